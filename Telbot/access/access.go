@@ -0,0 +1,290 @@
+package access
+
+import (
+	"Telbot/metrics"
+	"encoding/csv"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Who may use the bot: when users or chats are allowlisted, only those
+// users, and everyone inside those chats, get through. Banned users never
+// do. Within a chat, people have a role deciding whether they may run
+// commands that change or delete shared records.
+
+const accessFile = "access.csv"
+
+type Role int
+
+const (
+	RoleMember Role = iota
+	RoleAdmin
+	RoleOwner
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleOwner:
+		return "owner"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "member"
+	}
+}
+
+// Entry statuses in the access file
+const (
+	statusAllowed = "allowed"
+	statusAdmin   = "admin"
+	statusBanned  = "banned"
+)
+
+// memberCacheTime is how long a role looked up from Telegram is reused.
+const memberCacheTime = 10 * time.Minute
+
+type entry struct {
+	Kind   string // "user" or "chat"
+	ID     int64
+	Status string
+}
+
+type cachedRole struct {
+	Role    Role
+	Fetched time.Time
+}
+
+var (
+	mu      sync.Mutex
+	owner   int
+	entries []entry
+	members = map[string]cachedRole{} // chatID:userID
+)
+
+// Load reads the access file and adds the IDs configured in the
+// environment: TELBOT_OWNER, TELBOT_ADMINS, TELBOT_ALLOWED_USERS and
+// TELBOT_ALLOWED_CHATS, each a comma separated list of IDs.
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := loadEntries(); err != nil {
+		return err
+	}
+
+	owner, _ = strconv.Atoi(strings.TrimSpace(os.Getenv("TELBOT_OWNER")))
+	for _, id := range parseIDs(os.Getenv("TELBOT_ADMINS")) {
+		setStatus("user", id, statusAdmin)
+	}
+	for _, id := range parseIDs(os.Getenv("TELBOT_ALLOWED_USERS")) {
+		if status("user", id) == "" {
+			setStatus("user", id, statusAllowed)
+		}
+	}
+	for _, id := range parseIDs(os.Getenv("TELBOT_ALLOWED_CHATS")) {
+		setStatus("chat", id, statusAllowed)
+	}
+
+	if owner == 0 {
+		slog.Warn("TELBOT_OWNER is not set, only the admins in the access file may administer the bot")
+	}
+	return saveEntries()
+}
+
+func parseIDs(list string) []int64 {
+	var ids []int64
+	for _, field := range strings.Split(list, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// status returns the status of a user or chat, empty when unlisted. The
+// caller must hold mu.
+func status(kind string, id int64) string {
+	for _, e := range entries {
+		if e.Kind == kind && e.ID == id {
+			return e.Status
+		}
+	}
+	return ""
+}
+
+// setStatus adds or updates an entry. The caller must hold mu.
+func setStatus(kind string, id int64, status string) {
+	for i := range entries {
+		if entries[i].Kind == kind && entries[i].ID == id {
+			entries[i].Status = status
+			return
+		}
+	}
+	entries = append(entries, entry{Kind: kind, ID: id, Status: status})
+}
+
+// restricted reports whether an allowlist is in place. Admins alone don't
+// make one. The caller must hold mu.
+func restricted() bool {
+	for _, e := range entries {
+		if e.Status == statusAllowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the user may use the bot in the chat.
+func Allowed(u *telebot.User, chat *telebot.Chat) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if u == nil {
+		return false
+	}
+	if u.ID == owner {
+		return true
+	}
+	userStatus := status("user", int64(u.ID))
+	if userStatus == statusBanned || status("chat", chat.ID) == statusBanned {
+		return false
+	}
+	if !restricted() {
+		return true
+	}
+	return userStatus == statusAllowed || userStatus == statusAdmin || status("chat", chat.ID) == statusAllowed
+}
+
+// IsBotAdmin reports whether the user administers the bot itself: the
+// configured owner or an admin of the access file. Chat roles don't count,
+// anyone can create a group of their own.
+func IsBotAdmin(u *telebot.User) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if u == nil {
+		return false
+	}
+	return owner != 0 && u.ID == owner || status("user", int64(u.ID)) == statusAdmin
+}
+
+// RoleOf returns the role of the user in the chat. The bot owner and bot
+// admins keep their role everywhere, otherwise the chat creator is an
+// owner and chat administrators are admins.
+func RoleOf(bot *telebot.Bot, u *telebot.User, chat *telebot.Chat) Role {
+	mu.Lock()
+	switch {
+	case owner != 0 && u.ID == owner:
+		mu.Unlock()
+		return RoleOwner
+	case status("user", int64(u.ID)) == statusAdmin:
+		mu.Unlock()
+		return RoleAdmin
+	}
+
+	key := fmt.Sprintf("%d:%d", chat.ID, u.ID)
+	cached, ok := members[key]
+	mu.Unlock()
+	if chat.Type == telebot.ChatPrivate {
+		return RoleMember
+	}
+	if ok && time.Since(cached.Fetched) < memberCacheTime {
+		return cached.Role
+	}
+
+	role := RoleMember
+	if member, err := bot.ChatMemberOf(chat, u); err == nil {
+		switch member.Role {
+		case telebot.Creator:
+			role = RoleOwner
+		case telebot.Administrator:
+			role = RoleAdmin
+		}
+	}
+
+	mu.Lock()
+	members[key] = cachedRole{Role: role, Fetched: time.Now()}
+	mu.Unlock()
+	return role
+}
+
+func loadEntries() error {
+	defer metrics.StoreDuration.Time("access", "read")()
+
+	entries = nil
+
+	file, err := os.Open(accessFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", accessFile, "line", line,
+				"err", fmt.Errorf("%d fields, want 3", len(record)))
+			continue
+		}
+
+		id, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", accessFile, "line", line, "err", err)
+			continue
+		}
+		entries = append(entries, entry{Kind: record[0], ID: id, Status: record[2]})
+	}
+	return nil
+}
+
+// saveEntries rewrites the access file. The caller must hold mu.
+func saveEntries() error {
+	defer metrics.StoreDuration.Time("access", "write")()
+
+	file, err := os.Create(accessFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	for _, e := range entries {
+		if err := writer.Write([]string{e.Kind, strconv.FormatInt(e.ID, 10), e.Status}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the access list, waiting for any change in progress.
+func Flush() (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := saveEntries(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d access entries", len(entries)), nil
+}
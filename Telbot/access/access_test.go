@@ -0,0 +1,84 @@
+package access
+
+import (
+	"github.com/tucnak/telebot"
+	"testing"
+)
+
+func withEntries(t *testing.T, ownerID int, list ...entry) {
+	oldOwner, oldEntries := owner, entries
+	owner, entries = ownerID, list
+	t.Cleanup(func() { owner, entries = oldOwner, oldEntries })
+}
+
+func TestAllowed(t *testing.T) {
+	private := &telebot.Chat{ID: 5, Type: telebot.ChatPrivate}
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatGroup}
+	user := func(id int) *telebot.User { return &telebot.User{ID: id} }
+
+	withEntries(t, 1, entry{"user", 3, statusBanned})
+	if !Allowed(user(2), private) || Allowed(user(3), private) {
+		t.Error("without an allowlist everyone but banned users should be allowed")
+	}
+
+	withEntries(t, 1, entry{"user", 2, statusAdmin})
+	if !Allowed(user(4), private) || !Allowed(user(2), private) {
+		t.Error("admins alone should not make an allowlist")
+	}
+
+	withEntries(t, 1,
+		entry{"user", 2, statusAllowed},
+		entry{"user", 3, statusBanned},
+		entry{"chat", -100, statusAllowed},
+	)
+	cases := []struct {
+		user int
+		chat *telebot.Chat
+		want bool
+	}{
+		{1, private, true}, // Owner
+		{2, private, true},
+		{4, private, false},
+		{4, group, true}, // Member of an allowed chat
+		{3, group, false},
+	}
+	for _, c := range cases {
+		if got := Allowed(user(c.user), c.chat); got != c.want {
+			t.Errorf("Allowed(%d, chat %d) = %v, want %v", c.user, c.chat.ID, got, c.want)
+		}
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	private := &telebot.Chat{ID: 5, Type: telebot.ChatPrivate}
+
+	withEntries(t, 0)
+	if RoleOf(nil, &telebot.User{ID: 9}, private) != RoleMember {
+		t.Error("without an owner nobody should be an admin")
+	}
+
+	withEntries(t, 1, entry{"user", 2, statusAdmin})
+	if RoleOf(nil, &telebot.User{ID: 1}, private) != RoleOwner {
+		t.Error("the owner should be owner")
+	}
+	if RoleOf(nil, &telebot.User{ID: 2}, private) != RoleAdmin {
+		t.Error("bot admins should be admins")
+	}
+	if RoleOf(nil, &telebot.User{ID: 3}, private) != RoleMember {
+		t.Error("others should be members in private chats")
+	}
+}
+
+func TestIsBotAdmin(t *testing.T) {
+	withEntries(t, 0)
+	if IsBotAdmin(&telebot.User{ID: 0}) || IsBotAdmin(&telebot.User{ID: 9}) {
+		t.Error("without an owner nobody should administer the bot")
+	}
+
+	withEntries(t, 1, entry{"user", 2, statusAdmin}, entry{"user", 3, statusAllowed})
+	for id, want := range map[int]bool{1: true, 2: true, 3: false, 4: false} {
+		if got := IsBotAdmin(&telebot.User{ID: id}); got != want {
+			t.Errorf("IsBotAdmin(%d) = %v, want %v", id, got, want)
+		}
+	}
+}
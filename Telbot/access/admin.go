@@ -0,0 +1,267 @@
+package access
+
+import (
+	"Telbot/router"
+	"Telbot/utils"
+	"errors"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminUsage = "Usage: /admin users\n/admin ban [user ID or @username]\n/admin unban [user ID or @username]\n" +
+	"/admin allow [user ID or @username]\n/admin allowChat [chat ID]\n/admin stats"
+
+// seenUser is someone who wrote to the bot since it started.
+type seenUser struct {
+	ID       int
+	Username string
+	Name     string
+	LastSeen time.Time
+	Commands int
+}
+
+var (
+	started  = time.Now()
+	seen     = map[int]*seenUser{}
+	handled  = map[string]int{} // Commands handled per name
+	refusals int
+)
+
+// Middleware turns away users who aren't allowed in the chat, and anyone
+// but the bot's owner and admins running a command marked Admin.
+func Middleware(bot *telebot.Bot) router.Middleware {
+	return func(cmd *router.Command, next router.Handler) router.Handler {
+		return func(m *telebot.Message) {
+			track(cmd, m.Sender)
+
+			if !Allowed(m.Sender, m.Chat) {
+				countRefusal()
+				// Plain text in a group isn't necessarily meant for the bot
+				if cmd.Name != telebot.OnText {
+					utils.Send(bot, m.Chat, "Sorry, you are not allowed to use this bot.")
+				}
+				return
+			}
+			if cmd.Admin && !IsBotAdmin(m.Sender) {
+				countRefusal()
+				utils.Send(bot, m.Chat, fmt.Sprintf("Only the bot's admins can use %s.", cmd.Name))
+				return
+			}
+			next(m)
+		}
+	}
+}
+
+// CanChange reports whether the user may change or delete shared records
+// in the chat, for buttons that act like an Admin command.
+func CanChange(bot *telebot.Bot, u *telebot.User, chat *telebot.Chat) bool {
+	return Allowed(u, chat) && RoleOf(bot, u, chat) >= RoleAdmin
+}
+
+func track(cmd *router.Command, u *telebot.User) {
+	if u == nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := seen[u.ID]
+	if !ok {
+		s = &seenUser{ID: u.ID}
+		seen[u.ID] = s
+	}
+	s.Username = u.Username
+	s.Name = strings.TrimSpace(u.FirstName + " " + u.LastName)
+	s.LastSeen = time.Now()
+	if cmd.Name != telebot.OnText {
+		s.Commands++
+		handled[cmd.Name]++
+	}
+}
+
+func countRefusal() {
+	mu.Lock()
+	defer mu.Unlock()
+	refusals++
+}
+
+// RegisterAdminCommands adds /admin. stats returns extra lines for
+// /admin stats, e.g. how many records each store holds.
+func RegisterAdminCommands(r *router.Router, stats func() string) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:        "/admin",
+		Group:       "General",
+		Description: "Manage who may use the bot",
+		Args:        []router.Arg{{Name: "users|ban|unban|allow|allowChat|stats"}, {Name: "ID", Optional: true}},
+		Note:        adminUsage,
+		Admin:       true,
+		Handler: func(m *telebot.Message) {
+			args := strings.Fields(m.Payload)
+
+			var reply string
+			var err error
+			switch {
+			case args[0] == "users":
+				reply = usersReport()
+			case args[0] == "stats":
+				reply = statsReport()
+				if stats != nil {
+					reply += stats()
+				}
+			case args[0] == "ban" && len(args) == 2:
+				reply, err = changeUser(m.Sender, args[1], statusBanned)
+			case args[0] == "unban" && len(args) == 2:
+				reply, err = changeUser(m.Sender, args[1], "")
+			case args[0] == "allow" && len(args) == 2:
+				reply, err = changeUser(m.Sender, args[1], statusAllowed)
+			case args[0] == "allowChat" && len(args) == 2:
+				reply, err = allowChat(args[1])
+			default:
+				reply = adminUsage
+			}
+
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Send(bot, m.Chat, reply)
+		},
+	})
+}
+
+// resolveUser finds a user by ID or by the @username they wrote with.
+// The caller must hold mu.
+func resolveUser(arg string) (int64, error) {
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return id, nil
+	}
+	name := strings.ToLower(strings.TrimPrefix(arg, "@"))
+	for _, s := range seen {
+		if strings.ToLower(s.Username) == name {
+			return int64(s.ID), nil
+		}
+	}
+	return 0, fmt.Errorf("haven't seen %s yet, use their user ID instead", arg)
+}
+
+func changeUser(by *telebot.User, arg, newStatus string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id, err := resolveUser(arg)
+	if err != nil {
+		return "", err
+	}
+	if id == int64(owner) || id == int64(by.ID) {
+		return "", fmt.Errorf("you can't change the access of %s", arg)
+	}
+	if status("user", id) == statusAdmin && by.ID != owner {
+		return "", errors.New("only the owner can change the access of another admin")
+	}
+
+	if newStatus == "" {
+		entries = removeEntry(entries, "user", id)
+	} else {
+		setStatus("user", id, newStatus)
+	}
+	if err := saveEntries(); err != nil {
+		return "", errors.New("failed to save the access list")
+	}
+
+	switch newStatus {
+	case statusBanned:
+		return fmt.Sprintf("Banned %s.", arg), nil
+	case statusAllowed:
+		return fmt.Sprintf("%s may now use the bot.", arg), nil
+	default:
+		return fmt.Sprintf("Removed %s from the access list.", arg), nil
+	}
+}
+
+func allowChat(arg string) (string, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return "", errors.New("please provide the numeric chat ID")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	setStatus("chat", id, statusAllowed)
+	if err := saveEntries(); err != nil {
+		return "", errors.New("failed to save the access list")
+	}
+	return fmt.Sprintf("Everyone in chat %d may now use the bot.", id), nil
+}
+
+func removeEntry(list []entry, kind string, id int64) []entry {
+	var result []entry
+	for _, e := range list {
+		if e.Kind != kind || e.ID != id {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func usersReport() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	reply := "Access list:\n"
+	if owner != 0 {
+		reply += fmt.Sprintf("user %d: owner\n", owner)
+	}
+	for _, e := range entries {
+		reply += fmt.Sprintf("%s %d: %s\n", e.Kind, e.ID, e.Status)
+	}
+	if !restricted() {
+		reply += "No allowlist, everyone who isn't banned may use the bot.\n"
+	}
+
+	var users []*seenUser
+	for _, s := range seen {
+		users = append(users, s)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].LastSeen.After(users[j].LastSeen) })
+
+	reply += "\nSeen since start:\n"
+	for _, s := range users {
+		name := s.Name
+		if s.Username != "" {
+			name += " @" + s.Username
+		}
+		reply += fmt.Sprintf("%d %s, %d commands, last %s\n", s.ID, name, s.Commands, s.LastSeen.Format("2006-01-02 15:04"))
+	}
+	return reply
+}
+
+func statsReport() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	total := 0
+	var names []string
+	for name, count := range handled {
+		names = append(names, name)
+		total += count
+	}
+	sort.Slice(names, func(i, j int) bool { return handled[names[i]] > handled[names[j]] })
+
+	reply := fmt.Sprintf("Up since %s.\nUsers seen: %d\nCommands handled: %d\nRefused: %d\n",
+		started.Format("2006-01-02 15:04"), len(seen), total, refusals)
+	for i, name := range names {
+		if i == 5 {
+			break
+		}
+		reply += fmt.Sprintf("%s: %d\n", name, handled[name])
+	}
+	return reply
+}
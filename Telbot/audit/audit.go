@@ -0,0 +1,56 @@
+// Package audit keeps an append-only log of every change to purchases,
+// budgets and debts, one JSON object per line.
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// File is where entries are appended.
+var File = "audit.log"
+
+// Entry is one change. Before and After hold the affected state, either may
+// be empty for records that were created or deleted.
+type Entry struct {
+	Time    time.Time   `json:"time"`
+	Actor   int         `json:"actor"`  // Telegram user ID, 0 for the bot itself
+	Action  string      `json:"action"` // e.g. "purchase.create", "debt.repay"
+	Subject string      `json:"subject,omitempty"`
+	Before  interface{} `json:"before,omitempty"`
+	After   interface{} `json:"after,omitempty"`
+	Detail  interface{} `json:"detail,omitempty"`
+}
+
+var mu sync.Mutex
+
+// Record appends the entry. The change itself has already happened, so a
+// failure is logged rather than returned.
+func Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode audit entry", "action", e.Action, "err", err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	file, err := os.OpenFile(File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("failed to open audit log", "err", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		slog.Error("failed to write audit entry", "action", e.Action, "err", err)
+	}
+}
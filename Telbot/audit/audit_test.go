@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAppends(t *testing.T) {
+	old := File
+	File = filepath.Join(t.TempDir(), "audit.log")
+	t.Cleanup(func() { File = old })
+
+	Record(Entry{Actor: 1, Action: "purchase.create", Subject: "coffee", After: map[string]int{"amount": 35000}})
+	Record(Entry{Actor: 1, Action: "debt.repay", Subject: "Nam", Before: 200000, After: 150000})
+
+	file, err := os.Open(File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 2 || entries[0].Action != "purchase.create" || entries[1].Action != "debt.repay" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries[1].Before != float64(200000) || entries[1].Time.IsZero() {
+		t.Errorf("second entry = %+v", entries[1])
+	}
+}
@@ -0,0 +1,250 @@
+// Package chart renders spending charts as PNG images with gonum/plot, so
+// they can be sent to Telegram as photos.
+package chart
+
+import (
+	"Telbot/utils"
+	"fmt"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"image/color"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+)
+
+// Size of the images, 960x576 pixels at the default 96 DPI.
+const (
+	Width  = 10 * vg.Inch
+	Height = 6 * vg.Inch
+)
+
+// MaxSlices is the number of slices of a pie, or lines of a line chart,
+// before the smallest are grouped as "others".
+const MaxSlices = 8
+
+// Pie saves a pie chart of the shares, e.g. percentages per target, to a
+// PNG file.
+func Pie(filename, title string, shares map[string]float64) error {
+	names, values := top(shares)
+	if len(names) == 0 {
+		return fmt.Errorf("nothing to chart")
+	}
+
+	p := plot.New()
+	p.Title.Text = title
+	p.HideAxes()
+	p.Legend.Top = true
+
+	pie := pie{values: values}
+	p.Add(pie)
+	for i, name := range names {
+		p.Legend.Add(fmt.Sprintf("%s (%.1f%%)", name, pie.share(i)*100), swatch{plotutil.Color(i)})
+	}
+	return p.Save(Width, Height, filename)
+}
+
+// Bars saves a bar chart of amounts, e.g. per day, to a PNG file. Only
+// some labels are shown when there are many bars.
+func Bars(filename, title string, labels []string, amounts []float64) error {
+	if len(amounts) == 0 {
+		return fmt.Errorf("nothing to chart")
+	}
+
+	p := plot.New()
+	p.Title.Text = title
+	p.Y.Tick.Marker = amountTicks
+	p.Y.Min = 0
+
+	bars, err := plotter.NewBarChart(plotter.Values(amounts), barWidth(len(amounts)))
+	if err != nil {
+		return err
+	}
+	bars.Color = plotutil.Color(0)
+	bars.LineStyle.Width = 0
+	p.Add(bars)
+	p.NominalX(sparse(labels, 12)...)
+	return p.Save(Width, Height, filename)
+}
+
+// Lines saves a line chart with one line per series, e.g. the spending per
+// target in each month, to a PNG file. Every series has one amount per
+// label.
+func Lines(filename, title string, labels []string, series map[string][]float64) error {
+	totals, charted := map[string]float64{}, 0
+	for name, amounts := range series {
+		for _, amount := range amounts {
+			totals[name] += amount
+		}
+		if totals[name] > 0 {
+			charted++
+		}
+	}
+	names, _ := top(totals)
+	if len(names) == 0 {
+		return fmt.Errorf("nothing to chart")
+	}
+
+	// The series beyond MaxSlices are added up as "others"
+	if charted > MaxSlices {
+		kept := names[:len(names)-1]
+		others := make([]float64, len(labels))
+		for name, amounts := range series {
+			if !slices.Contains(kept, name) {
+				for i := 0; i < len(others) && i < len(amounts); i++ {
+					others[i] += amounts[i]
+				}
+			}
+		}
+		series = maps.Clone(series)
+		series["others"] = others
+	}
+
+	p := plot.New()
+	p.Title.Text = title
+	p.Y.Tick.Marker = amountTicks
+	p.Y.Min = 0
+	p.Legend.Top = true
+	p.Legend.Left = true
+
+	var lines []interface{}
+	for _, name := range names {
+		amounts := series[name]
+		points := make(plotter.XYs, len(labels))
+		for i := range points {
+			points[i].X = float64(i)
+			if i < len(amounts) {
+				points[i].Y = amounts[i]
+			}
+		}
+		lines = append(lines, name, points)
+	}
+	if err := plotutil.AddLinePoints(p, lines...); err != nil {
+		return err
+	}
+	p.NominalX(labels...)
+	return p.Save(Width, Height, filename)
+}
+
+// top sorts the values from largest to smallest and adds up those beyond
+// MaxSlices as "others".
+func top(values map[string]float64) ([]string, []float64) {
+	var names []string
+	for name, value := range values {
+		if value > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if values[names[i]] != values[names[j]] {
+			return values[names[i]] > values[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var sorted []float64
+	for _, name := range names {
+		sorted = append(sorted, values[name])
+	}
+	if len(names) <= MaxSlices {
+		return names, sorted
+	}
+
+	others := 0.0
+	for _, value := range sorted[MaxSlices-1:] {
+		others += value
+	}
+	return append(names[:MaxSlices-1], "others"), append(sorted[:MaxSlices-1], others)
+}
+
+// sparse keeps about n of the labels, blanking the others.
+func sparse(labels []string, n int) []string {
+	step := (len(labels) + n - 1) / n
+	if step <= 1 {
+		return labels
+	}
+	kept := make([]string, len(labels))
+	for i := 0; i < len(labels); i += step {
+		kept[i] = labels[i]
+	}
+	return kept
+}
+
+// barWidth fits the bars in the chart with a small gap between them.
+func barWidth(n int) vg.Length {
+	width := (Width - vg.Inch) / vg.Length(n) * 0.8
+	return vg.Length(math.Max(float64(width), 1))
+}
+
+// amountTicks labels the amount axis like the replies do, e.g. 1M and 500K.
+var amountTicks = plot.TickerFunc(func(min, max float64) []plot.Tick {
+	ticks := plot.DefaultTicks{}.Ticks(min, max)
+	for i := range ticks {
+		if ticks[i].Label != "" {
+			ticks[i].Label = utils.FormatNumber(int(ticks[i].Value))
+		}
+	}
+	return ticks
+})
+
+// pie draws the slices clockwise from the top, labelling those large
+// enough with their share.
+type pie struct {
+	values []float64
+}
+
+func (p pie) share(i int) float64 {
+	total := 0.0
+	for _, value := range p.values {
+		total += value
+	}
+	return p.values[i] / total
+}
+
+func (p pie) Plot(c draw.Canvas, plt *plot.Plot) {
+	center := c.Center()
+	radius := vg.Length(math.Min(float64(c.Max.X-c.Min.X), float64(c.Max.Y-c.Min.Y))) / 2 * 0.9
+
+	label := plt.Legend.TextStyle
+	label.Color = color.White
+	label.XAlign = draw.XCenter
+	label.YAlign = draw.YCenter
+
+	start := math.Pi / 2
+	for i := range p.values {
+		sweep := -p.share(i) * 2 * math.Pi
+
+		var wedge vg.Path
+		wedge.Move(center)
+		wedge.Arc(center, radius, start, sweep)
+		wedge.Close()
+		c.SetColor(plotutil.Color(i))
+		c.Fill(wedge)
+
+		if p.share(i) >= 0.05 {
+			middle := start + sweep/2
+			at := vg.Point{
+				X: center.X + radius*0.65*vg.Length(math.Cos(middle)),
+				Y: center.Y + radius*0.65*vg.Length(math.Sin(middle)),
+			}
+			c.FillText(label, at, fmt.Sprintf("%.0f%%", p.share(i)*100))
+		}
+		start += sweep
+	}
+}
+
+// swatch is the legend entry of a slice.
+type swatch struct{ color.Color }
+
+func (s swatch) Thumbnail(c *draw.Canvas) {
+	c.FillPolygon(s.Color, []vg.Point{
+		{X: c.Min.X, Y: c.Min.Y},
+		{X: c.Min.X, Y: c.Max.Y},
+		{X: c.Max.X, Y: c.Max.Y},
+		{X: c.Max.X, Y: c.Min.Y},
+	})
+}
@@ -0,0 +1,58 @@
+package chart
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestCharts(t *testing.T) {
+	dir := t.TempDir()
+	labels := []string{"10/2024", "11/2024", "12/2024"}
+	draw := map[string]func(filename string) error{
+		"pie.png": func(filename string) error {
+			return Pie(filename, "Share", map[string]float64{"coffee": 25, "food": 70, "books": 5})
+		},
+		"bars.png": func(filename string) error {
+			return Bars(filename, "Per day", labels, []float64{35000, 0, 1500000})
+		},
+		"lines.png": func(filename string) error {
+			return Lines(filename, "Per month", labels, map[string][]float64{"coffee": {35000, 70000, 0}, "food": {0, 0, 120000}})
+		},
+	}
+	for name, draw := range draw {
+		filename := filepath.Join(dir, name)
+		if err := draw(filename); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+			t.Errorf("%s is not a PNG", name)
+		}
+	}
+
+	if err := Pie(filepath.Join(dir, "empty.png"), "Share", map[string]float64{}); err == nil {
+		t.Error("Pie of nothing succeeded")
+	}
+}
+
+func TestTop(t *testing.T) {
+	values := map[string]float64{"none": 0}
+	for i := 1; i <= MaxSlices+2; i++ {
+		values["t"+strconv.Itoa(i)] = float64(i)
+	}
+
+	names, sorted := top(values)
+	if len(names) != MaxSlices || names[0] != "t10" || names[MaxSlices-1] != "others" {
+		t.Fatalf("top = %v", names)
+	}
+	// t1, t2 and t3 are grouped
+	if sorted[MaxSlices-1] != 6 {
+		t.Errorf("others = %v, want 6", sorted[MaxSlices-1])
+	}
+}
@@ -0,0 +1,54 @@
+// Package cli runs the commands of package core in a terminal, e.g. to
+// check budgets or record purchases without opening Telegram:
+//
+//	TELBOT_MODE=cli TELBOT_CLI_USER=<your Telegram ID> go run .
+package cli
+
+import (
+	"Telbot/core"
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Messenger prints replies.
+type Messenger struct {
+	W io.Writer
+}
+
+func (m Messenger) Send(chatID int64, text string) error {
+	_, err := fmt.Fprintln(m.W, strings.TrimRight(text, "\n"))
+	return err
+}
+
+// Run reads commands from in, one per line, until "quit" or the end of the
+// input. Commands run as the user, in a chat with the user's ID like a
+// private Telegram chat.
+func Run(in io.Reader, out io.Writer, userID int, username string) error {
+	m := Messenger{W: out}
+	req := core.Request{UserID: userID, Username: username, ChatID: int64(userID)}
+
+	fmt.Fprintln(out, "Type a command, e.g. /checkBudget, or help.")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+		case "quit", "exit":
+			return nil
+		case "help", "/help":
+			fmt.Fprint(out, core.Help())
+		default:
+			if err := core.Handle(m, req, line); err != nil {
+				return err
+			}
+		}
+	}
+}
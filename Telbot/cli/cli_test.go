@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"Telbot/core"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previous)
+	if err := core.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	script := strings.Join([]string{
+		"/setBudget 1M food month",
+		"purchase 800k food", // The slash is optional
+		"checkBudget",
+		"/addDebtor Nam 200k lunch",
+		"/listDebtors",
+		"/purchase abc",
+		"quit",
+		"/viewBudget", // Never runs
+	}, "\n")
+
+	var out strings.Builder
+	if err := Run(strings.NewReader(script), &out, 42, "lan"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Budget set for food: 1M every month.",
+		"Recorded purchase: 800000 for food.",
+		"Alert: You've spent 80.00% of your month budget for food.",
+		"food: Spent 800K of 1M (80.00%)",
+		"Updated Nam's debt to 200K.",
+		"Nam owes me 200K",
+		"Usage: /purchase [amount with K or M] [target]",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "Your Budgets") {
+		t.Error("ran a command after quit")
+	}
+}
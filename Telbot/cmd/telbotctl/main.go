@@ -0,0 +1,156 @@
+// Command telbotctl manages the bot's records from a terminal. It reads and
+// writes the same CSV files as the bot, in the directory given by -dir:
+//
+//	telbotctl -dir /srv/telbot purchases list -user 1001 -from 2024-12-01
+//	telbotctl -format json report targets -user 1001 -period week
+//	telbotctl budgets add -user 1001 -amount 2M -category food -period month
+//	telbotctl validate
+//
+// Purchases and budgets are read from disk on every command, so changes
+// show up in the running bot right away. The bot keeps the debt ledger in
+// memory though: stop it before changing debts, or it writes over them.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+const usage = `Usage: telbotctl [-dir DIR] [-format table|json|csv] COMMAND
+
+Commands:
+  purchases list|add|delete     Purchase records of every user
+  budgets list|add|delete       Budgets of every user
+  debts list|add|repay|delete   The debt ledger
+  report totals|targets|budgets|debts
+                                Any report, for any user and period
+  validate                      Look for malformed records in every file
+  repair                        Drop malformed records, keeping a .bak copy
+
+Run "telbotctl COMMAND ACTION -h" for the flags of an action.`
+
+// errUsage is returned when the arguments are wrong. The flag package
+// has already explained why.
+var errUsage = errors.New("usage")
+
+var formats = []string{"table", "json", "csv"}
+
+func main() {
+	// Only problems are worth a line on stderr, e.g. skipped records
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	err := run(os.Args[1:], os.Stdout)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "telbotctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("telbotctl", flag.ContinueOnError)
+	dir := flags.String("dir", ".", "data directory of the bot")
+	format := flags.String("format", "table", "output format: "+strings.Join(formats, ", "))
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), usage) }
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+	if !slices.Contains(formats, *format) {
+		return fmt.Errorf("unknown format %q, want one of %s", *format, strings.Join(formats, ", "))
+	}
+	if err := os.Chdir(*dir); err != nil {
+		return err
+	}
+
+	out := output{w: stdout, format: *format}
+	return dispatch(flags, out, flags.Args(), map[string]func(output, []string) error{
+		"purchases": purchases,
+		"budgets":   budgets,
+		"debts":     debts,
+		"report":    report,
+		"validate":  func(out output, args []string) error { return validate(out, args, false) },
+		"repair":    func(out output, args []string) error { return validate(out, args, true) },
+	})
+}
+
+// dispatch runs the command named by the first argument with the rest.
+func dispatch(parent *flag.FlagSet, out output, args []string, commands map[string]func(output, []string) error) error {
+	if len(args) == 0 {
+		parent.Usage()
+		return errUsage
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		parent.Usage()
+		return flag.ErrHelp
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		fmt.Fprintf(parent.Output(), "unknown command %q, want one of %s\n", args[0], strings.Join(names, ", "))
+		return errUsage
+	}
+	return command(out, args[1:])
+}
+
+// group runs an action of a command such as "purchases".
+func group(name string, out output, args []string, actions map[string]func(output, []string) error) error {
+	var names []string
+	for action := range actions {
+		names = append(names, action)
+	}
+	slices.Sort(names)
+
+	flags := flag.NewFlagSet("telbotctl "+name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: telbotctl %s %s [flags]\n", name, strings.Join(names, "|"))
+	}
+	return dispatch(flags, out, args, actions)
+}
+
+// parse parses the flags given to the command.
+func parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// newAction returns the flags of an action such as "purchases list".
+func newAction(name string, describe string) *flag.FlagSet {
+	flags := flag.NewFlagSet("telbotctl "+name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: telbotctl %s [flags]\n\n%s\n\n", name, describe)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseAction parses the flags of an action, which takes no other
+// arguments.
+func parseAction(flags *flag.FlagSet, args []string) error {
+	if err := parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "unexpected argument %q\n", flags.Arg(0))
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// ctl runs telbotctl on the directory and returns what it printed.
+func ctl(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previous) // run changes to the -dir
+
+	var out strings.Builder
+	err = run(append([]string{"-dir", dir}, args...), &out)
+	return out.String(), err
+}
+
+func TestRecordsAndReports(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"purchases", "add", "-user", "1001", "-amount", "35k", "-target", "coffee"},
+		{"purchases", "add", "-user", "1001", "-amount", "65k", "-target", "books"},
+		{"purchases", "add", "-user", "1002", "-amount", "1M", "-target", "rent"},
+		{"budgets", "add", "-user", "1001", "-amount", "200k", "-category", "coffee", "-period", "week"},
+	} {
+		if _, err := ctl(t, dir, args...); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	out, err := ctl(t, dir, "-format", "csv", "report", "targets", "-user", "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "target,total,share\nbooks,65000,65\ncoffee,35000,35\n"; out != want {
+		t.Errorf("targets report = %q, want %q", out, want)
+	}
+
+	out, err = ctl(t, dir, "-format", "json", "report", "budgets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `{"user": 1001, "category": "coffee", "period": "week", "amount": 200000, "spent": 35000, "used": 17.5}`) {
+		t.Errorf("budgets report = %s", out)
+	}
+
+	if _, err := ctl(t, dir, "purchases", "delete"); err == nil {
+		t.Error("deleted every purchase without a filter")
+	}
+	if _, err := ctl(t, dir, "purchases", "delete", "-user", "1002"); err != nil {
+		t.Fatal(err)
+	}
+	out, err = ctl(t, dir, "-format", "csv", "purchases", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "rent") || strings.Count(out, "\n") != 3 {
+		t.Errorf("after deleting the purchases of 1002:\n%s", out)
+	}
+}
+
+func TestValidateAndRepair(t *testing.T) {
+	dir := t.TempDir()
+	records := "1001,lan,35000,coffee,2024-12-01\n" +
+		"1001,lan,35k,coffee,2024-12-01\n" + // Amount written with a suffix
+		"1001,lan,50000,books,2024-12-02,note\n"
+	if err := os.WriteFile(dir+"/purchase_records.csv", []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ctl(t, dir, "-format", "csv", "validate")
+	if err == nil {
+		t.Error("validate passed with a malformed record")
+	}
+	if !strings.Contains(out, "purchase_records.csv,2,") {
+		t.Errorf("validate = %q", out)
+	}
+
+	if _, err := ctl(t, dir, "repair"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctl(t, dir, "validate"); err != nil {
+		t.Errorf("validate after repair: %v", err)
+	}
+	repaired, _ := os.ReadFile(dir + "/purchase_records.csv")
+	if want := "1001,lan,35000,coffee,2024-12-01\n1001,lan,50000,books,2024-12-02,note\n"; string(repaired) != want {
+		t.Errorf("repaired file = %q, want %q", repaired, want)
+	}
+	if backup, _ := os.ReadFile(dir + "/purchase_records.csv.bak"); string(backup) != records {
+		t.Errorf("backup = %q", backup)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"Telbot/export"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// output prints results in the format picked with -format.
+type output struct {
+	w      io.Writer
+	format string
+}
+
+// table prints rows of values under the columns: aligned for a terminal,
+// or like /export does for JSON and CSV.
+func (o output) table(columns []string, rows [][]interface{}) error {
+	t := export.Table{Columns: columns, Rows: rows}
+	switch o.format {
+	case "json":
+		return export.WriteJSON(o.w, t)
+	case "csv":
+		return export.WriteCSV(o.w, t)
+	}
+
+	writer := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		fields := make([]string, len(row))
+		for i, value := range row {
+			fields[i] = fmt.Sprint(value)
+		}
+		fmt.Fprintln(writer, strings.Join(fields, "\t"))
+	}
+	return writer.Flush()
+}
+
+// message prints the reply of a change, e.g. "Budget set for food".
+func (o output) message(text string) error {
+	_, err := fmt.Fprintln(o.w, strings.TrimSpace(text))
+	return err
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"Telbot/debt"
+	"Telbot/purchase"
+	"Telbot/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// actor is the user ID recorded in the audit log for changes made here,
+// the same as changes made by the bot itself.
+const actor = 0
+
+var periods = []string{"week", "month", "year"}
+
+var directions = []string{debt.DirectionOwedToMe, debt.DirectionIOwe}
+
+func purchases(out output, args []string) error {
+	return group("purchases", out, args, map[string]func(output, []string) error{
+		"list":   listPurchases,
+		"add":    addPurchase,
+		"delete": deletePurchases,
+	})
+}
+
+// purchaseFilter selects purchases by the flags of list and delete.
+type purchaseFilter struct {
+	user             int
+	account, target  string
+	from, to         string
+	fromDate, toDate time.Time
+}
+
+func (f *purchaseFilter) register(flags *flag.FlagSet) {
+	flags.IntVar(&f.user, "user", 0, "Telegram ID of the user")
+	flags.StringVar(&f.account, "account", "", "account name")
+	flags.StringVar(&f.target, "target", "", "target, ignoring case")
+	flags.StringVar(&f.from, "from", "", "first day, YYYY-MM-DD")
+	flags.StringVar(&f.to, "to", "", "last day, YYYY-MM-DD")
+}
+
+// parse checks the dates, it must be called after the flags are parsed.
+func (f *purchaseFilter) parse() error {
+	var errs []error
+	if f.from != "" {
+		var err error
+		f.fromDate, err = time.Parse("2006-01-02", f.from)
+		errs = append(errs, err)
+	}
+	if f.to != "" {
+		var err error
+		f.toDate, err = time.Parse("2006-01-02", f.to)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (f *purchaseFilter) empty() bool {
+	return *f == purchaseFilter{}
+}
+
+func (f *purchaseFilter) match(p purchase.Purchase) bool {
+	return (f.user == 0 || p.IDTele == f.user) &&
+		(f.account == "" || strings.EqualFold(p.AccountName, f.account)) &&
+		(f.target == "" || strings.EqualFold(p.Target, f.target)) &&
+		(f.fromDate.IsZero() || !p.CreatedTime.Before(f.fromDate)) &&
+		(f.toDate.IsZero() || !p.CreatedTime.After(f.toDate))
+}
+
+var purchaseColumns = []string{"user", "account", "date", "amount", "target", "note"}
+
+func purchaseRows(purchases []purchase.Purchase) [][]interface{} {
+	var rows [][]interface{}
+	for _, p := range purchases {
+		rows = append(rows, []interface{}{p.IDTele, p.AccountName, p.CreatedTime.Format("2006-01-02"), p.Amount, p.Target, p.Note})
+	}
+	return rows
+}
+
+func listPurchases(out output, args []string) error {
+	flags := newAction("purchases list", "Lists the purchases matching every flag given.")
+	var filter purchaseFilter
+	filter.register(flags)
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	if err := filter.parse(); err != nil {
+		return err
+	}
+
+	all, err := purchase.AllPurchases()
+	if err != nil {
+		return err
+	}
+	var matching []purchase.Purchase
+	for _, p := range all {
+		if filter.match(p) {
+			matching = append(matching, p)
+		}
+	}
+	return out.table(purchaseColumns, purchaseRows(matching))
+}
+
+func addPurchase(out output, args []string) error {
+	flags := newAction("purchases add", "Records a purchase, matching the target to one of the user's categories.")
+	user := flags.Int("user", 0, "Telegram ID of the user (required)")
+	account := flags.String("account", "", "account name")
+	amount := flags.String("amount", "", "amount with K or M, e.g. 35K (required)")
+	target := flags.String("target", "", "what was bought (required)")
+	date := flags.String("date", time.Now().Format("2006-01-02"), "day of the purchase, YYYY-MM-DD")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+
+	if *user == 0 || *amount == "" || *target == "" {
+		return fmt.Errorf("-user, -amount and -target are required")
+	}
+	value, err := utils.ParseAmount(*amount)
+	if err != nil {
+		return err
+	}
+	day, err := time.Parse("2006-01-02", *date)
+	if err != nil {
+		return err
+	}
+
+	reply, err := purchase.RecordPurchase(*user, *account, value, *target, day)
+	if err != nil {
+		return err
+	}
+	return out.message(reply)
+}
+
+func deletePurchases(out output, args []string) error {
+	flags := newAction("purchases delete", "Deletes the purchases matching every flag given, and lists them.")
+	var filter purchaseFilter
+	filter.register(flags)
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	if err := filter.parse(); err != nil {
+		return err
+	}
+	if filter.empty() {
+		return fmt.Errorf("give at least one flag, deleting every purchase is not allowed")
+	}
+
+	deleted, err := purchase.DeletePurchases(actor, filter.match)
+	if err != nil {
+		return err
+	}
+	return out.table(purchaseColumns, purchaseRows(deleted))
+}
+
+func budgets(out output, args []string) error {
+	return group("budgets", out, args, map[string]func(output, []string) error{
+		"list":   listBudgets,
+		"add":    addBudget,
+		"delete": deleteBudgets,
+	})
+}
+
+var budgetColumns = []string{"user", "category", "amount", "period", "threshold"}
+
+func budgetRows(budgets []purchase.Budget) [][]interface{} {
+	var rows [][]interface{}
+	for _, b := range budgets {
+		rows = append(rows, []interface{}{b.IDTele, b.Category, b.Amount, b.Duration, b.Threshold})
+	}
+	return rows
+}
+
+// budgetMatch selects budgets by user and category, either may be left out.
+func budgetMatch(user int, category string) func(purchase.Budget) bool {
+	return func(b purchase.Budget) bool {
+		return (user == 0 || b.IDTele == user) && (category == "" || strings.EqualFold(b.Category, category))
+	}
+}
+
+func listBudgets(out output, args []string) error {
+	flags := newAction("budgets list", "Lists the budgets matching every flag given.")
+	user := flags.Int("user", 0, "Telegram ID of the user")
+	category := flags.String("category", "", "category, ignoring case")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+
+	all, err := purchase.AllBudgets()
+	if err != nil {
+		return err
+	}
+	var matching []purchase.Budget
+	for _, b := range all {
+		if budgetMatch(*user, *category)(b) {
+			matching = append(matching, b)
+		}
+	}
+	return out.table(budgetColumns, budgetRows(matching))
+}
+
+func addBudget(out output, args []string) error {
+	flags := newAction("budgets add", "Sets the budget of a user for a category.")
+	user := flags.Int("user", 0, "Telegram ID of the user (required)")
+	amount := flags.String("amount", "", "amount with K or M, e.g. 2M (required)")
+	category := flags.String("category", "", "category (required)")
+	period := flags.String("period", "month", "how often the budget resets: "+strings.Join(periods, ", "))
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+
+	if *user == 0 || *amount == "" || *category == "" {
+		return fmt.Errorf("-user, -amount and -category are required")
+	}
+	value, err := utils.ParseAmount(*amount)
+	if err != nil {
+		return err
+	}
+
+	reply, err := purchase.SetBudget(*user, value, *category, *period)
+	if err != nil {
+		return err
+	}
+	return out.message(reply)
+}
+
+func deleteBudgets(out output, args []string) error {
+	flags := newAction("budgets delete", "Deletes the budgets matching every flag given, and lists them.")
+	user := flags.Int("user", 0, "Telegram ID of the user")
+	category := flags.String("category", "", "category, ignoring case")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	if *user == 0 && *category == "" {
+		return fmt.Errorf("give -user, -category or both, deleting every budget is not allowed")
+	}
+
+	deleted, err := purchase.DeleteBudgets(actor, budgetMatch(*user, *category))
+	if err != nil {
+		return err
+	}
+	return out.table(budgetColumns, budgetRows(deleted))
+}
+
+func debts(out output, args []string) error {
+	if err := debt.LoadDebtRecords(); err != nil {
+		return err
+	}
+	return group("debts", out, args, map[string]func(output, []string) error{
+		"list":   listDebts,
+		"add":    addDebt,
+		"repay":  repayDebt,
+		"delete": deleteDebtor,
+	})
+}
+
+func listDebts(out output, args []string) error {
+	flags := newAction("debts list", "Lists the transactions of the ledger matching every flag given.")
+	name := flags.String("name", "", "debtor or lender, ignoring case")
+	direction := flags.String("direction", "", "who owes whom: "+strings.Join(directions, ", "))
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	if *direction != "" && !slices.Contains(directions, *direction) {
+		return fmt.Errorf("unknown direction %q, want one of %s", *direction, strings.Join(directions, ", "))
+	}
+
+	var rows [][]interface{}
+	for _, t := range debt.Transactions() {
+		if *name != "" && !strings.EqualFold(t.Name, *name) && !strings.EqualFold(t.Lender, *name) {
+			continue
+		}
+		if *direction != "" && t.Direction != *direction {
+			continue
+		}
+		due := ""
+		if !t.DueDate.IsZero() {
+			due = t.DueDate.Format("2006-01-02")
+		}
+		rows = append(rows, []interface{}{t.CreatedTime.Format("2006-01-02"), t.Name, t.Kind, t.Direction, t.Amount, due, t.Lender, t.InterestRate, t.Note})
+	}
+	return out.table([]string{"date", "name", "kind", "direction", "amount", "due", "lender", "interest", "note"}, rows)
+}
+
+func addDebt(out output, args []string) error {
+	flags := newAction("debts add", "Records a new debt.")
+	name := flags.String("name", "", "the other person (required)")
+	amount := flags.String("amount", "", "amount with K or M (required)")
+	direction := flags.String("direction", debt.DirectionOwedToMe, "who owes whom: "+strings.Join(directions, ", "))
+	terms := flags.String("terms", "", `due date, interest, fee and note, e.g. "2024-12-01 12% compound fee 50K laptop"`)
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	value, err := debtArgs(*name, *amount, *direction)
+	if err != nil {
+		return err
+	}
+
+	reply, err := debt.AddDebt(actor, *name, *direction, value, *terms, 0)
+	if err != nil {
+		return err
+	}
+	return out.message(reply)
+}
+
+func repayDebt(out output, args []string) error {
+	flags := newAction("debts repay", "Records a repayment.")
+	name := flags.String("name", "", "the other person (required)")
+	amount := flags.String("amount", "", "amount with K or M (required)")
+	direction := flags.String("direction", debt.DirectionOwedToMe, "who paid whom back, as for add: "+strings.Join(directions, ", "))
+	note := flags.String("note", "", "note")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	value, err := debtArgs(*name, *amount, *direction)
+	if err != nil {
+		return err
+	}
+
+	reply, err := debt.Repay(actor, *name, *direction, value, *note)
+	if err != nil {
+		return err
+	}
+	return out.message(reply)
+}
+
+// debtArgs checks the flags shared by add and repay, and parses the amount.
+func debtArgs(name, amount, direction string) (int, error) {
+	if name == "" || amount == "" {
+		return 0, fmt.Errorf("-name and -amount are required")
+	}
+	if !slices.Contains(directions, direction) {
+		return 0, fmt.Errorf("unknown direction %q, want one of %s", direction, strings.Join(directions, ", "))
+	}
+	return utils.ParseAmount(amount)
+}
+
+func deleteDebtor(out output, args []string) error {
+	flags := newAction("debts delete", "Deletes every transaction between me and a person.")
+	name := flags.String("name", "", "the other person (required)")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return fmt.Errorf("-name is required")
+	}
+	return out.message(debt.DeleteDebtor(actor, *name))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"Telbot/debt"
+	"Telbot/purchase"
+	"flag"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+func report(out output, args []string) error {
+	return group("report", out, args, map[string]func(output, []string) error{
+		"totals":  reportTotals,
+		"targets": reportTargets,
+		"budgets": reportBudgets,
+		"debts":   reportDebts,
+	})
+}
+
+// reportFlags adds -user and -period to the flags of a report.
+func reportFlags(name, describe string) (*flag.FlagSet, *int, *string) {
+	flags := newAction("report "+name, describe)
+	user := flags.Int("user", 0, "Telegram ID of the user, 0 for everyone")
+	period := flags.String("period", "", "last "+strings.Join(periods, ", "))
+	return flags, user, period
+}
+
+func checkPeriod(period string) error {
+	if !slices.Contains(periods, period) {
+		return fmt.Errorf("unknown period %q, want one of %s", period, strings.Join(periods, ", "))
+	}
+	return nil
+}
+
+// share is part of total in percent, rounded to two decimals.
+func share(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(total)*10000) / 100
+}
+
+func reportTotals(out output, args []string) error {
+	flags, user, period := reportFlags("totals", "Total spent in the last week, month and year, or in the -period.")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	wanted := periods
+	if *period != "" {
+		if err := checkPeriod(*period); err != nil {
+			return err
+		}
+		wanted = []string{*period}
+	}
+
+	var rows [][]interface{}
+	for _, p := range wanted {
+		byTarget, err := purchase.Spending(*user, p)
+		if err != nil {
+			return err
+		}
+		total := 0
+		for _, sum := range byTarget {
+			total += sum
+		}
+		rows = append(rows, []interface{}{p, total})
+	}
+	return out.table([]string{"period", "total"}, rows)
+}
+
+func reportTargets(out output, args []string) error {
+	flags, user, period := reportFlags("targets", "Total and share of each target, the largest first.")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	if *period == "" {
+		*period = "month"
+	}
+	if err := checkPeriod(*period); err != nil {
+		return err
+	}
+
+	byTarget, err := purchase.Spending(*user, *period)
+	if err != nil {
+		return err
+	}
+	targets, total := make([]string, 0, len(byTarget)), 0
+	for target, sum := range byTarget {
+		targets = append(targets, target)
+		total += sum
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if byTarget[targets[i]] != byTarget[targets[j]] {
+			return byTarget[targets[i]] > byTarget[targets[j]]
+		}
+		return targets[i] < targets[j]
+	})
+
+	var rows [][]interface{}
+	for _, target := range targets {
+		rows = append(rows, []interface{}{target, byTarget[target], share(byTarget[target], total)})
+	}
+	return out.table([]string{"target", "total", "share"}, rows)
+}
+
+func reportBudgets(out output, args []string) error {
+	flags, user, period := reportFlags("budgets", "How much of each budget is spent. Every budget covers its own period.")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+
+	budgets, err := purchase.AllBudgets()
+	if err != nil {
+		return err
+	}
+	var rows [][]interface{}
+	for _, b := range budgets {
+		if (*user != 0 && b.IDTele != *user) || (*period != "" && b.Duration != *period) {
+			continue
+		}
+		spent := purchase.CalculateSpent(b.IDTele, b.Category, b.Duration)
+		rows = append(rows, []interface{}{b.IDTele, b.Category, b.Duration, b.Amount, spent, share(spent, b.Amount)})
+	}
+	return out.table([]string{"user", "category", "period", "amount", "spent", "used"}, rows)
+}
+
+func reportDebts(out output, args []string) error {
+	flags := newAction("report debts", "What each person owes me and I owe them, interest and fees included.")
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+	if err := debt.LoadDebtRecords(); err != nil {
+		return err
+	}
+
+	owedToMe := debt.Balances(debt.DirectionOwedToMe)
+	iOwe := debt.Balances(debt.DirectionIOwe)
+	var names []string
+	for name, amount := range owedToMe {
+		if amount != 0 {
+			names = append(names, name)
+		}
+	}
+	for name, amount := range iOwe {
+		if amount != 0 && owedToMe[name] == 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var rows [][]interface{}
+	for _, name := range names {
+		rows = append(rows, []interface{}{name, owedToMe[name], iOwe[name], owedToMe[name] - iOwe[name]})
+	}
+	return out.table([]string{"name", "owed_to_me", "i_owe", "net"}, rows)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// check tells what is wrong with a field, or returns nil.
+type check func(value string) error
+
+func isInt(value string) error {
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err
+}
+
+func isFloat(value string) error {
+	_, err := strconv.ParseFloat(value, 64)
+	return err
+}
+
+func isDate(value string) error {
+	_, err := time.Parse("2006-01-02", value)
+	return err
+}
+
+func notEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("empty")
+	}
+	return nil
+}
+
+func oneOf(values ...string) check {
+	return func(value string) error {
+		if !slices.Contains(values, value) {
+			return fmt.Errorf("%q is not one of %s", value, strings.Join(values, ", "))
+		}
+		return nil
+	}
+}
+
+// optional lets the field be empty.
+func optional(c check) check {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+		return c(value)
+	}
+}
+
+// dataFile describes the records of a file the bot keeps, by column.
+type dataFile struct {
+	name     string
+	min, max int // Number of fields, older records may have fewer
+	columns  map[int]check
+}
+
+var dataFiles = []dataFile{
+	{name: "purchase_records.csv", min: 5, max: 6, columns: map[int]check{0: isInt, 2: isInt, 3: notEmpty, 4: isDate}},
+	{name: "budgets.csv", min: 5, max: 5, columns: map[int]check{0: isInt, 1: notEmpty, 2: isInt, 3: oneOf(periods...), 4: isFloat}},
+	{name: "categories.csv", min: 4, max: 4, columns: map[int]check{0: isInt, 1: notEmpty}},
+	{name: "import_mappings.csv", min: 2, max: 2, columns: map[int]check{0: isInt, 1: notEmpty}},
+	{name: "weekly_digest.csv", min: 2, max: 2, columns: map[int]check{0: isInt, 1: isDate}},
+	{name: "debt_transactions.csv", min: 5, max: 13, columns: map[int]check{
+		0: notEmpty, 1: oneOf("borrow", "repay"), 2: isInt, 3: isDate,
+		5: optional(oneOf(directions...)), 6: optional(isDate), 7: optional(isInt),
+		10: optional(isFloat), 11: optional(oneOf("simple", "compound")), 12: optional(isInt),
+	}},
+	{name: "debtor_identities.csv", min: 3, max: 3, columns: map[int]check{0: notEmpty, 1: isInt}},
+	{name: "debtors.csv", min: 2, max: 2, columns: map[int]check{0: notEmpty, 1: isInt}},
+	{name: "access.csv", min: 3, max: 3, columns: map[int]check{0: oneOf("user", "chat"), 1: isInt, 2: oneOf("allowed", "admin", "banned")}},
+}
+
+// problem is a malformed record.
+type problem struct {
+	file, reason string
+	line         int
+}
+
+// inspect reads the file and returns the well formed records and the
+// problems with the others. A missing file has neither.
+func (f dataFile) inspect() ([][]string, []problem, error) {
+	file, err := os.Open(f.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var records [][]string
+	var problems []problem
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// The reader carries on with the next line
+			problems = append(problems, problem{file: f.name, line: parseErr.StartLine, reason: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if reason := f.check(record); reason != "" {
+			problems = append(problems, problem{file: f.name, line: line, reason: reason})
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, problems, nil
+}
+
+func (f dataFile) check(record []string) string {
+	if len(record) < f.min || len(record) > f.max {
+		if f.min == f.max {
+			return fmt.Sprintf("%d fields, want %d", len(record), f.min)
+		}
+		return fmt.Sprintf("%d fields, want %d to %d", len(record), f.min, f.max)
+	}
+	var reasons []string
+	for i := range record {
+		if c, ok := f.columns[i]; ok {
+			if err := c(record[i]); err != nil {
+				reasons = append(reasons, fmt.Sprintf("field %d: %v", i+1, err))
+			}
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// repair rewrites the file with only the well formed records, after
+// copying the original to a .bak file.
+func (f dataFile) repair(records [][]string) error {
+	original, err := os.ReadFile(f.name)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.name+".bak", original, 0644); err != nil {
+		return err
+	}
+
+	tmp, err := os.Create(f.name + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(tmp)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.name+".tmp", f.name)
+}
+
+// validate lists the malformed records of every file, and with repair
+// drops them. Validating fails when there are any, so scripts can tell.
+func validate(out output, args []string, repair bool) error {
+	name, describe := "validate", "Lists the malformed records of every file the bot keeps."
+	if repair {
+		name, describe = "repair", "Drops the malformed records of every file the bot keeps and lists them. The originals are kept as .bak files."
+	}
+	flags := newAction(name, describe)
+	if err := parseAction(flags, args); err != nil {
+		return err
+	}
+
+	var rows [][]interface{}
+	for _, f := range dataFiles {
+		records, problems, err := f.inspect()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		if repair && len(problems) > 0 {
+			if err := f.repair(records); err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+		for _, p := range problems {
+			rows = append(rows, []interface{}{p.file, p.line, p.reason})
+		}
+	}
+
+	if err := out.table([]string{"file", "line", "problem"}, rows); err != nil {
+		return err
+	}
+	if !repair && len(rows) > 0 {
+		return fmt.Errorf("%d malformed records, run telbotctl repair to drop them", len(rows))
+	}
+	return nil
+}
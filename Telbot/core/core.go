@@ -0,0 +1,214 @@
+// Package core runs the bot's commands from plain text, so any transport
+// can offer them: Telegram (see package telegram) or a terminal (see
+// package cli).
+//
+// The commands here take their arguments as typed. Telegram additionally
+// registers richer handlers for some of them, with buttons, guided flows and
+// mentions, which call the same functions of packages purchase and debt.
+package core
+
+import (
+	"Telbot/debt"
+	"Telbot/purchase"
+	"Telbot/utils"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Messenger delivers replies to a chat.
+type Messenger interface {
+	Send(chatID int64, text string) error
+}
+
+// Request is one command sent by a user.
+type Request struct {
+	UserID   int
+	Username string
+	ChatID   int64
+	Args     string // Everything after the command name
+}
+
+// Command is a command any transport can run.
+type Command struct {
+	Name        string // e.g. "/checkBudget"
+	Group       string // Section of the help, e.g. "Budgets"
+	Description string
+	Usage       string // Arguments, e.g. "[amount] [target]"
+	Run         func(req Request) (string, error)
+}
+
+// Commands lists every command, in the order of the help.
+var Commands = []Command{
+	{Name: "/purchase", Group: "Purchases", Description: "Record a purchase", Usage: "[amount with K or M] [target]", Run: recordPurchase},
+
+	{Name: "/sumPurchases", Group: "Reports", Description: "Total spent in the last week, month and year",
+		Run: func(Request) (string, error) { return purchase.Totals() }},
+	{Name: "/targetSummary", Group: "Reports", Description: "Total and share per target", Usage: "[week|month|year]", Run: targetSummary},
+	{Name: "/compare", Group: "Reports", Description: "Spending per target against the period before and last year", Usage: "[week|month|year]",
+		Run: func(req Request) (string, error) {
+			return purchase.Compare(req.UserID, periodOrMonth(req.Args), time.Now())
+		}},
+
+	{Name: "/setBudget", Group: "Budgets", Description: "Set a budget for a category", Usage: "[amount with K or M] [category] [week|month|year]", Run: setBudget},
+	{Name: "/viewBudget", Group: "Budgets", Description: "List your budgets",
+		Run: func(req Request) (string, error) { return purchase.Budgets(req.UserID) }},
+	{Name: "/checkBudget", Group: "Budgets", Description: "Show how much of each budget is spent",
+		Run: func(req Request) (string, error) { return purchase.BudgetStatus(req.UserID) }},
+	{Name: "/forecast", Group: "Budgets", Description: "Show where each budget is heading by the end of the period",
+		Run: func(req Request) (string, error) { return purchase.Forecast(req.UserID, time.Now()) }},
+
+	{Name: "/addDebtor", Group: "Debts", Description: "Record money someone owes me", Usage: "[name] [amount] [due date, interest, fee, note]",
+		Run: addDebt(debt.DirectionOwedToMe)},
+	{Name: "/iOwe", Group: "Debts", Description: "Record money I owe someone", Usage: "[name] [amount] [due date, interest, fee, note]",
+		Run: addDebt(debt.DirectionIOwe)},
+	{Name: "/repay", Group: "Debts", Description: "Record a repayment to me", Usage: "[name] [amount] [note]", Run: repay(debt.DirectionOwedToMe)},
+	{Name: "/payBack", Group: "Debts", Description: "Record that I paid someone back", Usage: "[name] [amount] [note]", Run: repay(debt.DirectionIOwe)},
+	{Name: "/debtHistory", Group: "Debts", Description: "Show every transaction with a person", Usage: "[name]",
+		Run: func(req Request) (string, error) {
+			if strings.TrimSpace(req.Args) == "" {
+				return "", errors.New("missing name")
+			}
+			return debt.History(strings.TrimSpace(req.Args)), nil
+		}},
+	{Name: "/listDebtors", Group: "Debts", Description: "Show who owes what",
+		Run: func(Request) (string, error) { return debt.Summary(), nil }},
+}
+
+// Load reads the debt ledger, which is kept in memory. Purchases and
+// budgets are read by every command.
+func Load() error {
+	return debt.LoadDebtRecords()
+}
+
+// Find returns the command with the name, ignoring case, or nil.
+func Find(name string) *Command {
+	for i := range Commands {
+		if strings.EqualFold(Commands[i].Name, name) {
+			return &Commands[i]
+		}
+	}
+	return nil
+}
+
+// Handle runs a line such as "/purchase 35k coffee" and sends the reply.
+// The leading slash is optional.
+func Handle(m Messenger, req Request, line string) error {
+	name, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	cmd := Find(name)
+	if cmd == nil {
+		return m.Send(req.ChatID, fmt.Sprintf("Unknown command %s, try help.", name))
+	}
+
+	req.Args = strings.TrimSpace(args)
+	reply, err := cmd.Run(req)
+	if err != nil {
+		reply = utils.Sentence(err)
+		if cmd.Usage != "" {
+			reply += "\nUsage: " + cmd.Name + " " + cmd.Usage
+		}
+	}
+	return m.Send(req.ChatID, reply)
+}
+
+// Help lists the commands by group.
+func Help() string {
+	var help, group string
+	for _, cmd := range Commands {
+		if cmd.Group != group {
+			group = cmd.Group
+			help += "\n" + group + ":\n"
+		}
+		help += "  " + cmd.Name
+		if cmd.Usage != "" {
+			help += " " + cmd.Usage
+		}
+		help += " - " + cmd.Description + "\n"
+	}
+	return strings.TrimPrefix(help, "\n")
+}
+
+func recordPurchase(req Request) (string, error) {
+	amount, target, err := amountAndRest(req.Args)
+	if err != nil || target == "" {
+		return "", errors.New("please provide an amount and a target")
+	}
+	reply, err := purchase.RecordPurchase(req.UserID, req.Username, amount, target, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if alert, _ := purchase.CheckBudgetAlert(req.UserID); alert != "" {
+		reply += "\n" + alert
+	}
+	return reply, nil
+}
+
+func setBudget(req Request) (string, error) {
+	amount, rest, err := amountAndRest(req.Args)
+	words := strings.Fields(rest)
+	if err != nil || len(words) != 2 {
+		return "", errors.New("please provide an amount, a category and a period")
+	}
+	return purchase.SetBudget(req.UserID, amount, words[0], words[1])
+}
+
+func targetSummary(req Request) (string, error) {
+	period := periodOrMonth(req.Args)
+	if period != "week" && period != "month" && period != "year" {
+		return "", errors.New("please specify a valid period: week, month or year")
+	}
+	return purchase.TargetSummary(period)
+}
+
+// periodOrMonth is the period given, the last month by default.
+func periodOrMonth(args string) string {
+	if args == "" {
+		return "month"
+	}
+	return args
+}
+
+func addDebt(direction string) func(req Request) (string, error) {
+	return func(req Request) (string, error) {
+		name, amount, rest, err := nameAmountAndRest(req.Args)
+		if err != nil {
+			return "", err
+		}
+		return debt.AddDebt(req.UserID, name, direction, amount, rest, req.ChatID)
+	}
+}
+
+func repay(direction string) func(req Request) (string, error) {
+	return func(req Request) (string, error) {
+		name, amount, note, err := nameAmountAndRest(req.Args)
+		if err != nil {
+			return "", err
+		}
+		return debt.Repay(req.UserID, name, direction, amount, note)
+	}
+}
+
+// amountAndRest splits "35k coffee beans" into 35000 and "coffee beans".
+func amountAndRest(args string) (int, string, error) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	amount, err := utils.ParseAmount(first)
+	if err != nil {
+		return 0, "", err
+	}
+	return amount, strings.TrimSpace(rest), nil
+}
+
+// nameAmountAndRest splits "Nam 200K lunch" into the name, the amount and the
+// rest. Names are one word when typed, Telegram mentions can be longer.
+func nameAmountAndRest(args string) (string, int, string, error) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	amount, rest, err := amountAndRest(rest)
+	if name == "" || err != nil {
+		return "", 0, "", errors.New("please provide a name and a valid amount")
+	}
+	return name, amount, rest, nil
+}
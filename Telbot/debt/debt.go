@@ -1,133 +1,486 @@
 package debt
 
 import (
+	"Telbot/audit"
+	"Telbot/router"
 	"Telbot/utils"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"github.com/tucnak/telebot"
-	"os"
+	"log/slog"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-var debtorMap = make(map[string]int)
+func RegisterHandlers(r *router.Router) {
+	bot := r.Bot
+	if err := LoadDebtRecords(); err != nil {
+		slog.Error("failed to load debt records", "err", err)
+	}
 
-func LoadDebtRecords() error {
-	file, err := os.Open("debtors.csv")
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // Nếu file không tồn tại, không có lỗi
-		}
-		return err
+	debtArgs := []router.Arg{
+		{Name: "name", Kind: router.Name},
+		{Name: "amount with K for thousand or M for million", Kind: router.Amount},
+		{Name: "due date", Optional: true},
+		{Name: "12% simple|compound", Optional: true},
+		{Name: "fee 50K", Optional: true},
+		{Name: "note", Kind: router.Text, Optional: true},
+	}
+	repayArgs := []router.Arg{
+		{Name: "name", Kind: router.Name},
+		{Name: "amount with K or M", Kind: router.Amount},
+		{Name: "note", Kind: router.Text, Optional: true},
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			break
-		}
+	// Every new debt rewrites the ledger, a script flooding it is cut short
+	addLimit := router.Limit{N: 10, Per: time.Minute}
 
-		name := record[0]
-		amount, _ := strconv.Atoi(record[1])
-		debtorMap[name] = amount
-	}
+	// A negative amount means I owe the person instead, e.g. /addDebtor Nam -50K
+	addDebtor := router.Command{Name: "/addDebtor", Group: "Debts", Description: "Record money someone owes me",
+		Args: debtArgs, Note: "Everything after the amount is optional.", Guided: true, Limit: addLimit}
+	addDebtor.Handler = addDebtHandler(bot, DirectionOwedToMe, addDebtor.Usage())
+	r.Handle(addDebtor)
+
+	iOwe := router.Command{Name: "/iOwe", Group: "Debts", Description: "Record money I owe someone",
+		Args: debtArgs, Note: "Everything after the amount is optional.", Guided: true, Limit: addLimit}
+	iOwe.Handler = addDebtHandler(bot, DirectionIOwe, iOwe.Usage())
+	r.Handle(iOwe)
+
+	repay := router.Command{Name: "/repay", Group: "Debts", Description: "Record a repayment to me", Args: repayArgs, Guided: true}
+	repay.Handler = repayHandler(bot, DirectionOwedToMe, repay.Usage())
+	r.Handle(repay)
+
+	payBack := router.Command{Name: "/payBack", Group: "Debts", Description: "Record that I paid someone back", Args: repayArgs, Guided: true}
+	payBack.Handler = repayHandler(bot, DirectionIOwe, payBack.Usage())
+	r.Handle(payBack)
+
+	registerSplitHandlers(r)
+	registerGuidedFlows(r)
+
+	r.Handle(router.Command{
+		Name:        "/alias",
+		Group:       "Debts",
+		Description: "Give a debtor another name",
+		Args:        []router.Arg{{Name: "name"}, {Name: "other name"}},
+		Handler: func(m *telebot.Message) {
+			args := strings.Fields(m.Payload)
+			if len(args) != 2 {
+				utils.Send(bot, m.Chat, "Usage: /alias [name] [other name]")
+				return
+			}
+
+			reply, err := Alias(m.Sender.ID, args[0], args[1])
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Send(bot, m.Chat, reply)
+		},
+	})
+
+	r.Handle(router.Command{
+		Name:        "/mergeDebtors",
+		Group:       "Debts",
+		Description: "Merge a duplicate debtor into another",
+		Args:        []router.Arg{{Name: "duplicate name"}, {Name: "name to keep"}},
+		Admin:       true,
+		Handler: func(m *telebot.Message) {
+			args := strings.Fields(m.Payload)
+			if len(args) != 2 {
+				utils.Send(bot, m.Chat, "Usage: /mergeDebtors [duplicate name] [name to keep]")
+				return
+			}
+
+			reply, err := MergeDebtors(m.Sender.ID, args[0], args[1])
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Send(bot, m.Chat, reply)
+		},
+	})
+
+	r.Handle(router.Command{
+		Name:        "/debtHistory",
+		Group:       "Debts",
+		Description: "Show every transaction with a person",
+		Args:        []router.Arg{{Name: "name", Kind: router.Name}},
+		Guided:      true,
+		Handler: func(m *telebot.Message) {
+			name := strings.TrimSpace(m.Payload)
+			if name == "" {
+				startDebtFlow(bot, m, actionHistory, "")
+				return
+			}
+
+			utils.Send(bot, m.Chat, History(name))
+		},
+	})
 
-	return nil
+	r.Handle(router.Command{
+		Name:        "/listDebtors",
+		Group:       "Debts",
+		Description: "Show who owes what",
+		Handler: func(m *telebot.Message) {
+			utils.Send(bot, m.Chat, Summary())
+		},
+	})
+
+	r.Handle(router.Command{
+		Name:        "/delDebtor",
+		Group:       "Debts",
+		Description: "Delete a debtor's records",
+		Args:        []router.Arg{{Name: "name", Kind: router.Name}},
+		Guided:      true,
+		Admin:       true,
+		Handler: func(m *telebot.Message) {
+			name := strings.TrimSpace(m.Payload)
+			if name == "" {
+				startDebtFlow(bot, m, actionDelete, "")
+				return
+			}
+
+			utils.Send(bot, m.Chat, DeleteDebtor(m.Sender.ID, name))
+		},
+	})
 }
 
-func SaveDebtRecords() error {
-	file, err := os.Create("debtors.csv")
-	if err != nil {
-		return err
+// historyReport lists the transactions of a person. The caller must hold mu.
+func historyReport(name string) string {
+	events := history(name)
+	if len(events) == 0 {
+		return fmt.Sprintf("No debtor found with the name %s.", name)
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	for name, amount := range debtorMap {
-		record := []string{name, strconv.Itoa(amount)}
-		if err := writer.Write(record); err != nil {
-			return err
+	reply := fmt.Sprintf("Debt history of %s:\n", name)
+	for _, t := range events {
+		reply += fmt.Sprintf("%s %s %s", t.CreatedTime.Format("2006-01-02"), describe(t), utils.FormatNumber(t.Amount))
+		if t.Note != "" {
+			reply += fmt.Sprintf(" (%s)", t.Note)
 		}
+		reply += "\n"
 	}
-
-	return nil
+	reply += "Net position: " + formatNet(name, netPositions()[name])
+	return reply
 }
 
-func RegisterHandlers(bot *telebot.Bot) {
-	if err := LoadDebtRecords(); err != nil {
-		fmt.Println("Failed to load debt records:", err)
+// deleteDebtor removes a person from the personal ledger. The caller must hold mu.
+func deleteDebtor(actor int, name string) string {
+	var before []Transaction
+	for _, t := range history(name) {
+		if t.Lender == "" && t.Name == name {
+			before = append(before, t)
+		}
+	}
+	if !removeDebtor(name) {
+		return fmt.Sprintf("No debtor found with the name %s.", name)
+	}
+	if err := SaveDebtRecords(); err != nil {
+		return "Failed to update debt records."
 	}
+	audit.Record(audit.Entry{Actor: actor, Action: "debt.delete", Subject: name, Before: before})
+	return fmt.Sprintf("Deleted %s from debt records.", name)
+}
 
-	bot.Handle("/addDebtor", func(m *telebot.Message) {
-		args := strings.Fields(m.Payload) // Chia dựa trên khoảng trắng để bỏ qua dấu cách thừa
-		if len(args) < 2 {
-			bot.Send(m.Chat, "Usage: /addDebtor [name] [amount with K for thousand or M for million]")
+func addDebtHandler(bot *telebot.Bot, direction string, usage string) func(m *telebot.Message) {
+	return func(m *telebot.Message) {
+		name, args := nameAndArgs(m) // Chia dựa trên khoảng trắng để bỏ qua dấu cách thừa
+		if name == "" {
+			startDebtFlow(bot, m, actionAdd, direction)
+			return
+		}
+		if len(args) < 1 {
+			utils.Send(bot, m.Chat, usage)
 			return
 		}
 
-		name := args[0]
-		amountString := strings.ToUpper(args[1])
-		multiplier := 1
-
-		if strings.HasSuffix(amountString, "K") {
-			multiplier = 1000
-			amountString = strings.TrimSuffix(amountString, "K")
-		} else if strings.HasSuffix(amountString, "M") {
-			multiplier = 1000000
-			amountString = strings.TrimSuffix(amountString, "M")
+		amount, err := utils.ParseAmount(args[0])
+		if err != nil || amount == 0 {
+			utils.Send(bot, m.Chat, "Please provide a valid number for the amount.")
+			return
 		}
 
-		amount, err := strconv.Atoi(amountString)
+		mu.Lock()
+		observeSender(m.Sender)
+		linkMentions(m)
+		mu.Unlock()
+
+		reply, err := AddDebt(m.Sender.ID, name, direction, amount, strings.Join(args[1:], " "), m.Chat.ID)
 		if err != nil {
-			bot.Send(m.Chat, "Please provide a valid number for the amount.")
+			utils.Send(bot, m.Chat, utils.Sentence(err))
 			return
 		}
+		utils.Send(bot, m.Chat, reply)
+	}
+}
+
+// addDebt records a new debt, a negative amount is a debt in the opposite
+// direction. The caller must hold mu.
+func addDebt(actor int, name, direction string, amount int, terms terms, chatID int64) (string, error) {
+	if amount < 0 {
+		amount = -amount
+		direction = opposite(direction)
+	}
 
-		amount *= multiplier
-		debtorMap[name] += amount
+	before := balances(direction)[name]
+	n := len(transactions)
+	transactions = append(transactions, Transaction{
+		Name:        name,
+		Kind:        KindBorrow,
+		Amount:      amount,
+		Note:        terms.Note,
+		CreatedTime: clock(),
+		Direction:   direction,
+		DueDate:     terms.DueDate,
+		ChatID:      chatID,
 
-		if err := SaveDebtRecords(); err != nil {
-			bot.Send(m.Chat, "Failed to save debt records.")
+		InterestRate: terms.InterestRate,
+		InterestType: terms.InterestType,
+		LateFee:      terms.LateFee,
+	})
+
+	if err := SaveDebtRecords(); err != nil {
+		transactions = transactions[:n] // Not recorded after all
+		return "", errors.New("failed to save debt records")
+	}
+
+	total := balances(direction)[name]
+	audit.Record(audit.Entry{Actor: actor, Action: "debt.add", Subject: name, Before: before, After: total, Detail: transactions[len(transactions)-1]})
+
+	reply := fmt.Sprintf("Updated %s's debt to %s.", name, utils.FormatNumber(total))
+	if direction == DirectionIOwe {
+		reply = fmt.Sprintf("Updated what I owe %s to %s.", name, utils.FormatNumber(total))
+	}
+	if terms.InterestRate > 0 {
+		reply += fmt.Sprintf(" Interest: %g%% a year, %s.", terms.InterestRate, terms.InterestType)
+	}
+	if !terms.DueDate.IsZero() {
+		reply += fmt.Sprintf(" Due on %s, I'll send a reminder.", terms.DueDate.Format("2006-01-02"))
+	}
+	if terms.LateFee > 0 {
+		reply += fmt.Sprintf(" Late fee: %s.", utils.FormatNumber(terms.LateFee))
+	}
+	return reply, nil
+}
+
+func repayHandler(bot *telebot.Bot, direction string, usage string) func(m *telebot.Message) {
+	return func(m *telebot.Message) {
+		name, args := nameAndArgs(m)
+		if name == "" {
+			startDebtFlow(bot, m, actionRepay, direction)
+			return
+		}
+		if len(args) < 1 {
+			utils.Send(bot, m.Chat, usage)
 			return
 		}
 
-		bot.Send(m.Chat, fmt.Sprintf("Updated %s's debt to %s.", name, utils.FormatNumber(debtorMap[name])))
-	})
+		amount, err := utils.ParseAmount(args[0])
+		if err != nil || amount <= 0 {
+			utils.Send(bot, m.Chat, "Please provide a valid number for the amount.")
+			return
+		}
 
-	bot.Handle("/listDebtors", func(m *telebot.Message) {
-		if len(debtorMap) == 0 {
-			bot.Send(m.Chat, "No debtors recorded.")
+		mu.Lock()
+		observeSender(m.Sender)
+		linkMentions(m)
+		mu.Unlock()
+
+		reply, err := Repay(m.Sender.ID, name, direction, amount, strings.Join(args[1:], " "))
+		if err != nil {
+			utils.Send(bot, m.Chat, utils.Sentence(err))
 			return
 		}
-		reply := "Current debt list:\n"
-		for name, amount := range debtorMap {
-			reply += fmt.Sprintf("%s owes %s\n", name, utils.FormatNumber(amount))
+		utils.Send(bot, m.Chat, reply)
+	}
+}
+
+// recordRepayment records a payment against what is outstanding with the
+// person. The caller must hold mu.
+func recordRepayment(actor int, name, direction string, amount int, note string) (string, error) {
+	owed := balances(direction)[name]
+	if owed <= 0 {
+		if direction == DirectionIOwe {
+			return "", fmt.Errorf("you don't owe %s anything", name)
 		}
-		bot.Send(m.Chat, reply)
+		return "", fmt.Errorf("%s doesn't owe anything", name)
+	}
+	if amount > owed {
+		return "", fmt.Errorf("the outstanding amount with %s is only %s", name, utils.FormatNumber(owed))
+	}
+
+	n := len(transactions)
+	transactions = append(transactions, Transaction{
+		Name:        name,
+		Kind:        KindRepay,
+		Amount:      amount,
+		Note:        note,
+		CreatedTime: clock(),
+		Direction:   direction,
 	})
 
-	bot.Handle("/delDebtor", func(m *telebot.Message) {
-		name := strings.TrimSpace(m.Payload)
-		if name == "" {
-			bot.Send(m.Chat, "Usage: /delDebtor [name]")
-			return
+	if err := SaveDebtRecords(); err != nil {
+		transactions = transactions[:n] // Not recorded after all
+		return "", errors.New("failed to save debt records")
+	}
+
+	remaining := owed - amount
+	audit.Record(audit.Entry{Actor: actor, Action: "debt.repay", Subject: name, Before: owed, After: remaining, Detail: transactions[len(transactions)-1]})
+	if remaining == 0 {
+		return fmt.Sprintf("The debt with %s is fully paid.", name), nil
+	}
+	return fmt.Sprintf("Recorded repayment of %s with %s. Remaining: %s.", utils.FormatNumber(amount), name, utils.FormatNumber(remaining)), nil
+}
+
+// terms are the optional parts of a new debt.
+type terms struct {
+	DueDate      time.Time
+	InterestRate float64
+	InterestType string
+	LateFee      int
+	Note         string
+}
+
+// parseTerms reads the words after the amount, e.g.
+// "2024-12-01 12% compound fee 50K laptop". A leading date or "due <date>"
+// is the due date, "<rate>%" a yearly interest rate, "fee <amount>" a late
+// fee, and whatever is left is the note.
+func parseTerms(args []string, now time.Time) (terms, error) {
+	var result terms
+	var note []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		lower := strings.ToLower(arg)
+
+		switch {
+		case lower == "due" && i+1 < len(args):
+			date, err := utils.ParseDate(args[i+1], now)
+			if err != nil {
+				return result, errors.New("please provide the due date as YYYY-MM-DD or DD/MM")
+			}
+			result.DueDate = date
+			i++
+		case (lower == "fee" || lower == "latefee") && i+1 < len(args):
+			fee, err := utils.ParseAmount(args[i+1])
+			if err != nil || fee < 0 {
+				return result, errors.New("please provide a valid number for the late fee")
+			}
+			result.LateFee = fee
+			i++
+		case strings.HasSuffix(lower, "%"):
+			rate, err := strconv.ParseFloat(strings.TrimSuffix(lower, "%"), 64)
+			if err != nil || rate < 0 {
+				return result, errors.New("please provide the interest rate as a yearly percentage, e.g. 12%")
+			}
+			result.InterestRate = rate
+		case lower == InterestSimple || lower == InterestCompound:
+			result.InterestType = lower
+		default:
+			if i == 0 {
+				if date, err := utils.ParseDate(arg, now); err == nil {
+					result.DueDate = date
+					continue
+				}
+			}
+			note = append(note, arg)
 		}
+	}
 
-		if _, exists := debtorMap[name]; exists {
-			delete(debtorMap, name)
+	if result.InterestRate > 0 && result.InterestType == "" {
+		result.InterestType = InterestSimple
+	}
+	result.Note = strings.Join(note, " ")
+	return result, nil
+}
 
-			if err := SaveDebtRecords(); err != nil {
-				bot.Send(m.Chat, "Failed to update debt records.")
-				return
+// breakdown shows how interest and late fees make up the total of a person,
+// e.g. " (principal 5M, interest 41K, late fees 100K)". The caller must hold mu.
+func breakdown(open []OpenDebt, name, direction string) string {
+	var principal, interest, fees int
+	for _, debt := range open {
+		t := transactions[debt.Index]
+		if t.Name == name && t.Direction == direction && t.Lender == "" {
+			principal += debt.Remaining
+			interest += debt.Interest
+			fees += debt.Fees
+		}
+	}
+	if interest == 0 && fees == 0 {
+		return ""
+	}
+
+	result := fmt.Sprintf(" (principal %s, interest %s", utils.FormatNumber(principal), utils.FormatNumber(interest))
+	if fees > 0 {
+		result += fmt.Sprintf(", late fees %s", utils.FormatNumber(fees))
+	}
+	return result + ")"
+}
+
+func opposite(direction string) string {
+	if direction == DirectionIOwe {
+		return DirectionOwedToMe
+	}
+	return DirectionIOwe
+}
+
+// describe tells what a transaction means from my point of view, or
+// between group members for split bills.
+func describe(t Transaction) string {
+	switch {
+	case t.Lender != "" && t.Kind == KindRepay:
+		return t.Name + " repaid " + t.Lender
+	case t.Lender != "":
+		return t.Name + " owes " + t.Lender
+	case t.Direction == DirectionIOwe && t.Kind == KindRepay:
+		return "I repaid"
+	case t.Direction == DirectionIOwe:
+		return "I borrowed"
+	case t.Kind == KindRepay:
+		return t.Name + " repaid"
+	default:
+		return t.Name + " borrowed"
+	}
+}
+
+func formatNet(name string, net int) string {
+	switch {
+	case net > 0:
+		return fmt.Sprintf("%s owes me %s", name, utils.FormatNumber(net))
+	case net < 0:
+		return fmt.Sprintf("I owe %s %s", name, utils.FormatNumber(-net))
+	default:
+		return fmt.Sprintf("%s and I are even", name)
+	}
+}
+
+// outstandingNames returns, sorted, everyone with an open balance in either direction.
+func outstandingNames(owedToMe, iOwe map[string]int) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, totals := range []map[string]int{owedToMe, iOwe} {
+		for name, amount := range totals {
+			if amount > 0 && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
 			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
 
-			bot.Send(m.Chat, fmt.Sprintf("Deleted %s from debt records.", name))
-		} else {
-			bot.Send(m.Chat, fmt.Sprintf("No debtor found with the name %s.", name))
+func listSection(names []string, totals map[string]int, format string, status func(name string) string) string {
+	section := ""
+	for _, name := range names {
+		if totals[name] > 0 {
+			section += fmt.Sprintf(format, name, utils.FormatNumber(totals[name])) + status(name) + "\n"
 		}
-	})
+	}
+	if section == "" {
+		return "Nothing\n"
+	}
+	return section
 }
@@ -0,0 +1,91 @@
+package debt
+
+import (
+	"os"
+	"testing"
+)
+
+// inTempDir runs the test in an empty directory, since the records are
+// stored in the working directory.
+func inTempDir(t *testing.T) {
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func TestFailedSaveRollsBack(t *testing.T) {
+	inTempDir(t)
+	withClock(t, day(2024, 7, 1))
+	transactions = []Transaction{
+		{Name: "Nam", Kind: KindBorrow, Amount: 100_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 6, 1)},
+	}
+
+	// A directory in the way of the file makes every save fail
+	if err := os.Mkdir(transactionsFile, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := addDebt(1, "Nam", DirectionOwedToMe, 50_000, terms{}, 0); err == nil {
+		t.Fatal("addDebt succeeded without saving")
+	}
+	if _, err := recordRepayment(1, "Nam", DirectionOwedToMe, 50_000, ""); err == nil {
+		t.Fatal("recordRepayment succeeded without saving")
+	}
+	if len(transactions) != 1 {
+		t.Errorf("Expected the unsaved transactions to be dropped, got %+v", transactions)
+	}
+	if owed := balances(DirectionOwedToMe)["Nam"]; owed != 100_000 {
+		t.Errorf("Expected 100000 still owed, got %d", owed)
+	}
+}
+
+func TestLoadSkipsShortRecords(t *testing.T) {
+	inTempDir(t)
+	data := "Nam,borrow,100000,2024-06-01,lunch\nBinh,borrow\nAn,repay,5000\n"
+	if err := os.WriteFile(transactionsFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadTransactions(); err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].Name != "Nam" {
+		t.Errorf("Expected only Nam's record, got %+v", transactions)
+	}
+
+	if err := os.WriteFile(identitiesFile, []byte("Nam,0,nam nguyen\nBinh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadIdentities(); err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Name != "Nam" {
+		t.Errorf("Expected only Nam's identity, got %+v", identities)
+	}
+}
+
+func TestSaveDebtRecords(t *testing.T) {
+	inTempDir(t)
+	transactions = []Transaction{
+		{Name: "Nam", Kind: KindBorrow, Amount: 100_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 6, 1)},
+	}
+	if err := SaveDebtRecords(); err != nil {
+		t.Fatal(err)
+	}
+
+	transactions = nil
+	if err := loadTransactions(); err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].Amount != 100_000 {
+		t.Errorf("Expected the saved record back, got %+v", transactions)
+	}
+	if _, err := os.Stat(transactionsFile + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+}
@@ -0,0 +1,194 @@
+package debt
+
+import (
+	"Telbot/dialog"
+	"Telbot/router"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"sort"
+	"strings"
+)
+
+// Debt commands sent without arguments ask for the debtor with a keyboard
+// of known names, then for the amount when the action needs one.
+
+const debtFlow = "debt"
+
+const (
+	actionAdd     = "add"
+	actionRepay   = "repay"
+	actionHistory = "history"
+	actionDelete  = "delete"
+)
+
+// maxDebtorButtons keeps the keyboard readable, other names can be typed
+const maxDebtorButtons = 20
+
+var (
+	debtorButton = telebot.InlineButton{Unique: "pickDebtor"}
+	deleteButton = telebot.InlineButton{
+		Unique: "confirmDelete",
+		Text:   "🗑 Delete",
+	}
+)
+
+func registerGuidedFlows(r *router.Router) {
+	bot := r.Bot
+
+	dialog.Register(debtFlow, func(m *telebot.Message, c *dialog.Conversation) {
+		switch c.Step() {
+		case "name":
+			pickDebtor(bot, m.Chat, nil, c, strings.TrimSpace(m.Text))
+		case "amount":
+			amount, err := utils.ParseAmount(m.Text)
+			if err != nil || amount == 0 || (amount < 0 && c.Get("action") == actionRepay) {
+				utils.Send(bot, m.Chat, "Please type a valid amount, e.g. 200K.", dialog.CancelKeyboard())
+				return
+			}
+			dialog.End(c.UserID)
+
+			var reply string
+			if c.Get("action") == actionAdd {
+				reply, err = AddDebt(c.UserID, c.Get("name"), c.Get("direction"), amount, "", m.Chat.ID)
+			} else {
+				reply, err = Repay(c.UserID, c.Get("name"), c.Get("direction"), amount, "")
+			}
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Send(bot, m.Chat, reply)
+		}
+	})
+
+	r.HandleCallback(&debtorButton, func(cb *telebot.Callback) {
+		c := callbackConversation(bot, cb, "name")
+		if c == nil {
+			return
+		}
+		if name, ok := c.Option(cb.Data); ok {
+			pickDebtor(bot, cb.Message.Chat, cb.Message, c, name)
+		}
+	})
+
+	r.HandleCallback(&deleteButton, func(cb *telebot.Callback) {
+		c := callbackConversation(bot, cb, "confirm")
+		if c == nil {
+			return
+		}
+		dialog.End(c.UserID)
+		utils.Edit(bot, cb.Message, DeleteDebtor(c.UserID, c.Get("name")))
+	})
+}
+
+// callbackConversation answers the callback and returns the presser's
+// conversation if it is waiting for the step the button belongs to.
+func callbackConversation(bot *telebot.Bot, cb *telebot.Callback, step string) *dialog.Conversation {
+	utils.Respond(bot, cb)
+	if cb.Message == nil {
+		return nil
+	}
+	c := dialog.Current(cb.Sender.ID, debtFlow)
+	if c == nil || c.Step() != step || c.ChatID != cb.Message.Chat.ID {
+		return nil
+	}
+	return c
+}
+
+func startDebtFlow(bot *telebot.Bot, m *telebot.Message, action, direction string) {
+	mu.Lock()
+	names := pickerNames(action, direction)
+	mu.Unlock()
+
+	if len(names) == 0 && action != actionAdd {
+		utils.Send(bot, m.Chat, "No debtors recorded.")
+		return
+	}
+
+	c := dialog.Start(m.Sender.ID, m.Chat.ID, debtFlow, "name")
+	c.Set("action", action)
+	c.Set("direction", direction)
+
+	question := "Who? Pick a name or type it."
+	if action == actionAdd {
+		question = "Who? Pick a name or type a new one."
+	}
+	utils.Send(bot, m.Chat, question, c.Keyboard(debtorButton, names, 2))
+}
+
+// pickerNames lists the people the action makes sense for. The caller must hold mu.
+func pickerNames(action, direction string) []string {
+	seen := map[string]bool{}
+	switch action {
+	case actionRepay:
+		for name, amount := range balances(direction) {
+			if amount > 0 {
+				seen[name] = true
+			}
+		}
+	default:
+		for _, t := range transactions {
+			if t.Lender == "" {
+				seen[t.Name] = true
+			} else if action == actionHistory {
+				seen[t.Name], seen[t.Lender] = true, true
+			}
+		}
+	}
+
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > maxDebtorButtons {
+		names = names[:maxDebtorButtons]
+	}
+	return names
+}
+
+// pickDebtor stores the chosen person and carries on with the action. When
+// the name comes from a button, edit is the message holding the keyboard.
+func pickDebtor(bot *telebot.Bot, chat *telebot.Chat, edit *telebot.Message, c *dialog.Conversation, typed string) {
+	if normalizeName(typed) == "" {
+		return
+	}
+
+	mu.Lock()
+	name := resolve(typed)
+	text, markup, step := nextQuestion(c.Get("action"), c.Get("direction"), name)
+	mu.Unlock()
+
+	c.Set("name", name)
+	if step == "" {
+		dialog.End(c.UserID)
+	} else {
+		c.Next(step)
+	}
+	dialog.Reply(bot, chat, edit, text, markup)
+}
+
+// nextQuestion is what to reply once the person is picked, with the step
+// waiting for the answer, "" when the action is done. The caller must hold mu.
+func nextQuestion(action, direction, name string) (string, *telebot.ReplyMarkup, string) {
+	switch action {
+	case actionHistory:
+		return historyReport(name), nil, ""
+	case actionDelete:
+		if len(history(name)) == 0 {
+			return fmt.Sprintf("No debtor found with the name %s.", name), nil, ""
+		}
+		return fmt.Sprintf("Delete all personal debt records with %s?", name), &telebot.ReplyMarkup{
+			InlineKeyboard: [][]telebot.InlineButton{{deleteButton, dialog.CancelButton}},
+		}, "confirm"
+	case actionRepay:
+		owed := balances(direction)[name]
+		if owed <= 0 {
+			return fmt.Sprintf("Nothing is outstanding with %s.", name), nil, ""
+		}
+		return fmt.Sprintf("%s outstanding with %s. How much was paid?", utils.FormatNumber(owed), name), dialog.CancelKeyboard(), "amount"
+	default:
+		return fmt.Sprintf("How much, with %s? e.g. 200K, negative for the other direction.", name), dialog.CancelKeyboard(), "amount"
+	}
+}
@@ -0,0 +1,283 @@
+package debt
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const identitiesFile = "debtor_identities.csv"
+
+// Identity is a debtor as a person. Transactions store the canonical Name,
+// every other spelling ("nam", "@nam", "nam_nguyen") is resolved to it.
+type Identity struct {
+	Name    string   // Canonical name, as first written
+	UserID  int      // Telegram user ID once the debtor has been linked, 0 otherwise
+	Aliases []string // Normalized alternative names
+}
+
+var identities []*Identity
+
+// normalizeName makes names comparable: "  @Nam " and "nam" are the same key.
+func normalizeName(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// lookup finds the identity a name or alias belongs to. The caller must hold mu.
+func lookup(name string) *Identity {
+	key := normalizeName(name)
+	for _, id := range identities {
+		if normalizeName(id.Name) == key || slices.Contains(id.Aliases, key) {
+			return id
+		}
+	}
+	return nil
+}
+
+func lookupUser(userID int) *Identity {
+	for _, id := range identities {
+		if id.UserID == userID {
+			return id
+		}
+	}
+	return nil
+}
+
+// resolve returns the canonical name for any spelling, or the name itself
+// when nobody by that name is known. The caller must hold mu.
+func resolve(name string) string {
+	if id := lookup(name); id != nil {
+		return id.Name
+	}
+	return strings.TrimSpace(name)
+}
+
+// ensureIdentity resolves the name, registering a new identity for unknown
+// names. The caller must hold mu.
+func ensureIdentity(name string) string {
+	if id := lookup(name); id != nil {
+		return id.Name
+	}
+	id := &Identity{Name: strings.TrimSpace(name)}
+	identities = append(identities, id)
+	return id.Name
+}
+
+// identifyUser returns the canonical name of a Telegram user and links it
+// to the user ID. A user who changed their username keeps the same identity
+// and the new username becomes an alias. The caller must hold mu.
+func identifyUser(u *telebot.User, name string) string {
+	id := lookupUser(u.ID)
+	if id == nil {
+		id = lookup(name)
+		if id != nil && id.UserID != 0 && id.UserID != u.ID {
+			id = nil // Same name, different person
+		}
+	}
+	if id == nil {
+		id = &Identity{Name: strings.TrimSpace(name)}
+		identities = append(identities, id)
+	}
+
+	id.UserID = u.ID
+	if u.Username != "" {
+		addAlias(id, "@"+u.Username)
+	}
+	return id.Name
+}
+
+// observeSender links the sender to their identity, if they are a debtor.
+// The caller must hold mu.
+func observeSender(u *telebot.User) {
+	if u == nil {
+		return
+	}
+	if lookupUser(u.ID) != nil || (u.Username != "" && lookup(u.Username) != nil) {
+		identifyUser(u, "@"+u.Username)
+	}
+}
+
+// linkMentions links debtors mentioned by their display name to their
+// user ID. Telegram only includes the user for mentions of people without a
+// username, plain @username mentions are linked when the person writes in
+// a chat with the bot (see observeSender). The caller must hold mu.
+func linkMentions(m *telebot.Message) {
+	for _, e := range m.Entities {
+		if e.Type == telebot.EntityTMention && e.User != nil {
+			identifyUser(e.User, entityText(m.Text, e))
+		}
+	}
+}
+
+// entityText cuts the entity out of the text, entity offsets count UTF-16 units.
+func entityText(text string, e telebot.MessageEntity) string {
+	units := utf16.Encode([]rune(text))
+	if e.Offset < 0 || e.Offset+e.Length > len(units) {
+		return ""
+	}
+	return string(utf16.Decode(units[e.Offset : e.Offset+e.Length]))
+}
+
+func addAlias(id *Identity, alias string) {
+	key := normalizeName(alias)
+	if key != "" && key != normalizeName(id.Name) && !slices.Contains(id.Aliases, key) {
+		id.Aliases = append(id.Aliases, key)
+	}
+}
+
+// setAlias makes alias another name of the person. The caller must hold mu.
+func setAlias(name, alias string) error {
+	if normalizeName(alias) == "" {
+		return errors.New("the alias can't be empty")
+	}
+	id := lookup(name)
+	if id == nil {
+		return fmt.Errorf("no debtor found with the name %s", name)
+	}
+	if other := lookup(alias); other != nil {
+		if other == id {
+			return nil
+		}
+		return fmt.Errorf("%s is already a separate debtor, use /mergeDebtors %s %s instead", other.Name, other.Name, id.Name)
+	}
+	addAlias(id, alias)
+	return nil
+}
+
+// mergeIdentities moves everything of from into into: its transactions,
+// aliases and user link. The caller must hold mu.
+func mergeIdentities(from, into string) error {
+	source, target := lookup(from), lookup(into)
+	if source == nil {
+		return fmt.Errorf("no debtor found with the name %s", from)
+	}
+	if target == nil {
+		return fmt.Errorf("no debtor found with the name %s", into)
+	}
+	if source == target {
+		return fmt.Errorf("%s and %s are already the same debtor", from, into)
+	}
+	if source.UserID != 0 && target.UserID != 0 && source.UserID != target.UserID {
+		return fmt.Errorf("%s and %s are linked to different Telegram users", source.Name, target.Name)
+	}
+
+	for i := range transactions {
+		if transactions[i].Name == source.Name {
+			transactions[i].Name = target.Name
+		}
+		if transactions[i].Lender == source.Name {
+			transactions[i].Lender = target.Name
+		}
+	}
+
+	addAlias(target, source.Name)
+	for _, alias := range source.Aliases {
+		addAlias(target, alias)
+	}
+	if target.UserID == 0 {
+		target.UserID = source.UserID
+	}
+	identities = slices.DeleteFunc(identities, func(id *Identity) bool { return id == source })
+	return nil
+}
+
+func loadIdentities() error {
+	identities = nil
+
+	file, err := os.Open(identitiesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", identitiesFile, "line", line,
+				"err", fmt.Errorf("%d fields, want 3", len(record)))
+			continue
+		}
+
+		userID, err := strconv.Atoi(record[1])
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", identitiesFile, "line", line, "err", err)
+			continue
+		}
+		id := &Identity{Name: record[0], UserID: userID}
+		if record[2] != "" {
+			id.Aliases = strings.Split(record[2], "|")
+		}
+		identities = append(identities, id)
+	}
+	return nil
+}
+
+// saveIdentities rewrites the identity file. The caller must hold mu.
+func saveIdentities() error {
+	var records [][]string
+	for _, id := range identities {
+		records = append(records, []string{id.Name, strconv.Itoa(id.UserID), strings.Join(id.Aliases, "|")})
+	}
+	return writeRecords(identitiesFile, records)
+}
+
+// canonicalizeTransactions rewrites the names of older records, written
+// before identities existed, to their canonical form. The caller must hold mu.
+func canonicalizeTransactions() bool {
+	changed := false
+	for i := range transactions {
+		t := &transactions[i]
+		if name := ensureIdentity(t.Name); name != t.Name {
+			t.Name, changed = name, true
+		}
+		if t.Lender != "" {
+			if lender := ensureIdentity(t.Lender); lender != t.Lender {
+				t.Lender, changed = lender, true
+			}
+		}
+	}
+	return changed
+}
+
+// nameAndArgs splits the payload into the debtor name and the remaining
+// arguments. A mention of a user without a username may span several
+// words, e.g. "/addDebtor Nam Nguyen 200K".
+func nameAndArgs(m *telebot.Message) (string, []string) {
+	payload := strings.TrimSpace(m.Payload)
+	for _, e := range m.Entities {
+		if e.Type != telebot.EntityTMention {
+			continue
+		}
+		if text := entityText(m.Text, e); text != "" && strings.HasPrefix(payload, text) {
+			return text, strings.Fields(strings.TrimPrefix(payload, text))
+		}
+	}
+
+	args := strings.Fields(payload)
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
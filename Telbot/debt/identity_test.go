@@ -0,0 +1,40 @@
+package debt
+
+import (
+	"github.com/tucnak/telebot"
+	"testing"
+)
+
+func TestIdentities(t *testing.T) {
+	identities = nil
+	transactions = []Transaction{
+		{Name: "Nam", Kind: KindBorrow, Amount: 100_000, Direction: DirectionOwedToMe},
+		{Name: "@nam", Kind: KindBorrow, Amount: 50_000, Direction: DirectionOwedToMe},
+		{Name: "nam_nguyen", Kind: KindBorrow, Amount: 20_000, Direction: DirectionOwedToMe},
+	}
+	canonicalizeTransactions()
+
+	if owed := balances(DirectionOwedToMe)["Nam"]; owed != 150_000 {
+		t.Errorf("Expected Nam, @nam to be one debtor owing 150000, got %d", owed)
+	}
+
+	if err := setAlias("nam", "nam_nguyen"); err == nil {
+		t.Errorf("Expected an error when aliasing an existing debtor")
+	}
+	if err := mergeIdentities("nam_nguyen", " NAM "); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if owed := balances(DirectionOwedToMe)["Nam"]; owed != 170_000 {
+		t.Errorf("Expected 170000 after merging, got %d", owed)
+	}
+	if resolve("@Nam_Nguyen") != "Nam" {
+		t.Errorf("Expected the merged name to resolve to Nam, got %q", resolve("@Nam_Nguyen"))
+	}
+
+	// The link to the Telegram user survives a username change
+	identifyUser(&telebot.User{ID: 7, Username: "nam"}, "@nam")
+	observeSender(&telebot.User{ID: 7, Username: "nam_2024"})
+	if resolve("@nam_2024") != "Nam" {
+		t.Errorf("Expected the new username to resolve to Nam, got %q", resolve("@nam_2024"))
+	}
+}
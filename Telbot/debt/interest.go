@@ -0,0 +1,127 @@
+package debt
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Values of Transaction.InterestType
+const (
+	InterestSimple   = "simple"   // Interest on the principal only
+	InterestCompound = "compound" // Interest on principal and interest, compounded monthly
+)
+
+// OpenDebt is a borrow transaction with what is left of it after
+// repayments, as of a given time.
+type OpenDebt struct {
+	Index     int // Position in transactions
+	Remaining int // Principal left
+	Interest  int // Accrued interest not paid yet
+	Fees      int // Late fees not paid yet
+}
+
+// Total is everything due on the debt.
+func (d OpenDebt) Total() int {
+	return d.Remaining + d.Interest + d.Fees
+}
+
+// loan tracks one borrowing while the history is replayed.
+type loan struct {
+	index      int
+	principal  float64
+	interest   float64
+	fees       float64
+	accruedTo  time.Time
+	feeCharged bool
+}
+
+func (l *loan) due() float64 {
+	return l.principal + l.interest + l.fees
+}
+
+// accrue brings the loan up to the given time: interest for the days
+// elapsed, and the late fee once the due date has passed.
+func (l *loan) accrue(t Transaction, to time.Time) {
+	if to.After(l.accruedTo) && l.principal > 0 && t.InterestRate > 0 {
+		days := to.Sub(l.accruedTo).Hours() / 24
+		rate := t.InterestRate / 100
+		if t.InterestType == InterestCompound {
+			base := l.principal + l.interest
+			l.interest += base * (math.Pow(1+rate/12, days*12/365) - 1)
+		} else {
+			l.interest += l.principal * rate * days / 365
+		}
+	}
+	if to.After(l.accruedTo) {
+		l.accruedTo = to
+	}
+
+	if !l.feeCharged && t.LateFee > 0 && !t.DueDate.IsZero() && l.due() >= 0.5 && daysBetween(t.DueDate, to) > 0 {
+		l.fees += float64(t.LateFee)
+		l.feeCharged = true
+	}
+}
+
+// pay settles fees first, then interest, then principal, and returns what
+// is left of the payment.
+func (l *loan) pay(amount float64) float64 {
+	for _, part := range []*float64{&l.fees, &l.interest, &l.principal} {
+		paid := math.Min(amount, *part)
+		*part -= paid
+		amount -= paid
+	}
+	return amount
+}
+
+// openDebts replays the history up to the given time. Every repayment goes
+// to the oldest borrowings of the same person and direction first, and the
+// borrowings that are not fully paid are returned. The caller must hold mu.
+func openDebts(at time.Time) []OpenDebt {
+	order := make([]int, len(transactions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return transactions[order[i]].CreatedTime.Before(transactions[order[j]].CreatedTime)
+	})
+
+	type key struct{ name, direction, lender string }
+	queues := map[key][]*loan{}
+	var all []*loan
+	for _, i := range order {
+		t := transactions[i]
+		if t.CreatedTime.After(at) {
+			break
+		}
+
+		k := key{t.Name, t.Direction, t.Lender}
+		if t.Kind != KindRepay {
+			l := &loan{index: i, principal: float64(t.Amount), accruedTo: t.CreatedTime}
+			queues[k] = append(queues[k], l)
+			all = append(all, l)
+			continue
+		}
+
+		left := float64(t.Amount)
+		for _, l := range queues[k] {
+			l.accrue(transactions[l.index], t.CreatedTime)
+			left = l.pay(left)
+		}
+	}
+
+	var result []OpenDebt
+	for _, l := range all {
+		l.accrue(transactions[l.index], at)
+		open := OpenDebt{
+			Index:     l.index,
+			Remaining: int(math.Round(l.principal)),
+			Interest:  int(math.Round(l.interest)),
+			Fees:      int(math.Round(l.fees)),
+		}
+		if open.Total() > 0 {
+			result = append(result, open)
+		}
+	}
+	return result
+}
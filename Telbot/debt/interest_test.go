@@ -0,0 +1,78 @@
+package debt
+
+import (
+	"testing"
+	"time"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func withClock(t *testing.T, now time.Time) {
+	previous := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = previous })
+}
+
+func TestSimpleInterest(t *testing.T) {
+	withClock(t, day(2024, 7, 1))
+	transactions = []Transaction{
+		{Name: "Nam", Kind: KindBorrow, Amount: 1_000_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 1, 1), InterestRate: 12, InterestType: InterestSimple},
+	}
+
+	// 182 days at 12% a year
+	if owed := balances(DirectionOwedToMe)["Nam"]; owed != 1_059_836 {
+		t.Errorf("Expected 1059836 due, got %d", owed)
+	}
+}
+
+func TestCompoundInterest(t *testing.T) {
+	withClock(t, day(2024, 7, 1))
+	transactions = []Transaction{
+		{Name: "Nam", Kind: KindBorrow, Amount: 1_000_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 1, 1), InterestRate: 12, InterestType: InterestCompound},
+	}
+
+	open := openDebts(clock())
+	if len(open) != 1 || open[0].Interest != 61_347 {
+		t.Fatalf("Expected 61347 interest compounded monthly, got %+v", open)
+	}
+}
+
+func TestRepaymentPaysInterestFirst(t *testing.T) {
+	withClock(t, day(2024, 7, 1))
+	transactions = []Transaction{
+		{Name: "Nam", Kind: KindBorrow, Amount: 1_000_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 1, 1), InterestRate: 12, InterestType: InterestSimple},
+		{Name: "Nam", Kind: KindRepay, Amount: 500_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 4, 1)},
+	}
+
+	// 29918 of interest is paid on April 1st, the rest goes to the principal
+	open := openDebts(clock())
+	if len(open) != 1 {
+		t.Fatalf("Expected 1 open debt, got %d", len(open))
+	}
+	if open[0].Remaining != 529_918 || open[0].Interest != 15_854 {
+		t.Errorf("Expected principal 529918 and interest 15854, got %+v", open[0])
+	}
+}
+
+func TestLateFee(t *testing.T) {
+	transactions = []Transaction{
+		{Name: "Nam", Kind: KindBorrow, Amount: 200_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 2, 1), DueDate: day(2024, 3, 1), LateFee: 50_000},
+	}
+
+	withClock(t, day(2024, 3, 1))
+	if owed := balances(DirectionOwedToMe)["Nam"]; owed != 200_000 {
+		t.Errorf("Expected no late fee on the due date, got %d due", owed)
+	}
+
+	withClock(t, day(2024, 3, 2))
+	if owed := balances(DirectionOwedToMe)["Nam"]; owed != 250_000 {
+		t.Errorf("Expected the late fee after the due date, got %d due", owed)
+	}
+
+	transactions = append(transactions, Transaction{Name: "Nam", Kind: KindRepay, Amount: 200_000, Direction: DirectionOwedToMe, CreatedTime: day(2024, 2, 20)})
+	if owed := balances(DirectionOwedToMe)["Nam"]; owed != 0 {
+		t.Errorf("Expected no late fee when paid on time, got %d due", owed)
+	}
+}
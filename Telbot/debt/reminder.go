@@ -0,0 +1,166 @@
+package debt
+
+import (
+	"Telbot/router"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReminderDaysBefore is how many days ahead of the due date the first reminder is sent.
+const ReminderDaysBefore = 3
+
+// Values of Transaction.Reminded
+const (
+	remindedBefore = "before"
+	remindedDue    = "due"
+)
+
+var mentionButton = telebot.InlineButton{
+	Unique: "debtMention",
+	Text:   "Mention them",
+}
+
+type reminder struct {
+	chatID int64
+	text   string
+	name   string // Set when the debtor can be mentioned in the chat
+}
+
+// StartReminders checks the due dates every hour and sends the reminders.
+func StartReminders(r *router.Router) {
+	bot := r.Bot
+
+	r.HandleCallback(&mentionButton, func(c *telebot.Callback) {
+		name := c.Data
+
+		mu.Lock()
+		owed := balances(DirectionOwedToMe)[name]
+		mention := mentionOf(name)
+		mu.Unlock()
+
+		utils.Respond(bot, c)
+		if owed <= 0 || c.Message == nil {
+			return
+		}
+		utils.Send(bot, c.Message.Chat, fmt.Sprintf("%s, friendly reminder that you still owe %s.", mention, utils.FormatNumber(owed)), telebot.ModeHTML)
+	})
+
+	r.Every(time.Hour, func() { sendReminders(bot) })
+}
+
+func sendReminders(bot *telebot.Bot) {
+	mu.Lock()
+	reminders := dueReminders(clock())
+	if len(reminders) > 0 {
+		if err := SaveDebtRecords(); err != nil {
+			slog.Error("failed to save debt reminders", "err", err)
+		}
+	}
+	mu.Unlock()
+
+	for _, r := range reminders {
+		chat := &telebot.Chat{ID: r.chatID}
+		if r.name == "" {
+			utils.Send(bot, chat, r.text)
+			continue
+		}
+
+		button := mentionButton
+		button.Text = "Mention " + r.name
+		button.Data = r.name
+		utils.Send(bot, chat, r.text, &telebot.ReplyMarkup{
+			InlineKeyboard: [][]telebot.InlineButton{{button}},
+		})
+	}
+}
+
+// dueReminders marks and returns the reminders that should go out now.
+// The caller must hold mu.
+func dueReminders(now time.Time) []reminder {
+	var result []reminder
+
+	for _, open := range openDebts(now) {
+		t := &transactions[open.Index]
+		if t.DueDate.IsZero() || t.ChatID == 0 {
+			continue
+		}
+
+		days := daysBetween(now, t.DueDate)
+		var when string
+		switch {
+		case days == 0 && t.Reminded != remindedDue:
+			t.Reminded = remindedDue
+			when = "today"
+		case days > 0 && days <= ReminderDaysBefore && t.Reminded == "":
+			t.Reminded = remindedBefore
+			when = "in " + strconv.Itoa(days) + " days"
+		default:
+			continue
+		}
+
+		r := reminder{chatID: t.ChatID}
+		amount := utils.FormatNumber(open.Total())
+		due := t.DueDate.Format("2006-01-02")
+		if t.Direction == DirectionIOwe {
+			r.text = fmt.Sprintf("⏰ Reminder: I owe %s %s, due on %s (%s).", t.Name, amount, due, when)
+		} else {
+			r.text = fmt.Sprintf("⏰ Reminder: %s owes %s, due on %s (%s).", t.Name, amount, due, when)
+			if t.ChatID < 0 && mentionOf(t.Name) != "" { // Negative IDs are group chats
+				r.name = t.Name
+			}
+		}
+		result = append(result, r)
+	}
+
+	return result
+}
+
+// mentionOf returns HTML that mentions the debtor, or "" when the debtor
+// can't be mentioned. The caller must hold mu.
+func mentionOf(name string) string {
+	if id := lookup(name); id != nil && id.UserID != 0 {
+		return fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, id.UserID, html.EscapeString(name))
+	}
+	if strings.HasPrefix(name, "@") {
+		return html.EscapeString(name)
+	}
+	return ""
+}
+
+// dueStatus describes the earliest open due date of a person, e.g.
+// " (due 2024-11-30)" or " ⚠️ overdue since 2024-11-01". The caller must hold mu.
+func dueStatus(open []OpenDebt, name, direction string, now time.Time) string {
+	var earliest time.Time
+	for _, debt := range open {
+		t := transactions[debt.Index]
+		if t.Name != name || t.Direction != direction || t.Lender != "" || t.DueDate.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || t.DueDate.Before(earliest) {
+			earliest = t.DueDate
+		}
+	}
+
+	switch {
+	case earliest.IsZero():
+		return ""
+	case daysBetween(now, earliest) < 0:
+		return " ⚠️ overdue since " + earliest.Format("2006-01-02")
+	default:
+		return " (due " + earliest.Format("2006-01-02") + ")"
+	}
+}
+
+// daysBetween counts calendar days, ignoring the time of day and time zone
+// since due dates are stored as plain dates.
+func daysBetween(from, to time.Time) int {
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
@@ -0,0 +1,37 @@
+package debt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDueReminders(t *testing.T) {
+	now := time.Date(2024, 11, 27, 9, 0, 0, 0, time.UTC)
+	transactions = []Transaction{
+		{Name: "@nam", Kind: KindBorrow, Amount: 200_000, Direction: DirectionOwedToMe, CreatedTime: now.AddDate(0, 0, -10), DueDate: now.AddDate(0, 0, 3), ChatID: -100},
+		{Name: "@nam", Kind: KindRepay, Amount: 50_000, Direction: DirectionOwedToMe, CreatedTime: now.AddDate(0, 0, -1)},
+		{Name: "lan", Kind: KindBorrow, Amount: 100_000, Direction: DirectionIOwe, CreatedTime: now.AddDate(0, 0, -5), DueDate: now.AddDate(0, 0, 10), ChatID: 42},
+	}
+
+	reminders := dueReminders(now)
+	if len(reminders) != 1 {
+		t.Fatalf("Expected 1 reminder, got %d", len(reminders))
+	}
+	if reminders[0].name != "@nam" {
+		t.Errorf("Expected to offer mentioning @nam in the group, got %q", reminders[0].name)
+	}
+	if expected := "⏰ Reminder: @nam owes 150K, due on 2024-11-30 (in 3 days)."; reminders[0].text != expected {
+		t.Errorf("Expected %q, got %q", expected, reminders[0].text)
+	}
+
+	if len(dueReminders(now)) != 0 {
+		t.Errorf("Expected the early reminder to be sent only once")
+	}
+	if len(dueReminders(now.AddDate(0, 0, 3))) != 1 {
+		t.Errorf("Expected a reminder on the due date")
+	}
+
+	if status := dueStatus(openDebts(now.AddDate(0, 0, 4)), "@nam", DirectionOwedToMe, now.AddDate(0, 0, 4)); status != " ⚠️ overdue since 2024-11-30" {
+		t.Errorf("Expected the debt to be overdue, got %q", status)
+	}
+}
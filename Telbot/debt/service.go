@@ -0,0 +1,141 @@
+package debt
+
+import (
+	"Telbot/audit"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The functions below are the ledger without Telegram: they take plain
+// values and return the reply to show, or an error whose text can be shown
+// as is. The Telegram handlers and package core both use them.
+
+// AddDebt records that name owes me amount, or that I owe them with
+// DirectionIOwe. A negative amount flips the direction. details holds the
+// optional terms, e.g. "2024-12-01 12% compound fee 50K laptop".
+func AddDebt(actor int, name, direction string, amount int, details string, chatID int64) (string, error) {
+	if normalizeName(name) == "" || amount == 0 {
+		return "", errors.New("please provide a name and a valid amount")
+	}
+	terms, err := parseTerms(strings.Fields(details), clock())
+	if err != nil {
+		return "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return addDebt(actor, ensureIdentity(name), direction, amount, terms, chatID)
+}
+
+// Repay records a payment of amount against what is outstanding with name.
+func Repay(actor int, name, direction string, amount int, note string) (string, error) {
+	if amount <= 0 {
+		return "", errors.New("please provide a valid number for the amount")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return recordRepayment(actor, resolve(name), direction, amount, note)
+}
+
+// History lists every transaction with name.
+func History(name string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return historyReport(resolve(name))
+}
+
+// Summary shows who owes what, with the terms of each open debt.
+func Summary() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	owedToMe := balances(DirectionOwedToMe)
+	iOwe := balances(DirectionIOwe)
+	net := netPositions()
+	now := clock()
+	open := openDebts(now)
+
+	names := outstandingNames(owedToMe, iOwe)
+	if len(names) == 0 {
+		return "No debtors recorded."
+	}
+
+	reply := "Owed to me:\n"
+	reply += listSection(names, owedToMe, "%s owes %s", func(name string) string {
+		return breakdown(open, name, DirectionOwedToMe) + dueStatus(open, name, DirectionOwedToMe, now)
+	})
+	reply += "\nI owe:\n"
+	reply += listSection(names, iOwe, "I owe %s %s", func(name string) string {
+		return breakdown(open, name, DirectionIOwe) + dueStatus(open, name, DirectionIOwe, now)
+	})
+	reply += "\nNet position:\n"
+	for _, name := range names {
+		reply += formatNet(name, net[name]) + "\n"
+	}
+	return reply
+}
+
+// Transactions returns a copy of the ledger, in the order recorded.
+func Transactions() []Transaction {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Transaction(nil), transactions...)
+}
+
+// Balances returns what is due per person in one direction as of now,
+// interest and late fees included.
+func Balances(direction string) map[string]int {
+	mu.Lock()
+	defer mu.Unlock()
+	return balances(direction)
+}
+
+// DeleteDebtor removes a person from the personal ledger.
+func DeleteDebtor(actor int, name string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return deleteDebtor(actor, resolve(name))
+}
+
+// Alias makes alias another name of the person called name.
+func Alias(actor int, name, alias string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var before []string
+	if id := lookup(name); id != nil {
+		before = slices.Clone(id.Aliases)
+	}
+	if err := setAlias(name, alias); err != nil {
+		return "", err
+	}
+	audit.Record(audit.Entry{Actor: actor, Action: "debt.alias", Subject: resolve(name), Before: before, After: lookup(name).Aliases})
+	if err := SaveDebtRecords(); err != nil {
+		return "", errors.New("failed to save debt records")
+	}
+	return fmt.Sprintf("%s is now also known as %s.", resolve(name), alias), nil
+}
+
+// MergeDebtors moves everything of the duplicate into the person to keep.
+func MergeDebtors(actor int, duplicate, keep string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var before []Identity
+	for _, id := range []*Identity{lookup(duplicate), lookup(keep)} {
+		if id != nil {
+			before = append(before, *id)
+		}
+	}
+	if err := mergeIdentities(duplicate, keep); err != nil {
+		return "", err
+	}
+	audit.Record(audit.Entry{Actor: actor, Action: "debt.merge", Subject: resolve(keep), Before: before, After: *lookup(keep)})
+	if err := SaveDebtRecords(); err != nil {
+		return "", errors.New("failed to save debt records")
+	}
+	return fmt.Sprintf("Merged %s into %s.", duplicate, resolve(keep)), nil
+}
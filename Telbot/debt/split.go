@@ -0,0 +1,446 @@
+package debt
+
+import (
+	"Telbot/access"
+	"Telbot/audit"
+	"Telbot/router"
+	"Telbot/utils"
+	"errors"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bills split in a group are recorded as debts between members: every
+// participant owes the payer their share. Those transactions carry the
+// payer in Lender, so they never mix with the personal ledger.
+
+const splitNote = "Shares are equal by default, use @member*2 for a weight or @member=200K for an exact share. Write me to include yourself."
+
+var settleButton = telebot.InlineButton{
+	Unique: "settleUp",
+	Text:   "Mark as settled",
+}
+
+type participant struct {
+	Name     string
+	Weight   int
+	Exact    int // Exact share, used when HasExact is set
+	HasExact bool
+}
+
+type share struct {
+	Name   string
+	Amount int
+}
+
+type transfer struct {
+	From   string
+	To     string
+	Amount int
+}
+
+func registerSplitHandlers(r *router.Router) {
+	bot := r.Bot
+
+	split := router.Command{
+		Name:        "/split",
+		Group:       "Group bills",
+		Description: "Split a bill between members of the chat",
+		Args: []router.Arg{
+			{Name: "amount", Kind: router.Amount},
+			{Name: "description", Optional: true},
+			{Name: "@member ...", Kind: router.Text, Optional: true},
+		},
+		Note: splitNote,
+	}
+	split.Handler = splitHandler(bot, split.Usage())
+	r.Handle(split)
+
+	r.Handle(router.Command{
+		Name:        "/settle",
+		Group:       "Group bills",
+		Description: "Show the fewest transfers that settle the chat",
+		Handler: func(m *telebot.Message) {
+			mu.Lock()
+			transfers := settleTransfers(groupBalances(m.Chat.ID))
+			mu.Unlock()
+
+			if len(transfers) == 0 {
+				utils.Send(bot, m.Chat, "Everyone in this chat is settled up.")
+				return
+			}
+
+			reply := "To settle up:\n"
+			for _, t := range transfers {
+				reply += fmt.Sprintf("%s pays %s %s\n", t.From, t.To, utils.FormatNumber(t.Amount))
+			}
+			utils.Send(bot, m.Chat, reply, &telebot.ReplyMarkup{
+				InlineKeyboard: [][]telebot.InlineButton{{settleButton}},
+			})
+		},
+	})
+
+	r.HandleCallback(&settleButton, func(c *telebot.Callback) {
+		if c.Message == nil {
+			utils.Respond(bot, c)
+			return
+		}
+		if !access.CanChange(bot, c.Sender, c.Message.Chat) {
+			utils.Respond(bot, c, &telebot.CallbackResponse{Text: "Only admins can settle the chat."})
+			return
+		}
+		n, err := settleChat(c.Sender.ID, c.Message.Chat.ID)
+		if err != nil {
+			utils.Respond(bot, c, &telebot.CallbackResponse{Text: utils.Sentence(err)})
+			return
+		}
+		utils.Respond(bot, c, &telebot.CallbackResponse{Text: "Recorded " + strconv.Itoa(n) + " transfers."})
+		utils.Send(bot, c.Message.Chat, "Group balances are settled.")
+	})
+}
+
+// settleChat records the transfers that settle the chat and returns how
+// many there were.
+func settleChat(actor int, chatID int64) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := clock()
+	before := groupBalances(chatID)
+	transfers := settleTransfers(before)
+	n := len(transactions)
+	for _, t := range transfers {
+		transactions = append(transactions, Transaction{
+			Name:        t.From,
+			Kind:        KindRepay,
+			Amount:      t.Amount,
+			Note:        "settle up",
+			CreatedTime: now,
+			Direction:   DirectionOwedToMe,
+			ChatID:      chatID,
+			Lender:      t.To,
+		})
+	}
+
+	if err := SaveDebtRecords(); err != nil {
+		transactions = transactions[:n]
+		return 0, errors.New("failed to save debt records")
+	}
+	audit.Record(audit.Entry{Actor: actor, Action: "debt.settle", Subject: strconv.FormatInt(chatID, 10),
+		Before: before, After: groupBalances(chatID), Detail: transfers})
+	return len(transfers), nil
+}
+
+func splitHandler(bot *telebot.Bot, usage string) func(m *telebot.Message) {
+	return func(m *telebot.Message) {
+		payer := senderName(m.Sender)
+		total, description, participants, err := parseSplit(m.Payload, payer)
+		if err != nil {
+			utils.Send(bot, m.Chat, utils.Sentence(err)+"\n"+usage)
+			return
+		}
+
+		shares, err := computeShares(total, participants)
+		if err != nil {
+			utils.Send(bot, m.Chat, utils.Sentence(err))
+			return
+		}
+
+		reply, err := recordSplit(m, payer, total, description, shares)
+		if err != nil {
+			utils.Send(bot, m.Chat, utils.Sentence(err))
+			return
+		}
+		utils.Send(bot, m.Chat, reply)
+	}
+}
+
+// recordSplit records what everyone owes the payer and returns the reply.
+func recordSplit(m *telebot.Message, payer string, total int, description string, shares []share) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Resolve everyone to their debtor identity, "me" is the payer
+	linkMentions(m)
+	canonicalPayer := identifyUser(m.Sender, payer)
+	seen := map[string]bool{}
+	for i := range shares {
+		if shares[i].Name == payer {
+			shares[i].Name = canonicalPayer
+		} else {
+			shares[i].Name = ensureIdentity(shares[i].Name)
+		}
+		if seen[shares[i].Name] {
+			return "", fmt.Errorf("%s is listed twice", shares[i].Name)
+		}
+		seen[shares[i].Name] = true
+	}
+	payer = canonicalPayer
+
+	now := clock()
+	before := groupBalances(m.Chat.ID)
+	reply := fmt.Sprintf("Split %s", utils.FormatNumber(total))
+	if description != "" {
+		reply += " for " + description
+	}
+	reply += fmt.Sprintf(" paid by %s:\n", payer)
+	n := len(transactions)
+	for _, s := range shares {
+		if s.Name == payer {
+			reply += fmt.Sprintf("%s's own share: %s\n", payer, utils.FormatNumber(s.Amount))
+			continue
+		}
+		transactions = append(transactions, Transaction{
+			Name:        s.Name,
+			Kind:        KindBorrow,
+			Amount:      s.Amount,
+			Note:        strings.TrimSpace("split " + description),
+			CreatedTime: now,
+			Direction:   DirectionOwedToMe,
+			ChatID:      m.Chat.ID,
+			Lender:      payer,
+		})
+		reply += fmt.Sprintf("%s owes %s %s\n", s.Name, payer, utils.FormatNumber(s.Amount))
+	}
+
+	if err := SaveDebtRecords(); err != nil {
+		transactions = transactions[:n]
+		return "", errors.New("failed to save debt records")
+	}
+	audit.Record(audit.Entry{Actor: m.Sender.ID, Action: "debt.split", Subject: description,
+		Before: before, After: groupBalances(m.Chat.ID), Detail: shares})
+	return reply, nil
+}
+
+// senderName returns the @username of the sender, or the first name when
+// the user has no username.
+func senderName(u *telebot.User) string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return u.FirstName
+}
+
+// parseSplit reads "600K lunch @a @b*2 @c=100K me".
+func parseSplit(payload string, payer string) (int, string, []participant, error) {
+	args := strings.Fields(payload)
+	if len(args) < 2 {
+		return 0, "", nil, errors.New("please provide an amount and at least one member")
+	}
+
+	total, err := utils.ParseAmount(args[0])
+	if err != nil || total <= 0 {
+		return 0, "", nil, errors.New("please provide a valid number for the amount")
+	}
+
+	var words []string
+	var participants []participant
+	seen := map[string]bool{}
+	for _, arg := range args[1:] {
+		if !isMember(arg) {
+			words = append(words, arg)
+			continue
+		}
+
+		p := participant{Name: arg, Weight: 1}
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			amount, err := utils.ParseAmount(value)
+			if err != nil || amount < 0 {
+				return 0, "", nil, fmt.Errorf("invalid share %q", arg)
+			}
+			p.Name, p.Exact, p.HasExact = name, amount, true
+		} else if name, value, ok := strings.Cut(arg, "*"); ok {
+			weight, err := strconv.Atoi(value)
+			if err != nil || weight <= 0 {
+				return 0, "", nil, fmt.Errorf("invalid weight %q", arg)
+			}
+			p.Name, p.Weight = name, weight
+		}
+
+		if strings.EqualFold(p.Name, "me") {
+			p.Name = payer
+		}
+		if seen[p.Name] {
+			return 0, "", nil, fmt.Errorf("%s is listed twice", p.Name)
+		}
+		seen[p.Name] = true
+		participants = append(participants, p)
+	}
+
+	if len(participants) == 0 {
+		return 0, "", nil, errors.New("please mention at least one member")
+	}
+	return total, strings.Join(words, " "), participants, nil
+}
+
+func isMember(arg string) bool {
+	lower := strings.ToLower(arg)
+	return strings.HasPrefix(arg, "@") || lower == "me" || strings.HasPrefix(lower, "me*") || strings.HasPrefix(lower, "me=")
+}
+
+// computeShares takes the exact shares first, then splits what is left by
+// weight. Rounding leftovers go to the first members so the shares always
+// add up to the total.
+func computeShares(total int, participants []participant) ([]share, error) {
+	exactSum, weightSum := 0, 0
+	for _, p := range participants {
+		if p.HasExact {
+			exactSum += p.Exact
+		} else {
+			weightSum += p.Weight
+		}
+	}
+
+	remaining := total - exactSum
+	if remaining < 0 || (weightSum == 0 && remaining != 0) {
+		return nil, fmt.Errorf("the exact shares add up to %s, not %s", utils.FormatNumber(exactSum), utils.FormatNumber(total))
+	}
+
+	shares := make([]share, len(participants))
+	leftover := remaining
+	for i, p := range participants {
+		shares[i].Name = p.Name
+		if p.HasExact {
+			shares[i].Amount = p.Exact
+			continue
+		}
+		shares[i].Amount = remaining * p.Weight / weightSum
+		leftover -= shares[i].Amount
+	}
+	for i := 0; leftover > 0; i = (i + 1) % len(shares) {
+		if !participants[i].HasExact {
+			shares[i].Amount++
+			leftover--
+		}
+	}
+	return shares, nil
+}
+
+// groupBalances returns the net balance of every member in the chat:
+// positive when the member is owed money. The caller must hold mu.
+func groupBalances(chatID int64) map[string]int {
+	net := map[string]int{}
+	for _, t := range transactions {
+		if t.Lender == "" || t.ChatID != chatID {
+			continue
+		}
+		net[t.Lender] += t.signedAmount()
+		net[t.Name] -= t.signedAmount()
+	}
+	return net
+}
+
+// settleTransfers finds the fewest transfers that clear the balances.
+// Members are split into as many zero-sum groups as possible, and a group
+// of k members is then settled with k-1 transfers.
+func settleTransfers(net map[string]int) []transfer {
+	var names []string
+	for name, amount := range net {
+		if amount != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var result []transfer
+	for _, group := range zeroSumGroups(names, net) {
+		result = append(result, settleGroup(group, net)...)
+	}
+	return result
+}
+
+// maxExactMembers bounds the exponential search, larger groups are
+// settled as a whole.
+const maxExactMembers = 16
+
+func zeroSumGroups(names []string, net map[string]int) [][]string {
+	n := len(names)
+	if n == 0 {
+		return nil
+	}
+	if n > maxExactMembers {
+		return [][]string{names}
+	}
+
+	full := 1<<n - 1
+	sum := make([]int, full+1)
+	best := make([]int, full+1) // Most zero-sum groups a removal order of the mask goes through
+	for mask := 1; mask <= full; mask++ {
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 {
+				sum[mask] = sum[mask^(1<<i)] + net[names[i]]
+				break
+			}
+		}
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && best[mask^(1<<i)] > best[mask] {
+				best[mask] = best[mask^(1<<i)]
+			}
+		}
+		if sum[mask] == 0 {
+			best[mask]++
+		}
+	}
+
+	// Walk back from the full set; members removed between two zero-sum
+	// masks form one group.
+	var groups [][]string
+	var current []string
+	for mask := full; mask != 0; {
+		next := -1
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			prev := mask ^ (1 << i)
+			want := best[mask]
+			if sum[mask] == 0 {
+				want--
+			}
+			if best[prev] == want {
+				next = i
+				break
+			}
+		}
+		current = append(current, names[next])
+		mask ^= 1 << next
+		if sum[mask] == 0 {
+			groups = append(groups, current)
+			current = nil
+		}
+	}
+	return groups
+}
+
+// settleGroup matches the largest debtor with the largest creditor until
+// the zero-sum group is cleared.
+func settleGroup(group []string, net map[string]int) []transfer {
+	balance := map[string]int{}
+	for _, name := range group {
+		balance[name] = net[name]
+	}
+
+	var result []transfer
+	for {
+		creditor, debtor := "", ""
+		for _, name := range group {
+			if balance[name] > 0 && (creditor == "" || balance[name] > balance[creditor]) {
+				creditor = name
+			}
+			if balance[name] < 0 && (debtor == "" || balance[name] < balance[debtor]) {
+				debtor = name
+			}
+		}
+		if creditor == "" || debtor == "" {
+			return result
+		}
+
+		amount := min(balance[creditor], -balance[debtor])
+		result = append(result, transfer{From: debtor, To: creditor, Amount: amount})
+		balance[creditor] -= amount
+		balance[debtor] += amount
+	}
+}
@@ -0,0 +1,45 @@
+package debt
+
+import "testing"
+
+func TestComputeShares(t *testing.T) {
+	_, _, participants, err := parseSplit("600K lunch @a @b*2 @c=100K", "@payer")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	shares, err := computeShares(600_000, participants)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]int{"@a": 166_667, "@b": 333_333, "@c": 100_000}
+	for _, s := range shares {
+		if s.Amount != expected[s.Name] {
+			t.Errorf("Expected %s to pay %d, got %d", s.Name, expected[s.Name], s.Amount)
+		}
+	}
+
+	if _, err := computeShares(100_000, []participant{{Name: "@a", Exact: 60_000, HasExact: true}}); err == nil {
+		t.Errorf("Expected an error when exact shares don't add up to the total")
+	}
+}
+
+func TestSettleTransfers(t *testing.T) {
+	net := map[string]int{"@a": 50, "@b": -50, "@c": 30, "@d": -10, "@e": -20}
+
+	transfers := settleTransfers(net)
+	if len(transfers) != 3 {
+		t.Fatalf("Expected 3 transfers, got %d: %v", len(transfers), transfers)
+	}
+
+	for _, tr := range transfers {
+		net[tr.From] += tr.Amount
+		net[tr.To] -= tr.Amount
+	}
+	for name, amount := range net {
+		if amount != 0 {
+			t.Errorf("Expected %s to be settled, %d left", name, amount)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package debt
+
+import (
+	"Telbot/metrics"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	transactionsFile = "debt_transactions.csv"
+	legacyDebtorFile = "debtors.csv" // name,total rows written before the transaction history existed
+)
+
+// Kind tells whether a transaction increases or decreases a debt.
+const (
+	KindBorrow = "borrow"
+	KindRepay  = "repay"
+)
+
+// Direction tells who owes whom.
+const (
+	DirectionOwedToMe = "owed_to_me" // The debtor owes me
+	DirectionIOwe     = "i_owe"      // I owe the debtor
+)
+
+// Transaction is a single dated debt event for a debtor.
+type Transaction struct {
+	Name        string
+	Kind        string // KindBorrow or KindRepay
+	Amount      int    // Always positive, the Kind gives the sign
+	Note        string
+	CreatedTime time.Time
+	Direction   string    // DirectionOwedToMe or DirectionIOwe
+	DueDate     time.Time // Agreed payback date, zero when there is none
+	ChatID      int64     // Chat the debt was recorded in, reminders go there
+	Reminded    string    // Last reminder sent, see reminder.go
+	Lender      string    // Group member Name owes, empty when Name owes me (see split.go)
+
+	InterestRate float64 // Yearly rate in percent, 0 for no interest (see interest.go)
+	InterestType string  // InterestSimple or InterestCompound
+	LateFee      int     // Flat fee charged once the due date has passed
+}
+
+var (
+	mu           sync.Mutex
+	transactions []Transaction
+
+	// clock tells the current time, tests replace it to get deterministic interest.
+	clock = time.Now
+)
+
+// signedAmount returns the amount as it affects the debtor's balance.
+func (t Transaction) signedAmount() int {
+	if t.Kind == KindRepay {
+		return -t.Amount
+	}
+	return t.Amount
+}
+
+func LoadDebtRecords() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := loadIdentities(); err != nil {
+		return err
+	}
+	if err := loadTransactions(); err != nil {
+		return err
+	}
+	if canonicalizeTransactions() {
+		return SaveDebtRecords()
+	}
+	return nil
+}
+
+func loadTransactions() error {
+	defer metrics.StoreDuration.Time("debt_transactions", "read")()
+
+	file, err := os.Open(transactionsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return loadLegacyDebtors()
+		}
+		return err
+	}
+	defer file.Close()
+
+	transactions = nil
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 5 {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", transactionsFile, "line", line,
+				"err", fmt.Errorf("%d fields, want at least 5", len(record)))
+			continue
+		}
+
+		amount, amountErr := strconv.Atoi(record[2])
+		createdTime, dateErr := time.Parse("2006-01-02", record[3])
+		errs := []error{amountErr, dateErr}
+		direction := DirectionOwedToMe // Records written before directions existed
+		if len(record) > 5 && record[5] != "" {
+			direction = record[5]
+		}
+		var dueDate time.Time
+		var chatID int64
+		var reminded, lender string
+		if len(record) > 8 {
+			if record[6] != "" {
+				dueDate, err = time.Parse("2006-01-02", record[6])
+				errs = append(errs, err)
+			}
+			if record[7] != "" {
+				chatID, err = strconv.ParseInt(record[7], 10, 64)
+				errs = append(errs, err)
+			}
+			reminded = record[8]
+		}
+		if len(record) > 9 {
+			lender = record[9]
+		}
+		var interestRate float64
+		var interestType string
+		var lateFee int
+		if len(record) > 12 {
+			if record[10] != "" {
+				interestRate, err = strconv.ParseFloat(record[10], 64)
+				errs = append(errs, err)
+			}
+			interestType = record[11]
+			if record[12] != "" {
+				lateFee, err = strconv.Atoi(record[12])
+				errs = append(errs, err)
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", transactionsFile, "line", line, "err", err)
+			continue
+		}
+
+		transactions = append(transactions, Transaction{
+			Name:        record[0],
+			Kind:        record[1],
+			Amount:      amount,
+			CreatedTime: createdTime,
+			Note:        record[4],
+			Direction:   direction,
+			DueDate:     dueDate,
+			ChatID:      chatID,
+			Reminded:    reminded,
+			Lender:      lender,
+
+			InterestRate: interestRate,
+			InterestType: interestType,
+			LateFee:      lateFee,
+		})
+	}
+
+	return nil
+}
+
+// loadLegacyDebtors turns the running totals of the old debtors.csv into
+// opening transactions so no balance is lost after upgrading.
+func loadLegacyDebtors() error {
+	file, err := os.Open(legacyDebtorFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Nếu file không tồn tại, không có lỗi
+		}
+		return err
+	}
+	defer file.Close()
+
+	transactions = nil
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			break
+		}
+		if len(record) < 2 {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", legacyDebtorFile, "line", line,
+				"err", fmt.Errorf("%d fields, want 2", len(record)))
+			continue
+		}
+
+		amount, err := strconv.Atoi(record[1])
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", legacyDebtorFile, "line", line, "err", err)
+			continue
+		}
+		transactions = append(transactions, Transaction{
+			Name:        record[0],
+			Kind:        KindBorrow,
+			Amount:      amount,
+			Note:        "opening balance",
+			CreatedTime: clock(),
+			Direction:   DirectionOwedToMe,
+		})
+	}
+
+	return nil
+}
+
+// SaveDebtRecords rewrites the transaction and identity files. The caller must hold mu.
+func SaveDebtRecords() error {
+	defer metrics.StoreDuration.Time("debt_transactions", "write")()
+
+	if err := saveIdentities(); err != nil {
+		return err
+	}
+
+	var records [][]string
+	for _, t := range transactions {
+		records = append(records, []string{
+			t.Name,
+			t.Kind,
+			strconv.Itoa(t.Amount),
+			t.CreatedTime.Format("2006-01-02"),
+			t.Note,
+			t.Direction,
+			formatDate(t.DueDate),
+			strconv.FormatInt(t.ChatID, 10),
+			t.Reminded,
+			t.Lender,
+			strconv.FormatFloat(t.InterestRate, 'f', -1, 64),
+			t.InterestType,
+			strconv.Itoa(t.LateFee),
+		})
+	}
+	return writeRecords(transactionsFile, records)
+}
+
+// writeRecords replaces the file with the records. They are written to a
+// temporary file first, so a failed write leaves the old file intact.
+func writeRecords(filename string, records [][]string) error {
+	tmp, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(tmp)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// balances returns what is due per person in one direction as of now,
+// interest and late fees included. The caller must hold mu.
+func balances(direction string) map[string]int {
+	result := map[string]int{}
+	for _, open := range openDebts(clock()) {
+		t := transactions[open.Index]
+		if t.Direction == direction && t.Lender == "" {
+			result[t.Name] += open.Total()
+		}
+	}
+	return result
+}
+
+// netPositions returns what each person owes me minus what I owe them.
+// The caller must hold mu.
+func netPositions() map[string]int {
+	result := balances(DirectionOwedToMe)
+	for name, amount := range balances(DirectionIOwe) {
+		result[name] -= amount
+	}
+	return result
+}
+
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+// history returns the transactions involving the person, oldest first.
+// The caller must hold mu.
+func history(name string) []Transaction {
+	var result []Transaction
+	for _, t := range transactions {
+		if t.Name == name || t.Lender == name {
+			result = append(result, t)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedTime.Before(result[j].CreatedTime)
+	})
+	return result
+}
+
+// removeDebtor drops every transaction between me and the debtor.
+// The caller must hold mu.
+func removeDebtor(name string) bool {
+	kept := transactions[:0]
+	found := false
+	for _, t := range transactions {
+		if t.Name == name && t.Lender == "" {
+			found = true
+			continue
+		}
+		kept = append(kept, t)
+	}
+	transactions = kept
+	return found
+}
+
+// Stats summarizes the stored records for /admin stats.
+func Stats() string {
+	counts := Counts()
+	return fmt.Sprintf("Debt transactions: %d\nDebtors: %d\n", counts["debt_transactions"], counts["debtors"])
+}
+
+// Counts returns the number of records in each store, for metrics.
+func Counts() map[string]int {
+	mu.Lock()
+	defer mu.Unlock()
+	return map[string]int{"debt_transactions": len(transactions), "debtors": len(identities)}
+}
+
+// Flush writes the ledger one last time, waiting for any change in progress.
+func Flush() (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := SaveDebtRecords(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d debt transactions", len(transactions)), nil
+}
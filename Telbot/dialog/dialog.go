@@ -0,0 +1,246 @@
+package dialog
+
+import (
+	"Telbot/router"
+	"Telbot/utils"
+	"github.com/tucnak/telebot"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Conversation is a guided flow one user is going through, e.g. entering a
+// purchase step by step. Each user has at most one at a time; starting a new
+// flow replaces the old one.
+//
+// Messages and button presses of the same user are handled concurrently, so
+// the step and the answers are only read and written through the methods.
+type Conversation struct {
+	UserID  int
+	ChatID  int64
+	Flow    string            // Name the flow was registered with
+	step    string            // Current step, defined by the flow
+	data    map[string]string // Answers collected so far
+	options []string          // Of the last keyboard made with Keyboard
+	updated time.Time
+}
+
+// TextHandler receives what the user types while their conversation is at
+// one of the flow's steps.
+type TextHandler func(m *telebot.Message, c *Conversation)
+
+// Timeout is how long a conversation waits for the next answer.
+const Timeout = 15 * time.Minute
+
+var (
+	mu            sync.Mutex
+	conversations = map[int]*Conversation{}
+	flows         = map[string]TextHandler{}
+)
+
+// CancelButton ends the conversation of whoever presses it.
+var CancelButton = telebot.InlineButton{
+	Unique: "dialogCancel",
+	Text:   "Cancel",
+}
+
+// Register sets the handler for text typed during the flow.
+func Register(flow string, onText TextHandler) {
+	mu.Lock()
+	defer mu.Unlock()
+	flows[flow] = onText
+}
+
+// Start begins a flow for the user in the chat.
+func Start(userID int, chatID int64, flow, step string) *Conversation {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c := &Conversation{
+		UserID:  userID,
+		ChatID:  chatID,
+		Flow:    flow,
+		step:    step,
+		data:    map[string]string{},
+		updated: time.Now(),
+	}
+	conversations[userID] = c
+	return c
+}
+
+// Current returns the user's conversation in the given flow, or nil.
+func Current(userID int, flow string) *Conversation {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, ok := conversations[userID]
+	if !ok || c.Flow != flow {
+		return nil
+	}
+	if time.Since(c.updated) > Timeout {
+		delete(conversations, userID)
+		return nil
+	}
+	return c
+}
+
+// Step returns the current step.
+func (c *Conversation) Step() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return c.step
+}
+
+// Next moves the conversation to another step.
+func (c *Conversation) Next(step string) {
+	mu.Lock()
+	defer mu.Unlock()
+	c.step = step
+	c.updated = time.Now()
+}
+
+// Get returns an answer collected so far, "" when there is none.
+func (c *Conversation) Get(key string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return c.data[key]
+}
+
+// Set stores an answer.
+func (c *Conversation) Set(key, value string) {
+	mu.Lock()
+	defer mu.Unlock()
+	c.data[key] = value
+}
+
+// End finishes the user's conversation.
+func End(userID int) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(conversations, userID)
+}
+
+// active returns the conversation a message from the user belongs to.
+func active(m *telebot.Message) (*Conversation, TextHandler) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, ok := conversations[m.Sender.ID]
+	if !ok || c.ChatID != m.Chat.ID {
+		return nil, nil
+	}
+	if time.Since(c.updated) > Timeout {
+		delete(conversations, m.Sender.ID)
+		return nil, nil
+	}
+	return c, flows[c.Flow]
+}
+
+// HandleText routes plain text to the sender's conversation, and to
+// fallback when they have none. It also handles /cancel and CancelButton.
+func HandleText(r *router.Router, fallback func(m *telebot.Message)) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:   telebot.OnText,
+		Hidden: true,
+		Handler: func(m *telebot.Message) {
+			if c, onText := active(m); c != nil && onText != nil {
+				onText(m, c)
+				return
+			}
+			fallback(m)
+		},
+	})
+
+	r.Handle(router.Command{
+		Name:        "/cancel",
+		Group:       "General",
+		Description: "Stop the current question",
+		Handler: func(m *telebot.Message) {
+			End(m.Sender.ID)
+			utils.Send(bot, m.Chat, "Cancelled.")
+		},
+	})
+
+	r.HandleCallback(&CancelButton, func(cb *telebot.Callback) {
+		End(cb.Sender.ID)
+		utils.Respond(bot, cb)
+		if cb.Message != nil {
+			utils.Edit(bot, cb.Message, "Cancelled.")
+		}
+	})
+}
+
+// Keyboard lays out one button per option, with the option as callback
+// data, followed by a Cancel button.
+func Keyboard(button telebot.InlineButton, options []string, perRow int) *telebot.ReplyMarkup {
+	return keyboard(button, options, options, perRow)
+}
+
+// Keyboard lays out options that may not fit in the 64 bytes of callback
+// data, such as names the user typed. The buttons carry the option's index
+// and Option returns the option pressed.
+func (c *Conversation) Keyboard(button telebot.InlineButton, options []string, perRow int) *telebot.ReplyMarkup {
+	mu.Lock()
+	c.options = options
+	mu.Unlock()
+
+	data := make([]string, len(options))
+	for i := range options {
+		data[i] = strconv.Itoa(i)
+	}
+	return keyboard(button, options, data, perRow)
+}
+
+// Option returns the option of the button of Keyboard with the data.
+func (c *Conversation) Option(data string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	i, err := strconv.Atoi(data)
+	if err != nil || i < 0 || i >= len(c.options) {
+		return "", false
+	}
+	return c.options[i], true
+}
+
+func keyboard(button telebot.InlineButton, options, data []string, perRow int) *telebot.ReplyMarkup {
+	var rows [][]telebot.InlineButton
+	var row []telebot.InlineButton
+	for i, option := range options {
+		b := button
+		b.Text = option
+		b.Data = data[i]
+		row = append(row, b)
+		if len(row) == perRow {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []telebot.InlineButton{CancelButton})
+	return &telebot.ReplyMarkup{InlineKeyboard: rows}
+}
+
+// CancelKeyboard is the markup of a question answered by typing.
+func CancelKeyboard() *telebot.ReplyMarkup {
+	return &telebot.ReplyMarkup{
+		InlineKeyboard: [][]telebot.InlineButton{{CancelButton}},
+	}
+}
+
+// Reply edits the message holding the keyboard that was used, or sends a
+// new message when the answer was typed and edit is nil.
+func Reply(bot *telebot.Bot, chat *telebot.Chat, edit *telebot.Message, text string, markup *telebot.ReplyMarkup) {
+	var options []interface{}
+	if markup != nil {
+		options = append(options, markup)
+	}
+	if edit != nil {
+		utils.Edit(bot, edit, text, options...)
+		return
+	}
+	utils.Send(bot, chat, text, options...)
+}
@@ -0,0 +1,71 @@
+package dialog
+
+import "testing"
+
+func TestConversationLifecycle(t *testing.T) {
+	c := Start(1, 10, "purchase", "amount")
+	c.Set("amount", "35000")
+
+	if Current(1, "budget") != nil {
+		t.Fatal("conversation found in another flow")
+	}
+	if got := Current(1, "purchase"); got != c {
+		t.Fatal("conversation not found")
+	}
+
+	c.Next("category")
+	if step := Current(1, "purchase").Step(); step != "category" {
+		t.Errorf("step = %q, want category", step)
+	}
+	if amount := c.Get("amount"); amount != "35000" {
+		t.Errorf("amount = %q, want 35000", amount)
+	}
+
+	// Starting another flow replaces the first
+	Start(1, 10, "budget", "amount")
+	if Current(1, "purchase") != nil {
+		t.Error("old conversation still active")
+	}
+
+	End(1)
+	if Current(1, "budget") != nil {
+		t.Error("conversation still active after End")
+	}
+}
+
+func TestKeyboard(t *testing.T) {
+	button := CancelButton
+	button.Unique = "pick"
+	markup := Keyboard(button, []string{"a", "b", "c", "d", "e"}, 2)
+
+	rows := markup.InlineKeyboard
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 3 rows of options and a cancel row", len(rows))
+	}
+	if len(rows[2]) != 1 || rows[2][0].Data != "e" || rows[2][0].Unique != "pick" {
+		t.Errorf("last option row = %+v", rows[2])
+	}
+	if rows[3][0].Unique != CancelButton.Unique {
+		t.Errorf("last row is %q, want the cancel button", rows[3][0].Unique)
+	}
+}
+
+func TestConversationKeyboard(t *testing.T) {
+	c := Start(1, 10, "debt", "name")
+	defer End(1)
+	long := "Nguyễn Thị Minh Khai of the accounting department, second floor"
+	markup := c.Keyboard(CancelButton, []string{"Nam", long}, 2)
+
+	data := markup.InlineKeyboard[0][1].Data
+	if data != "1" {
+		t.Errorf("callback data = %q, want the index", data)
+	}
+	if option, ok := c.Option(data); !ok || option != long {
+		t.Errorf("Option(%q) = %q, %v", data, option, ok)
+	}
+	for _, data := range []string{"2", "-1", "Nam"} {
+		if _, ok := c.Option(data); ok {
+			t.Errorf("Option(%q) found an option", data)
+		}
+	}
+}
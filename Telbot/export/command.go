@@ -0,0 +1,82 @@
+package export
+
+import (
+	"Telbot/purchase"
+	"Telbot/router"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RegisterHandlers registers /export.
+func RegisterHandlers(r *router.Router) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:        "/export",
+		Group:       "Reports",
+		Description: "Download your purchases and budgets",
+		Args:        []router.Arg{{Name: strings.Join(Periods, "|"), Optional: true}, {Name: strings.Join(Formats, "|"), Optional: true}},
+		Note:        "Everything is exported as an XLSX spreadsheet by default.",
+		Limit:       purchase.ReportLimit,
+		Handler: func(m *telebot.Message) {
+			// Either order works, e.g. /export csv month
+			period, format := "all", "xlsx"
+			for _, arg := range strings.Fields(strings.ToLower(m.Payload)) {
+				switch {
+				case slices.Contains(Periods, arg):
+					period = arg
+				case slices.Contains(Formats, arg):
+					format = arg
+				default:
+					utils.Send(bot, m.Chat, fmt.Sprintf("Unknown period or format %q.\nUsage: /export [%s] [%s]",
+						arg, strings.Join(Periods, "|"), strings.Join(Formats, "|")))
+					return
+				}
+			}
+
+			tables, err := Tables(m.Sender.ID, period, time.Now())
+			if err != nil {
+				slog.Error("failed to export", "user_id", m.Sender.ID, "err", err)
+				utils.Send(bot, m.Chat, "Failed to load your records.")
+				return
+			}
+
+			// telebot uploads files from disk only
+			dir, err := os.MkdirTemp("", "telbot-export")
+			if err != nil {
+				slog.Error("failed to export", "user_id", m.Sender.ID, "err", err)
+				utils.Send(bot, m.Chat, "Failed to create the export.")
+				return
+			}
+			defer os.RemoveAll(dir)
+
+			paths, err := Write(dir, "telbot-"+period, format, tables)
+			if err != nil {
+				slog.Error("failed to export", "user_id", m.Sender.ID, "err", err)
+				utils.Send(bot, m.Chat, "Failed to create the export.")
+				return
+			}
+
+			caption := fmt.Sprintf("%d purchases and %d budgets", len(tables[0].Rows), len(tables[1].Rows))
+			if period != "all" {
+				caption += " from the last " + period
+			}
+			for i, path := range paths {
+				document := &telebot.Document{File: telebot.FromDisk(path)}
+				if i == 0 {
+					document.Caption = caption + "."
+				}
+				if utils.Send(bot, m.Chat, document) == nil {
+					utils.Send(bot, m.Chat, "Failed to send the export.")
+					return
+				}
+			}
+		},
+	})
+}
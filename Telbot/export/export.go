@@ -0,0 +1,194 @@
+// Package export writes a user's purchases and budgets as CSV, JSON or
+// XLSX files, and sends them on /export. Debts are left out: the ledger is
+// shared and doesn't record who entered each transaction.
+//
+// Every format has the same columns, named in snake_case, and the same
+// dates, written YYYY-MM-DD. Amounts are plain numbers without K or M.
+package export
+
+import (
+	"Telbot/purchase"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DateFormat is how every date is written.
+const DateFormat = "2006-01-02"
+
+var (
+	Periods = []string{"week", "month", "year", "all"}
+	Formats = []string{"csv", "json", "xlsx"}
+)
+
+// Table is a named list of rows, each holding one value per column:
+// numbers as int or float64, anything else as a string.
+type Table struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// since returns the start of the period, zero for "all".
+func since(period string, now time.Time) time.Time {
+	switch period {
+	case "week":
+		return now.AddDate(0, 0, -7)
+	case "month":
+		return now.AddDate(0, -1, 0)
+	case "year":
+		return now.AddDate(-1, 0, 0)
+	}
+	return time.Time{}
+}
+
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(DateFormat)
+}
+
+// Tables returns the purchases the user made during the period and the
+// user's budgets.
+func Tables(userID int, period string, now time.Time) ([]Table, error) {
+	start := since(period, now)
+
+	purchases := Table{Name: "purchases", Columns: []string{"date", "amount", "target", "account", "note"}}
+	all, err := purchase.AllPurchases()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range all {
+		if p.IDTele == userID && p.CreatedTime.After(start) {
+			purchases.Rows = append(purchases.Rows, []interface{}{formatDate(p.CreatedTime), p.Amount, p.Target, p.AccountName, p.Note})
+		}
+	}
+
+	budgets := Table{Name: "budgets", Columns: []string{"category", "amount", "period", "threshold"}}
+	userBudgets, err := purchase.LoadBudgets(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range userBudgets {
+		budgets.Rows = append(budgets.Rows, []interface{}{b.Category, b.Amount, b.Duration, b.Threshold})
+	}
+
+	return []Table{purchases, budgets}, nil
+}
+
+// Write writes the tables to dir, one CSV file per table or a single JSON
+// or XLSX file, and returns the paths. Files are named after base, e.g.
+// "telbot-month.xlsx" or "telbot-month-purchases.csv".
+func Write(dir, base, format string, tables []Table) ([]string, error) {
+	var paths []string
+	write := func(name string, content func(w io.Writer) error) error {
+		path := filepath.Join(dir, name)
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := content(file); err != nil {
+			file.Close()
+			return err
+		}
+		paths = append(paths, path)
+		return file.Close()
+	}
+
+	var err error
+	switch format {
+	case "csv":
+		for _, t := range tables {
+			if err = write(base+"-"+t.Name+".csv", func(w io.Writer) error { return WriteCSV(w, t) }); err != nil {
+				break
+			}
+		}
+	case "json":
+		err = write(base+".json", func(w io.Writer) error { return writeJSONTables(w, tables) })
+	case "xlsx":
+		err = write(base+".xlsx", func(w io.Writer) error { return WriteXLSX(w, tables) })
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	return paths, err
+}
+
+// WriteCSV writes the table with a header line.
+func WriteCSV(w io.Writer, t Table) error {
+	writer := csv.NewWriter(w)
+	writer.Write(t.Columns)
+	for _, row := range t.Rows {
+		fields := make([]string, len(row))
+		for i, value := range row {
+			fields[i] = fmt.Sprint(value)
+		}
+		writer.Write(fields)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes the table as an array of objects, one per row, whose
+// keys are in the order of the columns.
+func WriteJSON(w io.Writer, t Table) error {
+	var b bytes.Buffer
+	if err := jsonRows(&b, t, ""); err != nil {
+		return err
+	}
+	b.WriteString("\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeJSONTables writes an object holding each table under its name.
+func writeJSONTables(w io.Writer, tables []Table) error {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, t := range tables {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		name, _ := json.Marshal(t.Name)
+		fmt.Fprintf(&b, "\n  %s: ", name)
+		if err := jsonRows(&b, t, "  "); err != nil {
+			return err
+		}
+	}
+	b.WriteString("\n}\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func jsonRows(b *bytes.Buffer, t Table, indent string) error {
+	if len(t.Rows) == 0 {
+		b.WriteString("[]")
+		return nil
+	}
+	b.WriteString("[")
+	for i, row := range t.Rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n" + indent + "  {")
+		// Written by hand, a map would sort the keys
+		var fields []string
+		for j, value := range row {
+			key, _ := json.Marshal(t.Columns[j])
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, fmt.Sprintf("%s: %s", key, encoded))
+		}
+		b.WriteString(strings.Join(fields, ", ") + "}")
+	}
+	b.WriteString("\n" + indent + "]")
+	return nil
+}
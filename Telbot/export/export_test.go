@@ -0,0 +1,95 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+var tables = []Table{
+	{Name: "purchases", Columns: []string{"date", "amount", "target", "note"}, Rows: [][]interface{}{
+		{"2024-12-01", 35000, "coffee", ""},
+		{"2024-12-02", 1500000, "books & <pens>", "for school"},
+	}},
+	{Name: "budgets", Columns: []string{"category", "threshold"}},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b strings.Builder
+	if err := WriteCSV(&b, tables[0]); err != nil {
+		t.Fatal(err)
+	}
+	want := "date,amount,target,note\n2024-12-01,35000,coffee,\n2024-12-02,1500000,books & <pens>,for school\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeJSONTables(&b, tables); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string][]map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b.String(), err)
+	}
+	if len(decoded["purchases"]) != 2 || decoded["purchases"][1]["amount"] != 1500000.0 || decoded["budgets"] == nil {
+		t.Errorf("decoded %v", decoded)
+	}
+	// Keys keep the order of the columns
+	if !strings.Contains(b.String(), `{"date": "2024-12-01", "amount": 35000, "target": "coffee", "note": ""}`) {
+		t.Errorf("got %s", b.String())
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteXLSX(&b, tables); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		parts[f.Name] = string(content)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/styles.xml", "xl/worksheets/sheet2.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="budgets" sheetId="2" r:id="rId2"/>`) {
+		t.Errorf("workbook.xml = %s", parts["xl/workbook.xml"])
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, cell := range []string{
+		`<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">date</t></is></c>`,
+		`<c r="B3"><v>1500000</v></c>`,
+		`<c r="C3" t="inlineStr"><is><t xml:space="preserve">books &amp; &lt;pens&gt;</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("sheet1.xml is missing %s:\n%s", cell, sheet)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := column(i); got != want {
+			t.Errorf("column(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The XLSX writer below covers what the export needs and nothing more: one
+// sheet per table, a bold header row, numbers as numbers and the rest as
+// inline strings, so there is no shared string table to keep.
+
+const (
+	sheetNamespace = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	relNamespace   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	packageRels    = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xmlHeader      = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+const styles = `<styleSheet xmlns="` + sheetNamespace + `">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`</styleSheet>`
+
+// WriteXLSX writes the tables as a workbook, one sheet each.
+func WriteXLSX(w io.Writer, tables []Table) error {
+	var contentTypes, workbook, workbookRels strings.Builder
+	contentTypes.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(`<workbook xmlns="` + sheetNamespace + `" xmlns:r="` + relNamespace + `"><sheets>`)
+	workbookRels.WriteString(`<Relationships xmlns="` + packageRels + `">`)
+
+	parts := map[string]string{}
+	var order []string
+	for i, t := range tables {
+		n := i + 1
+		part := fmt.Sprintf("xl/worksheets/sheet%d.xml", n)
+		fmt.Fprintf(&contentTypes, `<Override PartName="/%s" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, part)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(t.Name), n, n)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, n, relNamespace, n)
+		parts[part] = sheet(t)
+		order = append(order, part)
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/></Relationships>`, len(tables)+1, relNamespace)
+
+	parts["[Content_Types].xml"] = contentTypes.String()
+	parts["_rels/.rels"] = `<Relationships xmlns="` + packageRels + `">` +
+		`<Relationship Id="rId1" Type="` + relNamespace + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	parts["xl/workbook.xml"] = workbook.String()
+	parts["xl/_rels/workbook.xml.rels"] = workbookRels.String()
+	parts["xl/styles.xml"] = styles
+	order = append([]string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"}, order...)
+
+	archive := zip.NewWriter(w)
+	for _, name := range order {
+		part, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(part, xmlHeader+parts[name]); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// sheet is the worksheet of a table, the header in the first row.
+func sheet(t Table) string {
+	var b strings.Builder
+	b.WriteString(`<worksheet xmlns="` + sheetNamespace + `"><sheetData>`)
+
+	header := make([]interface{}, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = column
+	}
+	for r, row := range append([][]interface{}{header}, t.Rows...) {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+		for c, value := range row {
+			ref := column(c) + strconv.Itoa(r+1)
+			style := ""
+			if r == 0 {
+				style = ` s="1"`
+			}
+			switch v := value.(type) {
+			case int:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%d</v></c>`, ref, style, v)
+			case float64:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%s</v></c>`, ref, style, strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				text := fmt.Sprint(v)
+				if text == "" {
+					continue
+				}
+				fmt.Fprintf(&b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(text))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// column is the letter of the column at index i, e.g. "A", "Z", "AA".
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func escape(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
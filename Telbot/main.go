@@ -1,31 +1,239 @@
 package main
 
 import (
+	"Telbot/access"
+	"Telbot/cli"
+	"Telbot/core"
 	"Telbot/debt"
+	"Telbot/export"
+	"Telbot/metrics"
 	"Telbot/purchase"
+	"Telbot/router"
+	"Telbot/telegram"
+	"Telbot/webhook"
+	"context"
+	"errors"
 	"github.com/tucnak/telebot"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 func main() {
+	setupLogging()
+
+	if os.Getenv("TELBOT_MODE") == "cli" {
+		runCLI()
+		return
+	}
+
+	// The token from @BotFather, kept out of the source
+	token := strings.TrimSpace(os.Getenv("TELBOT_TOKEN"))
+	if token == "" {
+		slog.Error("TELBOT_TOKEN is not set")
+		os.Exit(1)
+	}
+
+	var polling atomic.Bool
 	bot, err := telebot.NewBot(telebot.Settings{
-		Token:  "7821718420:AAFabsK75GWyGvD2yq45YdFfgu3ffvwynHM",
-		Poller: &telebot.LongPoller{Timeout: 10 * time.Second},
+		Token:  token,
+		Poller: poller(&polling),
 	})
 
 	if err != nil {
-		log.Fatal(err)
-		return
+		slog.Error("failed to start the bot", "err", err)
+		os.Exit(1)
 	}
 
+	if err := access.Load(); err != nil {
+		slog.Error("failed to load the access list", "err", err)
+		os.Exit(1)
+	}
+
+	r := newRouter(bot)
+
+	if err := r.SetMyCommands(); err != nil {
+		slog.Error("failed to set the command menu", "err", err)
+	}
+
+	server := metrics.Serve(envOr("TELBOT_METRICS_LISTEN", "127.0.0.1:9090"), func() error {
+		if !polling.Load() {
+			return errors.New("not receiving updates yet")
+		}
+		if r.Draining() {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
+	registerRecordCounts()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stopped := make(chan struct{})
+	go func() {
+		bot.Start()
+		close(stopped)
+	}()
+
+	<-ctx.Done()
+	slog.Info("shutting down")
+	shutdown(bot, r, stopped, server)
+}
+
+// registerRecordCounts reports the size of every store on each scrape.
+func registerRecordCounts() {
+	metrics.NewGaugeFunc("telbot_records", "Records in each store.", "store", func() map[string]float64 {
+		counts := debt.Counts()
+		if purchases, err := purchase.Counts(); err == nil {
+			for store, n := range purchases {
+				counts[store] = n
+			}
+		}
+
+		values := map[string]float64{}
+		for store, n := range counts {
+			values[store] = float64(n)
+		}
+		return values
+	})
+}
+
+// newRouter registers every command of the bot.
+func newRouter(bot *telebot.Bot) *router.Router {
+	r := router.New(bot)
+	r.Use(
+		router.Logging,
+		router.Recover(bot),
+		access.Middleware(bot),
+		router.RateLimit(bot, rateLimits()),
+	)
+
 	// Register handlers from each package
-	debt.RegisterHandlers(bot)
-	purchase.RegisterHandlers(bot)       // Handles purchase-related commands
-	purchase.RegisterReportCommands(bot) // Handles reporting-related commands
+	debt.RegisterHandlers(r)
+	debt.StartReminders(r)
+	purchase.StartDigest(r)
+	purchase.RegisterHandlers(r)       // Handles purchase-related commands
+	purchase.RegisterReportCommands(r) // Handles reporting-related commands
+	telegram.Handle(r, core.Find("/compare"), purchase.ReportLimit)
+	export.RegisterHandlers(r)
+	purchase.RegisterCategoryCommands(r)
 	//saving.RegisterHandlers(bot)
-	purchase.SetBudget(bot)
-	purchase.CheckBudget(bot)
-	purchase.ViewBudget(bot)
-	bot.Start()
+	purchase.RegisterBudgetCommands(r)
+	telegram.Handle(r, core.Find("/checkBudget"), purchase.ReportLimit)
+	telegram.Handle(r, core.Find("/viewBudget"), router.Limit{})
+	telegram.Handle(r, core.Find("/forecast"), purchase.ReportLimit)
+	access.RegisterAdminCommands(r, func() string {
+		return purchase.Stats() + debt.Stats()
+	})
+	r.HandleHelp()
+	return r
+}
+
+// runCLI runs the commands in the terminal as TELBOT_CLI_USER, the
+// Telegram ID whose records to use.
+func runCLI() {
+	userID, err := strconv.Atoi(strings.TrimSpace(os.Getenv("TELBOT_CLI_USER")))
+	if err != nil || userID <= 0 {
+		slog.Error("TELBOT_CLI_USER must be the Telegram user ID to run the commands as")
+		os.Exit(1)
+	}
+	if err := core.Load(); err != nil {
+		slog.Error("failed to load debt records", "err", err)
+		os.Exit(1)
+	}
+	if err := cli.Run(os.Stdin, os.Stdout, userID, os.Getenv("USER")); err != nil {
+		slog.Error("failed to read commands", "err", err)
+		os.Exit(1)
+	}
+}
+
+// poller picks how updates arrive: long polling by default, or a webhook
+// server when TELBOT_MODE is "webhook". ready tells /readyz whether updates
+// can arrive.
+func poller(ready *atomic.Bool) telebot.Poller {
+	if os.Getenv("TELBOT_MODE") != "webhook" {
+		return readyPoller{&telebot.LongPoller{Timeout: 10 * time.Second}, ready}
+	}
+
+	p := &webhook.Poller{
+		Listen:    envOr("TELBOT_WEBHOOK_LISTEN", ":8443"),
+		Path:      envOr("TELBOT_WEBHOOK_PATH", "/telegram"),
+		Secret:    os.Getenv("TELBOT_WEBHOOK_SECRET"),
+		CertFile:  os.Getenv("TELBOT_WEBHOOK_CERT"),
+		KeyFile:   os.Getenv("TELBOT_WEBHOOK_KEY"),
+		PublicURL: os.Getenv("TELBOT_WEBHOOK_URL"),
+		Ready:     ready.Store,
+	}
+	if p.Secret == "" {
+		slog.Warn("TELBOT_WEBHOOK_SECRET is not set, anyone can post updates to the webhook")
+	}
+	return p
+}
+
+// readyPoller reports through ready when the bot starts long polling, for
+// /readyz.
+type readyPoller struct {
+	telebot.Poller
+	ready *atomic.Bool
+}
+
+func (p readyPoller) Poll(b *telebot.Bot, updates chan telebot.Update, stop chan struct{}) {
+	p.ready.Store(true)
+	p.Poller.Poll(b, updates, stop)
+}
+
+// rateLimits reads TELBOT_RATE_LIMIT_USER and TELBOT_RATE_LIMIT_CHAT, e.g.
+// "20/1m", and per command overrides in TELBOT_RATE_LIMITS, e.g.
+// "/sumPurchases=3/1m,/addDebtor=10/1m".
+func rateLimits() router.Limits {
+	limits := router.Limits{
+		User: router.Limit{N: 20, Per: time.Minute},
+		Chat: router.Limit{N: 60, Per: time.Minute},
+	}
+	for key, limit := range map[string]*router.Limit{"TELBOT_RATE_LIMIT_USER": &limits.User, "TELBOT_RATE_LIMIT_CHAT": &limits.Chat} {
+		if value := os.Getenv(key); value != "" {
+			l, err := router.ParseLimit(value)
+			if err != nil {
+				slog.Warn("ignoring "+key, "err", err, "using", *limit)
+				continue
+			}
+			*limit = l
+		}
+	}
+
+	commands, err := router.ParseLimits(os.Getenv("TELBOT_RATE_LIMITS"))
+	if err != nil {
+		slog.Warn("ignoring TELBOT_RATE_LIMITS", "err", err)
+	}
+	limits.Commands = commands
+	return limits
+}
+
+// setupLogging configures slog from TELBOT_LOG_FORMAT (text or json) and
+// TELBOT_LOG_LEVEL (debug, info, warn or error).
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envOr("TELBOT_LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if os.Getenv("TELBOT_LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
 }
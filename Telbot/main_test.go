@@ -0,0 +1,263 @@
+package main
+
+import (
+	"Telbot/access"
+	"Telbot/telegramtest"
+	"bytes"
+	"github.com/tucnak/telebot"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startBot runs the bot with every command against a fake Telegram, in an
+// empty directory since the records are files in the working directory.
+func startBot(t *testing.T) *telegramtest.Harness {
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+
+	return telegramtest.Start(t, func(bot *telebot.Bot) {
+		newRouter(bot)
+	})
+}
+
+func TestPurchaseBudgetSummary(t *testing.T) {
+	h := startBot(t)
+
+	h.Send("/setBudget 1M food month")
+	h.Expect("Budget set for food", "every month")
+
+	h.Send("/purchase 800k food")
+	h.Expect("Recorded purchase: 800000 for food")
+	h.Expect("food") // Over the 70% threshold
+
+	h.Send("/purchase 200k books")
+	h.Expect("Recorded purchase: 200000 for books")
+
+	h.Send("/checkBudget")
+	h.Expect("Budget Usage", "food: Spent 800", "(80.00%)", "Warning")
+
+	h.Send("/targetSummary month")
+	h.Expect("Target Summary in month", "food", "(80.00%)", "books", "(20.00%)")
+
+	h.Send("/compare")
+	h.Expect("Last month compared with the month before", "Total: 1M\n", "food: 800K\n  vs the month before: +800K (new)")
+
+	h.Send("/forecast")
+	h.Expect("Budget Forecast", "food: spent 800K of 1M this month", "(too early to tell)")
+}
+
+func TestReportsArePerUser(t *testing.T) {
+	h := startBot(t)
+
+	other := telebot.User{ID: 1002, Username: "binh"}
+	h.Server.SendMessage(other, telebot.Chat{ID: 1002, Type: telebot.ChatPrivate}, "/purchase 500k food")
+	if _, _, err := h.Server.WaitFor(0, telegramtest.ReplyTimeout, func(c telegramtest.Call) bool { return c.ChatID() == 1002 }); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Send("/purchase 35k coffee")
+	h.Expect("Recorded purchase: 35000 for coffee")
+	h.Send("/compare")
+	h.Expect("Last month compared with the month before", "Total: 35K\n")
+
+	// Someone who recorded nothing has nothing to chart
+	newcomer := telebot.User{ID: 1003, Username: "chi"}
+	h.Server.SendMessage(newcomer, telebot.Chat{ID: 1003, Type: telebot.ChatPrivate}, "/chart")
+	reply, _, err := h.Server.WaitFor(0, telegramtest.ReplyTimeout, func(c telegramtest.Call) bool { return c.ChatID() == 1003 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply.Text(), "No purchases in the last month.") {
+		t.Errorf("/chart for a new user: %s %q", reply.Method, reply.Text())
+	}
+}
+
+func TestGuidedPurchase(t *testing.T) {
+	h := startBot(t)
+
+	h.Send("/purchase")
+	h.Expect("How much did you spend?")
+	h.Send("35k")
+	question := h.Expect("Which category?")
+	if len(question.Buttons()) == 0 {
+		t.Fatal("no buttons")
+	}
+	h.Send("coffee")
+	date := h.Expect("When was it?")
+	h.Press(date, "pickDate|today")
+	confirm := h.Expect("Save this purchase?")
+	h.Press(confirm, confirm.Buttons()[0]) // Save
+	h.Expect("Recorded purchase:", "coffee")
+
+	h.Send("/targetSummary")
+	h.Expect("coffee", "(100.00%)")
+}
+
+func TestInvalidArguments(t *testing.T) {
+	h := startBot(t)
+
+	h.Send("/purchase abc food")
+	h.Expect("valid number", "Usage: /purchase")
+	h.Send("/purchase 50k food")
+	h.Expect("Recorded purchase")
+	h.Send("/purchase 50k\tfood")
+	h.Expect("Recorded purchase: 50000 for food")
+	h.Send("/setBudget 1M\tfood\tmonth")
+	h.Expect("Budget set for food", "every month")
+
+	h.Send("/targetSummary day")
+	picker := h.Expect("Please pick a period.")
+	h.Press(picker, "pickPeriod|week")
+	h.Expect("Target Summary in week", "food")
+}
+
+func TestQuickEntryOnlyInPrivate(t *testing.T) {
+	h := startBot(t)
+
+	group := telebot.Chat{ID: -100, Type: telebot.ChatGroup}
+	h.Server.SendMessage(h.User, group, "cà phê 35k")
+	h.Send("cà phê 35k")
+	h.Expect("Save this purchase?")
+	if c, _, err := h.Server.WaitFor(0, 200*time.Millisecond, func(c telegramtest.Call) bool { return c.ChatID() == group.ID }); err == nil {
+		t.Errorf("replied to a group chat: %s", c.Text())
+	}
+}
+
+func TestBannedUserButtons(t *testing.T) {
+	h := startBot(t)
+
+	h.Send("cà phê 35k")
+	question := h.Expect("Save this purchase?")
+
+	// Banned after the question was asked
+	if err := os.WriteFile("access.csv", []byte("user,1001,banned\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := access.Load(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove("access.csv")
+		access.Load()
+	})
+
+	h.Press(question, question.Buttons()[0]) // Save
+	h.Expect("Sorry, you are not allowed to use this bot.")
+	if _, _, err := h.Server.WaitFor(0, time.Second, func(c telegramtest.Call) bool { return c.Method == "answerCallbackQuery" }); err != nil {
+		t.Error("the press was not answered")
+	}
+	if _, err := os.Stat("purchase_records.csv"); !os.IsNotExist(err) {
+		t.Error("a banned user saved a purchase")
+	}
+}
+
+func TestExport(t *testing.T) {
+	h := startBot(t)
+
+	h.Send("/purchase 35k coffee")
+	h.Expect("Recorded purchase")
+
+	h.Send("/export week csv")
+	purchases := h.Expect("1 purchases and 0 budgets")
+	if purchases.Method != "sendDocument" || purchases.File != "telbot-week-purchases.csv" {
+		t.Fatalf("sent %s %q", purchases.Method, purchases.File)
+	}
+	today := time.Now().Format("2006-01-02")
+	if want := "date,amount,target,account,note\n" + today + ",35000,coffee,lan,\n"; string(purchases.Data) != want {
+		t.Errorf("purchases.csv = %q, want %q", purchases.Data, want)
+	}
+	if budgets := h.Expect(); budgets.File != "telbot-week-budgets.csv" {
+		t.Errorf("second file is %q", budgets.File)
+	}
+
+	h.Send("/export")
+	workbook := h.Expect("1 purchases")
+	if workbook.File != "telbot-all.xlsx" || !bytes.HasPrefix(workbook.Data, []byte("PK")) {
+		t.Errorf("sent %q starting with %q", workbook.File, workbook.Data[:min(4, len(workbook.Data))])
+	}
+
+	h.Send("/export pdf")
+	h.Expect(`Unknown period or format "pdf"`)
+}
+
+func TestChart(t *testing.T) {
+	h := startBot(t)
+
+	h.Send("/chart")
+	h.Expect("No purchases in the last month.")
+
+	h.Send("/purchase 35k coffee")
+	h.Expect("Recorded purchase")
+	h.Send("/purchase 120k books")
+	h.Expect("Recorded purchase")
+
+	h.Send("/chart week")
+	for _, caption := range []string{"Share of each target in the last week.", "Spending per day in the last week.", "Spending per target in the last 6 months."} {
+		photo := h.Expect(caption)
+		if photo.Method != "sendPhoto" || !bytes.HasPrefix(photo.Data, []byte("\x89PNG")) {
+			t.Errorf("%q sent with %s, starting with %q", caption, photo.Method, photo.Data[:min(4, len(photo.Data))])
+		}
+	}
+
+	h.Send("/chart decade")
+	h.Expect("Please specify a valid period")
+}
+
+func TestUnusualPurchase(t *testing.T) {
+	h := startBot(t)
+
+	for _, amount := range []string{"35k", "30k", "40k", "35k", "45k"} {
+		h.Send("/purchase " + amount + " coffee")
+		h.Expect("Recorded purchase")
+	}
+
+	h.Send("/purchase 350k coffee")
+	h.Expect("Recorded purchase: 350000 for coffee")
+	question := h.Expect("350K is a lot for coffee", "Did you mean 35K?")
+	h.Press(question, question.Buttons()[0]) // Undo
+	h.Expect("Undone, removed the purchase:\n350K for coffee")
+
+	h.Send("/sumPurchases")
+	h.Expect("Last Week: 185000")
+}
+
+func TestImport(t *testing.T) {
+	h := startBot(t)
+	day := func(daysAgo int) string { return time.Now().AddDate(0, 0, -daysAgo).Format("02/01/2006") }
+
+	h.Send("/purchase 35k coffee")
+	h.Expect("Recorded purchase")
+
+	h.Send("/import")
+	h.Expect("Send me your bank statement")
+	h.SendDocument("statement.csv", []byte("Date,Description,Amount\n"+
+		day(0)+",HIGHLANDS COFFEE,\"-35,000\"\n"+ // Recorded above
+		day(1)+",GRAB*RIDE 1234,\"-120,000\"\n"+
+		day(2)+",The Coffee House,\"-45,000\"\n"+
+		day(3)+",Salary,\"15,000,000\"\n"), "")
+	review := h.Expect("Found 3 purchases (1 already recorded, 1 payments received left out)",
+		"HIGHLANDS COFFEE → coffee (duplicate)", "GRAB*RIDE 1234 → other", "The Coffee House → coffee")
+
+	h.Send("2 transport")
+	review = h.Expect("GRAB*RIDE 1234 → transport")
+	if buttons := review.Buttons(); len(buttons) != 3 || !strings.HasPrefix(buttons[0], "importSave") {
+		t.Fatalf("buttons = %q", buttons)
+	}
+	h.Press(review, review.Buttons()[0]) // Save the 2 new ones
+	h.Expect("Imported 2 purchases.")
+
+	h.Send("/targetSummary week")
+	h.Expect("coffee: 80K", "transport: 120K")
+
+	// The caption can replace /import
+	h.SendDocument("bank.qif", []byte("!Type:Bank\nD"+time.Now().Format("01/02/2006")+"\nT-50.00\nPBookshop\n^\n"), "/import")
+	h.Expect("Found 1 purchases", "Bookshop →")
+}
@@ -0,0 +1,250 @@
+// Package metrics keeps counters, gauges and histograms in memory and
+// serves them in the Prometheus text format, along with health checks.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit handler and store latencies, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	mu       sync.Mutex
+	families []family // In registration order
+)
+
+type family interface {
+	write(w io.Writer)
+}
+
+func register(f family) {
+	mu.Lock()
+	defer mu.Unlock()
+	families = append(families, f)
+}
+
+// Write writes every registered metric in the Prometheus text format.
+func Write(w io.Writer) {
+	mu.Lock()
+	registered := append([]family(nil), families...)
+	mu.Unlock()
+
+	for _, f := range registered {
+		f.write(w)
+	}
+}
+
+// vec holds one series per combination of label values.
+type vec struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	series map[string]interface{} // labelPairs -> *float64 or *histogram
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{name: name, help: help, kind: kind, labels: labels, series: map[string]interface{}{}}
+}
+
+// key formats the label values as they appear between the braces.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + `="` + escape(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+// sortedKeys must be called with v.mu held.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+func (v *vec) writeValues(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.header(w)
+	for _, key := range v.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, braces(key), formatValue(*v.series[key].(*float64)))
+	}
+}
+
+// Counter counts events, e.g. commands handled.
+type Counter struct{ v *vec }
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labels)}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(delta float64, values ...string) {
+	key := c.v.key(values)
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+
+	value, ok := c.v.series[key].(*float64)
+	if !ok {
+		value = new(float64)
+		c.v.series[key] = value
+	}
+	*value += delta
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.v.writeValues(w)
+}
+
+// Gauge reports a value that goes up and down, read when metrics are
+// scraped.
+type Gauge struct {
+	v     *vec
+	value func() map[string]float64 // First label value -> value
+}
+
+// NewGaugeFunc registers a gauge with one label whose series are computed by
+// value on every scrape.
+func NewGaugeFunc(name, help, label string, value func() map[string]float64) *Gauge {
+	g := &Gauge{v: newVec(name, help, "gauge", []string{label}), value: value}
+	register(g)
+	return g
+}
+
+func (g *Gauge) write(w io.Writer) {
+	values := g.value()
+	g.v.mu.Lock()
+	g.v.series = map[string]interface{}{}
+	for label, value := range values {
+		value := value
+		g.v.series[g.v.key([]string{label})] = &value
+	}
+	g.v.mu.Unlock()
+
+	g.v.writeValues(w)
+}
+
+// Histogram counts observations, e.g. latencies, in cumulative buckets.
+type Histogram struct {
+	v       *vec
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{v: newVec(name, help, "histogram", labels), buckets: buckets}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, values ...string) {
+	key := h.v.key(values)
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+
+	s, ok := h.v.series[key].(*histogram)
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.v.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Time starts a timer, the returned func observes the elapsed seconds.
+// Meant for defer:
+//
+//	defer metrics.StoreDuration.Time("purchases", "read")()
+func (h *Histogram) Time(values ...string) func() {
+	start := time.Now()
+	return func() { h.Since(start, values...) }
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+
+	h.v.header(w)
+	for _, key := range h.v.sortedKeys() {
+		s := h.v.series[key].(*histogram)
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.v.name, prefix, formatValue(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.v.name, prefix, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.v.name, braces(key), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.v.name, braces(key), s.count)
+	}
+}
+
+func braces(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "{" + key + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
+
+// StoreDuration times reads and writes of the CSV stores, by store and
+// operation ("read" or "write").
+var StoreDuration = NewHistogram("telbot_store_duration_seconds",
+	"Time spent reading or writing a store.", DefaultBuckets, "store", "op")
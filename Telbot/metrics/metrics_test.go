@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	commands := NewCounter("test_commands_total", "Commands handled.", "command")
+	commands.Inc("/purchase")
+	commands.Inc("/purchase")
+	commands.Inc(`say "hi"`)
+
+	latency := NewHistogram("test_latency_seconds", "Handler latency.", []float64{.1, 1}, "command")
+	latency.Observe(.05, "/purchase")
+	latency.Observe(.5, "/purchase")
+	latency.Observe(3, "/purchase")
+
+	NewGaugeFunc("test_records", "Records per store.", "store", func() map[string]float64 {
+		return map[string]float64{"purchases": 12}
+	})
+
+	var out strings.Builder
+	Write(&out)
+	for _, want := range []string{
+		"# TYPE test_commands_total counter\n",
+		`test_commands_total{command="/purchase"} 2` + "\n",
+		`test_commands_total{command="say \"hi\""} 1` + "\n",
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{command="/purchase",le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{command="/purchase",le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{command="/purchase",le="+Inf"} 3` + "\n",
+		`test_latency_seconds_sum{command="/purchase"} 3.55` + "\n",
+		`test_latency_seconds_count{command="/purchase"} 3` + "\n",
+		`test_records{store="purchases"} 12` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	var ready error
+	handler := Handler(func() error { return ready })
+
+	status := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz = %d while ready", code)
+	}
+	ready = errors.New("shutting down")
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d while not ready, want 503", code)
+	}
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", code)
+	}
+	if code := status("/metrics"); code != http.StatusOK {
+		t.Errorf("/metrics = %d, want 200", code)
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Handler serves /metrics, /healthz and /readyz. /healthz answers as long
+// as the process runs, /readyz only while ready returns nil, e.g. not
+// before the poller starts or once shutdown begins.
+func Handler(ready func() error) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// Serve starts the endpoints on addr in the background. Close the returned
+// server with Shutdown.
+func Serve(addr string, ready func() error) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           Handler(ready),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("metrics server stopped", "addr", addr, "err", err)
+		}
+	}()
+	slog.Info("serving metrics and health checks", "addr", addr)
+	return server
+}
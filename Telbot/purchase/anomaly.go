@@ -0,0 +1,244 @@
+package purchase
+
+import (
+	"Telbot/router"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every new purchase is compared with the user's past purchases of the same
+// target. An unusually large amount, e.g. 350K typed for 35K, or a target
+// never used before gets a "Did you mean…?" reply with a button to undo
+// the purchase. See digest.go for the weekly summary of the outliers.
+
+const (
+	minHistory   = 5   // Past purchases of a target before its amounts are judged
+	minPurchases = 10  // Past purchases before a new target is questioned
+	outlierScore = 3.5 // Robust z-score of the log amount above which it is unusual
+
+	undoFor = 24 * time.Hour // How long a purchase questioned can be undone
+)
+
+var undoButton = telebot.InlineButton{
+	Unique: "purchaseUndo",
+	Text:   "↩️ Undo",
+}
+
+// undoEntry is a purchase questioned, with when it was asked about.
+type undoEntry struct {
+	purchase Purchase
+	asked    time.Time
+}
+
+var (
+	undoMu     sync.Mutex
+	undoable   = map[string]undoEntry{}
+	undoNextID int
+)
+
+func registerAnomalyChecks(r *router.Router) {
+	bot := r.Bot
+
+	r.HandleCallback(&undoButton, func(c *telebot.Callback) {
+		// Only the person who recorded the purchase may undo it
+		undoMu.Lock()
+		entry, ok := undoable[c.Data]
+		purchase := entry.purchase
+		ok = ok && time.Since(entry.asked) < undoFor && c.Sender != nil && c.Sender.ID == purchase.IDTele && c.Message != nil
+		if ok {
+			delete(undoable, c.Data)
+		}
+		undoMu.Unlock()
+		if !ok {
+			utils.Respond(bot, c, &telebot.CallbackResponse{Text: "This purchase can no longer be undone."})
+			return
+		}
+
+		// Identical purchases can't be told apart, remove only one
+		removed := false
+		_, err := DeletePurchases(purchase.IDTele, func(p Purchase) bool {
+			if removed || !samePurchase(p, purchase) {
+				return false
+			}
+			removed = true
+			return true
+		})
+		if err != nil {
+			utils.Respond(bot, c, &telebot.CallbackResponse{Text: "Failed to undo the purchase."})
+			return
+		}
+
+		utils.Respond(bot, c)
+		if !removed {
+			utils.Edit(bot, c.Message, "This purchase was already removed.")
+			return
+		}
+		utils.Edit(bot, c.Message, "Undone, removed the purchase:\n"+describePurchase(purchase))
+	})
+}
+
+// sendPurchaseCheck asks about the purchase just saved if it is unusual,
+// offering to undo it.
+func sendPurchaseCheck(bot *telebot.Bot, chat *telebot.Chat, purchase Purchase) {
+	purchases, err := AllPurchases()
+	if err != nil {
+		return
+	}
+	// The purchase was appended last
+	for i := len(purchases) - 1; i >= 0; i-- {
+		if samePurchase(purchases[i], purchase) {
+			purchases = slices.Delete(purchases, i, i+1)
+			break
+		}
+	}
+
+	question := checkPurchase(purchase, purchases)
+	if question == "" {
+		return
+	}
+
+	undoMu.Lock()
+	now := time.Now()
+	for id, entry := range undoable {
+		if now.Sub(entry.asked) >= undoFor {
+			delete(undoable, id)
+		}
+	}
+	undoNextID++
+	id := strconv.Itoa(undoNextID)
+	undoable[id] = undoEntry{purchase, now}
+	undoMu.Unlock()
+
+	undo := undoButton
+	undo.Data = id
+	utils.Send(bot, chat, question, &telebot.ReplyMarkup{
+		InlineKeyboard: [][]telebot.InlineButton{{undo}},
+	})
+}
+
+// checkPurchase compares the purchase with the others, returning a
+// question for the user when it stands out or "".
+func checkPurchase(purchase Purchase, purchases []Purchase) string {
+	var past []int
+	counts := map[string]int{}
+	total := 0
+	for _, p := range purchases {
+		if p.IDTele != purchase.IDTele {
+			continue
+		}
+		counts[p.Target]++
+		total++
+		if p.Target == purchase.Target {
+			past = append(past, p.Amount)
+		}
+	}
+
+	if len(past) == 0 {
+		if total < minPurchases {
+			return "" // Every target is new to a new user
+		}
+		return fmt.Sprintf("This is your first purchase for %s. Did you mean %s?",
+			purchase.Target, strings.Join(similarTargets(purchase.Target, counts, 3), " or "))
+	}
+
+	usual, ok := outlier(purchase.Amount, past)
+	if !ok {
+		return ""
+	}
+	question := fmt.Sprintf("%s is a lot for %s, you usually spend around %s.",
+		utils.FormatNumber(purchase.Amount), purchase.Target, utils.FormatNumber(usual))
+	if typo, ok := likelyTypo(purchase.Amount, past); ok {
+		return question + fmt.Sprintf(" Did you mean %s?", utils.FormatNumber(typo))
+	}
+	return question + " Did you mean to spend that much?"
+}
+
+// outlier tells whether the amount is unusually large next to the past
+// amounts, and returns their median. Amounts are compared on a log scale,
+// with the median and the median absolute deviation so that a few past
+// outliers don't hide new ones.
+func outlier(amount int, past []int) (int, bool) {
+	if len(past) < minHistory || amount <= 0 {
+		return 0, false
+	}
+	center, scale := logStats(past)
+	usual := int(math.Round(math.Exp(center)))
+	return usual, (math.Log(float64(amount))-center)/scale > outlierScore && amount >= 3*usual
+}
+
+// likelyTypo returns the amount with one to three zeros less if that is a
+// usual amount.
+func likelyTypo(amount int, past []int) (int, bool) {
+	center, scale := logStats(past)
+	for _, divisor := range []int{10, 100, 1000} {
+		if amount%divisor != 0 {
+			break
+		}
+		if math.Abs(math.Log(float64(amount/divisor))-center)/scale <= 2 {
+			return amount / divisor, true
+		}
+	}
+	return 0, false
+}
+
+// logStats returns the median of the log amounts and their spread, at least
+// a quarter (about 30%) so that a habit of paying the same price doesn't
+// make every other price an outlier.
+func logStats(amounts []int) (float64, float64) {
+	logs := make([]float64, 0, len(amounts))
+	for _, amount := range amounts {
+		logs = append(logs, math.Log(math.Max(float64(amount), 1)))
+	}
+	center := median(logs)
+
+	deviations := make([]float64, len(logs))
+	for i, l := range logs {
+		deviations[i] = math.Abs(l - center)
+	}
+	// 1.4826 makes the median absolute deviation a standard deviation
+	return center, math.Max(1.4826*median(deviations), 0.25)
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// similarTargets returns up to n of the targets, the closest to the name
+// first, then the most used.
+func similarTargets(name string, counts map[string]int, n int) []string {
+	var targets []string
+	for target := range counts {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if da, db := levenshtein(name, a), levenshtein(name, b); da != db {
+			return da < db
+		}
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return a < b
+	})
+	return targets[:min(n, len(targets))]
+}
+
+// samePurchase compares purchases as stored, to the day.
+func samePurchase(a, b Purchase) bool {
+	return a.IDTele == b.IDTele && a.AccountName == b.AccountName && a.Amount == b.Amount && a.Target == b.Target &&
+		a.Note == b.Note && a.CreatedTime.Format("2006-01-02") == b.CreatedTime.Format("2006-01-02")
+}
@@ -0,0 +1,75 @@
+package purchase
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPurchase(t *testing.T) {
+	day := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	var history []Purchase
+	for i, amount := range []int{35_000, 30_000, 40_000, 35_000, 45_000} {
+		history = append(history, Purchase{IDTele: 1, Target: "coffee", Amount: amount, CreatedTime: day.AddDate(0, 0, i)})
+	}
+	for i := 0; i < 5; i++ {
+		history = append(history, Purchase{IDTele: 1, Target: "food", Amount: 100_000, CreatedTime: day.AddDate(0, 0, i)})
+	}
+
+	cases := []struct {
+		purchase Purchase
+		want     string
+	}{
+		{Purchase{IDTele: 1, Target: "coffee", Amount: 50_000}, ""},
+		{Purchase{IDTele: 1, Target: "coffee", Amount: 350_000}, "350K is a lot for coffee, you usually spend around 35K. Did you mean 35K?"},
+		{Purchase{IDTele: 1, Target: "coffee", Amount: 600_000}, "600K is a lot for coffee, you usually spend around 35K. Did you mean to spend that much?"},
+		{Purchase{IDTele: 1, Target: "foods", Amount: 100_000}, "This is your first purchase for foods. Did you mean food or coffee?"},
+		{Purchase{IDTele: 2, Target: "coffee", Amount: 350_000}, ""}, // A new user
+	}
+	for _, c := range cases {
+		if got := checkPurchase(c.purchase, history); got != c.want {
+			t.Errorf("checkPurchase(%d for %s) = %q, want %q", c.purchase.Amount, c.purchase.Target, got, c.want)
+		}
+	}
+}
+
+func TestWeeklyDigests(t *testing.T) {
+	monday := time.Date(2024, 11, 11, 0, 0, 0, 0, time.UTC)
+	var purchases []Purchase
+	for i := 1; i <= 5; i++ {
+		purchases = append(purchases, Purchase{IDTele: 1, Target: "coffee", Amount: 35_000, CreatedTime: monday.AddDate(0, 0, -7-i)})
+	}
+	purchases = append(purchases,
+		Purchase{IDTele: 1, Target: "coffee", Amount: 350_000, CreatedTime: monday.AddDate(0, 0, -2)},
+		Purchase{IDTele: 1, Target: "coffee", Amount: 40_000, CreatedTime: monday.AddDate(0, 0, -5)},
+		Purchase{IDTele: 2, Target: "books", Amount: 120_000, CreatedTime: monday.AddDate(0, 0, -1)},
+		Purchase{IDTele: 2, Target: "books", Amount: 90_000, CreatedTime: monday}, // This week
+	)
+
+	digests := weeklyDigests(purchases, monday.AddDate(0, 0, -7), monday)
+	if len(digests) != 2 {
+		t.Fatalf("got %d digests, want 2", len(digests))
+	}
+	want := "Weekly digest, 04/11 to 10/11:\nYou spent 390K on 2 purchases.\nUnusual purchases:\n- 09/11 coffee: 350K, usually around 35K\n"
+	if digests[1] != want {
+		t.Errorf("digest = %q, want %q", digests[1], want)
+	}
+	if !strings.HasSuffix(digests[2], "You spent 120K on 1 purchases.\nNothing unusual.") {
+		t.Errorf("digest = %q", digests[2])
+	}
+}
+
+func TestSentDigests(t *testing.T) {
+	inTempDir(t)
+
+	if sent, err := sentDigests(); err != nil || len(sent) != 0 {
+		t.Fatalf("sentDigests = %v, %v before any digest", sent, err)
+	}
+	if err := saveSentDigests(map[int]string{2: "2024-11-11", 1: "2024-11-04"}); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := sentDigests()
+	if err != nil || len(sent) != 2 || sent[1] != "2024-11-04" || sent[2] != "2024-11-11" {
+		t.Errorf("sentDigests = %v, %v", sent, err)
+	}
+}
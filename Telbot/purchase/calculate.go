@@ -3,40 +3,18 @@ package purchase
 import "time"
 
 func calculateSumByPeriod(purchases []Purchase, period string) int {
-	now := time.Now()
 	total := 0
-
-	for _, purchase := range purchases {
-		switch period {
-		case "month":
-			if purchase.CreatedTime.After(now.AddDate(0, -1, 0)) {
-				total += purchase.Amount
-			}
-		case "week":
-			if purchase.CreatedTime.After(now.AddDate(0, 0, -7)) {
-				total += purchase.Amount
-			}
-		case "year":
-			if purchase.CreatedTime.After(now.AddDate(-1, 0, 0)) {
-				total += purchase.Amount
-			}
-		}
+	for _, amount := range calculateSumByTarget(purchases, period) {
+		total += amount
 	}
-
 	return total
 }
 
 func calculateTargetPercentage(purchases []Purchase, period string) map[string]float64 {
-	total, targetSum := 0, map[string]int{}
-	now := time.Now()
-
-	for _, purchase := range purchases {
-		if (period == "month" && purchase.CreatedTime.After(now.AddDate(0, -1, 0))) ||
-			(period == "week" && purchase.CreatedTime.After(now.AddDate(0, 0, -7))) ||
-			(period == "year" && purchase.CreatedTime.After(now.AddDate(-1, 0, 0))) {
-			targetSum[purchase.Target] += purchase.Amount
-			total += purchase.Amount
-		}
+	targetSum := calculateSumByTarget(purchases, period)
+	total := 0
+	for _, amount := range targetSum {
+		total += amount
 	}
 
 	targetPercentage := map[string]float64{}
@@ -46,16 +24,34 @@ func calculateTargetPercentage(purchases []Purchase, period string) map[string]f
 	return targetPercentage
 }
 
+// calculateSumByTarget sums the purchases of the last week, month or year
+// per target. Purchases dated in the future don't count yet.
 func calculateSumByTarget(purchases []Purchase, period string) map[string]int {
-	targetTotals := map[string]int{}
 	now := time.Now()
+	return calculateSumByTargetBetween(purchases, periodStart(period, now), now)
+}
 
+// calculateSumByTargetBetween sums the purchases made after from and up to
+// to, per target.
+func calculateSumByTargetBetween(purchases []Purchase, from, to time.Time) map[string]int {
+	targetTotals := map[string]int{}
 	for _, purchase := range purchases {
-		if (period == "month" && purchase.CreatedTime.After(now.AddDate(0, -1, 0))) ||
-			(period == "week" && purchase.CreatedTime.After(now.AddDate(0, 0, -7))) ||
-			(period == "year" && purchase.CreatedTime.After(now.AddDate(-1, 0, 0))) {
+		if purchase.CreatedTime.After(from) && !purchase.CreatedTime.After(to) {
 			targetTotals[purchase.Target] += purchase.Amount
 		}
 	}
 	return targetTotals
 }
+
+// periodStart is the start of the week, month or year that ends at end.
+func periodStart(period string, end time.Time) time.Time {
+	switch period {
+	case "week":
+		return end.AddDate(0, 0, -7)
+	case "month":
+		return end.AddDate(0, -1, 0)
+	case "year":
+		return end.AddDate(-1, 0, 0)
+	}
+	return end
+}
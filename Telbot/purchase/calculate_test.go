@@ -0,0 +1,24 @@
+package purchase
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateSkipsFuturePurchases(t *testing.T) {
+	now := time.Now()
+	purchases := []Purchase{
+		{Amount: 30_000, Target: "coffee", CreatedTime: now.AddDate(0, 0, -1)},
+		{Amount: 10_000, Target: "food", CreatedTime: now.AddDate(0, 0, -2)},
+		{Amount: 500_000, Target: "rent", CreatedTime: now.AddDate(0, 0, 3)},
+		{Amount: 70_000, Target: "food", CreatedTime: now.AddDate(0, -2, 0)},
+	}
+
+	if total := calculateSumByPeriod(purchases, "week"); total != 40_000 {
+		t.Errorf("Expected 40000 spent this week, got %d", total)
+	}
+	shares := calculateTargetPercentage(purchases, "week")
+	if len(shares) != 2 || shares["coffee"] != 75 || shares["food"] != 25 {
+		t.Errorf("Expected coffee 75%% and food 25%%, got %v", shares)
+	}
+}
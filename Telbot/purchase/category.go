@@ -0,0 +1,582 @@
+package purchase
+
+import (
+	"Telbot/audit"
+	"Telbot/metrics"
+	"Telbot/router"
+	"Telbot/utils"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const categoriesFile = "categories.csv"
+
+// Category is a spending category of a user. Purchase.Target and
+// Budget.Category hold the category Name.
+type Category struct {
+	IDTele  int
+	Name    string   // Normalized name, e.g. "coffee"
+	Parent  string   // Name of the parent category, empty for top level
+	Aliases []string // Normalized alternative names, e.g. "cafe", "ca phe"
+}
+
+var (
+	categoryMu     sync.Mutex
+	categories     []*Category
+	categoriesRead bool
+	historyRead    = map[int]bool{} // Users whose past purchases are registered
+)
+
+var vietnameseLetters = map[rune]rune{}
+
+func init() {
+	for plain, accented := range map[rune]string{
+		'a': "àáạảãâầấậẩẫăằắặẳẵ",
+		'e': "èéẹẻẽêềếệểễ",
+		'i': "ìíịỉĩ",
+		'o': "òóọỏõôồốộổỗơờớợởỡ",
+		'u': "ùúụủũưừứựửữ",
+		'y': "ỳýỵỷỹ",
+		'd': "đ",
+	} {
+		for _, r := range accented {
+			vietnameseLetters[r] = plain
+		}
+	}
+}
+
+// normalizeCategory makes category names comparable: "  Cà  Phê " becomes "ca phe".
+func normalizeCategory(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return strings.Map(func(r rune) rune {
+		if plain, ok := vietnameseLetters[r]; ok {
+			return plain
+		}
+		return r
+	}, name)
+}
+
+// loadCategories reads the registry once. The caller must hold categoryMu.
+func loadCategories() error {
+	if categoriesRead {
+		return nil
+	}
+	defer metrics.StoreDuration.Time("categories", "read")()
+
+	file, err := os.Open(categoriesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			categoriesRead = true
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	categories = nil
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 4 {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", categoriesFile, "line", line,
+				"err", fmt.Errorf("%d fields, want 4", len(record)))
+			continue
+		}
+
+		idTele, err := strconv.Atoi(record[0])
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", categoriesFile, "line", line, "err", err)
+			continue
+		}
+		category := &Category{IDTele: idTele, Name: record[1], Parent: record[2]}
+		if record[3] != "" {
+			category.Aliases = strings.Split(record[3], "|")
+		}
+		categories = append(categories, category)
+	}
+
+	categoriesRead = true
+	return nil
+}
+
+// registerPastTargets adds the targets of purchases and budgets recorded
+// before the registry existed, once per user. The caller must hold categoryMu.
+func registerPastTargets(userID int) error {
+	if historyRead[userID] {
+		return nil
+	}
+
+	added := false
+	for _, source := range []struct {
+		filename string
+		column   int
+	}{{"purchase_records.csv", 3}, {"budgets.csv", 1}} {
+		file, err := os.Open(source.filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if len(record) <= source.column || record[0] != strconv.Itoa(userID) {
+				continue
+			}
+			if key := normalizeCategory(record[source.column]); key != "" && findCategory(userID, key) == nil {
+				categories = append(categories, &Category{IDTele: userID, Name: key})
+				added = true
+			}
+		}
+	}
+
+	historyRead[userID] = true
+	if added {
+		return saveCategories()
+	}
+	return nil
+}
+
+// saveCategories rewrites the registry, through a temporary file so a
+// failure never leaves half a file behind. The caller must hold categoryMu.
+func saveCategories() error {
+	defer metrics.StoreDuration.Time("categories", "write")()
+
+	var records [][]string
+	for _, c := range categories {
+		records = append(records, []string{strconv.Itoa(c.IDTele), c.Name, c.Parent, strings.Join(c.Aliases, "|")})
+	}
+
+	tmp, err := os.Create(categoriesFile + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(tmp)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(categoriesFile+".tmp", categoriesFile)
+}
+
+// copyCategories copies the registry, so a change that fails to save can
+// be undone. The caller must hold categoryMu.
+func copyCategories() []*Category {
+	saved := make([]*Category, len(categories))
+	for i, c := range categories {
+		category := *c
+		category.Aliases = slices.Clone(c.Aliases)
+		saved[i] = &category
+	}
+	return saved
+}
+
+// userCategories returns the categories of a user. The caller must hold categoryMu.
+func userCategories(userID int) []*Category {
+	var result []*Category
+	for _, c := range categories {
+		if c.IDTele == userID {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// findCategory looks a name up by exact name or alias. The caller must hold categoryMu.
+func findCategory(userID int, name string) *Category {
+	key := normalizeCategory(name)
+	for _, c := range userCategories(userID) {
+		if c.Name == key || slices.Contains(c.Aliases, key) {
+			return c
+		}
+	}
+	return nil
+}
+
+// fuzzyCategory finds the closest category within a few typos: one from 6
+// letters, two from 12. Shorter names are too close to each other, e.g.
+// "taxi" and "tax". The caller must hold categoryMu.
+func fuzzyCategory(userID int, name string) *Category {
+	key := normalizeCategory(name)
+	maxDistance := len([]rune(key)) / 6
+	if maxDistance == 0 {
+		return nil
+	}
+
+	var best *Category
+	bestDistance := maxDistance + 1
+	for _, c := range userCategories(userID) {
+		for _, candidate := range append([]string{c.Name}, c.Aliases...) {
+			if d := levenshtein(key, candidate); d < bestDistance {
+				best, bestDistance = c, d
+			}
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// CategoryMatch tells how a typed category was understood.
+type CategoryMatch struct {
+	Name  string
+	Fuzzy bool // Matched a similar name, e.g. "cofee" to "coffee"
+	New   bool // Registered as a new category
+}
+
+// ResolveCategory maps what the user typed to one of their categories,
+// registering a new category when nothing is close enough.
+func ResolveCategory(userID int, name string) (CategoryMatch, error) {
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+
+	match, err := matchCategory(userID, name)
+	if err != nil || !match.New {
+		return match, err
+	}
+	categories = append(categories, &Category{IDTele: userID, Name: match.Name})
+	return match, saveCategories()
+}
+
+// previewCategory is ResolveCategory without registering a new category,
+// for answers that may still be cancelled.
+func previewCategory(userID int, name string) (CategoryMatch, error) {
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+
+	return matchCategory(userID, name)
+}
+
+// matchCategory finds the category the user meant, or tells it is new.
+// The caller must hold categoryMu.
+func matchCategory(userID int, name string) (CategoryMatch, error) {
+	if err := loadCategories(); err != nil {
+		return CategoryMatch{}, err
+	}
+	if err := registerPastTargets(userID); err != nil {
+		return CategoryMatch{}, err
+	}
+
+	if c := findCategory(userID, name); c != nil {
+		return CategoryMatch{Name: c.Name}, nil
+	}
+	if c := fuzzyCategory(userID, name); c != nil {
+		return CategoryMatch{Name: c.Name, Fuzzy: true}, nil
+	}
+	return CategoryMatch{Name: normalizeCategory(name), New: true}, nil
+}
+
+// canonicalCategory returns the category name of a stored target without
+// registering anything. The caller must hold categoryMu.
+func canonicalCategory(userID int, target string) string {
+	if c := findCategory(userID, target); c != nil {
+		return c.Name
+	}
+	return normalizeCategory(target)
+}
+
+// descendants returns the category and all categories below it.
+// The caller must hold categoryMu.
+func descendants(userID int, name string) []string {
+	result := []string{name}
+	for i := 0; i < len(result); i++ {
+		for _, c := range userCategories(userID) {
+			if c.Parent == result[i] && !slices.Contains(result, c.Name) {
+				result = append(result, c.Name)
+			}
+		}
+	}
+	return result
+}
+
+// setParent moves a category below another one, "" makes it top level.
+// The caller must hold categoryMu.
+func setParent(userID int, name, parent string) error {
+	child := findCategory(userID, name)
+	if child == nil {
+		return fmt.Errorf("no category named %s", name)
+	}
+	if parent == "" {
+		child.Parent = ""
+		return nil
+	}
+
+	p := findCategory(userID, parent)
+	if p != nil && slices.Contains(descendants(userID, child.Name), p.Name) {
+		return fmt.Errorf("%s can't be placed under its own subcategory %s", child.Name, p.Name)
+	}
+	if p == nil {
+		p = &Category{IDTele: userID, Name: normalizeCategory(parent)}
+		categories = append(categories, p)
+	}
+	child.Parent = p.Name
+	return nil
+}
+
+// addCategoryAlias makes alias another name of the category.
+// The caller must hold categoryMu.
+func addCategoryAlias(userID int, alias, name string) error {
+	c := findCategory(userID, name)
+	if c == nil {
+		return fmt.Errorf("no category named %s", name)
+	}
+	if other := findCategory(userID, alias); other != nil {
+		if other == c {
+			return nil
+		}
+		return fmt.Errorf("%s is already a category, use /renameCategory %s %s to merge them", other.Name, other.Name, c.Name)
+	}
+	c.Aliases = append(c.Aliases, normalizeCategory(alias))
+	return nil
+}
+
+// renameCategory renames a category, or merges it into an existing one.
+// The old name stays as an alias. The caller must hold categoryMu.
+func renameCategory(userID int, oldName, newName string) (string, string, error) {
+	source := findCategory(userID, oldName)
+	if source == nil {
+		return "", "", fmt.Errorf("no category named %s", oldName)
+	}
+
+	newKey := normalizeCategory(newName)
+	if newKey == "" {
+		return "", "", errors.New("the new name can't be empty")
+	}
+	from := source.Name
+	target := findCategory(userID, newKey)
+
+	if target == nil || target == source {
+		// Plain rename
+		source.Aliases = slices.DeleteFunc(source.Aliases, func(a string) bool { return a == newKey })
+		source.Name = newKey
+		if !slices.Contains(source.Aliases, from) && from != newKey {
+			source.Aliases = append(source.Aliases, from)
+		}
+		target = source
+	} else {
+		// Merge into the existing category
+		for _, alias := range append([]string{from}, source.Aliases...) {
+			if !slices.Contains(target.Aliases, alias) && alias != target.Name {
+				target.Aliases = append(target.Aliases, alias)
+			}
+		}
+		categories = slices.DeleteFunc(categories, func(c *Category) bool { return c == source })
+	}
+
+	for _, c := range userCategories(userID) {
+		if c.Parent == from {
+			c.Parent = target.Name
+		}
+	}
+	if target.Parent == target.Name {
+		target.Parent = ""
+	}
+	return from, target.Name, nil
+}
+
+// categoryTree renders the categories of a user as an indented tree.
+// The caller must hold categoryMu.
+func categoryTree(userID int) string {
+	children := map[string][]*Category{}
+	for _, c := range userCategories(userID) {
+		children[c.Parent] = append(children[c.Parent], c)
+	}
+	for _, list := range children {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+
+	var tree strings.Builder
+	var walk func(parent string, depth int)
+	walk = func(parent string, depth int) {
+		for _, c := range children[parent] {
+			tree.WriteString(strings.Repeat("  ", depth) + "- " + c.Name)
+			if len(c.Aliases) > 0 {
+				tree.WriteString(" (" + strings.Join(c.Aliases, ", ") + ")")
+			}
+			tree.WriteString("\n")
+			walk(c.Name, depth+1)
+		}
+	}
+	walk("", 0)
+	return tree.String()
+}
+
+func RegisterCategoryCommands(r *router.Router) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:        "/categories",
+		Group:       "Categories",
+		Description: "Show your categories, or set a parent, top level or alias",
+		Args:        []router.Arg{{Name: "parent|top|alias ...", Kind: router.Text, Optional: true}},
+		Note:        "/categories parent [category] [parent]\n/categories top [category]\n/categories alias [alias] [category]",
+		Handler: func(m *telebot.Message) {
+			args := strings.Fields(m.Payload)
+
+			categoryMu.Lock()
+			defer categoryMu.Unlock()
+
+			if err := loadCategories(); err != nil {
+				utils.Send(bot, m.Chat, "Failed to load categories.")
+				return
+			}
+			if err := registerPastTargets(m.Sender.ID); err != nil {
+				utils.Send(bot, m.Chat, "Failed to load purchase records.")
+				return
+			}
+
+			before := copyCategories()
+			var err error
+			var reply string
+			switch {
+			case len(args) == 0:
+				tree := categoryTree(m.Sender.ID)
+				if tree == "" {
+					utils.Send(bot, m.Chat, "You have no categories yet, they are created as you record purchases.")
+					return
+				}
+				utils.Send(bot, m.Chat, "Your categories:\n"+tree)
+				return
+			case args[0] == "parent" && len(args) == 3:
+				err = setParent(m.Sender.ID, args[1], args[2])
+				reply = fmt.Sprintf("%s is now under %s.", normalizeCategory(args[1]), normalizeCategory(args[2]))
+			case args[0] == "top" && len(args) == 2:
+				err = setParent(m.Sender.ID, args[1], "")
+				reply = fmt.Sprintf("%s is now a top level category.", normalizeCategory(args[1]))
+			case args[0] == "alias" && len(args) >= 3:
+				alias := strings.Join(args[1:len(args)-1], " ")
+				err = addCategoryAlias(m.Sender.ID, alias, args[len(args)-1])
+				reply = fmt.Sprintf("%s now counts as %s.", normalizeCategory(alias), normalizeCategory(args[len(args)-1]))
+			default:
+				utils.Send(bot, m.Chat, "Usage: /categories\n/categories parent [category] [parent]\n/categories top [category]\n/categories alias [alias] [category]")
+				return
+			}
+
+			if err != nil {
+				categories = before
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			if err := saveCategories(); err != nil {
+				categories = before
+				utils.Send(bot, m.Chat, "Failed to save categories.")
+				return
+			}
+			utils.Send(bot, m.Chat, reply)
+		},
+	})
+
+	r.Handle(router.Command{
+		Name:        "/renameCategory",
+		Group:       "Categories",
+		Description: "Rename or merge a category",
+		Args:        []router.Arg{{Name: "old name"}, {Name: "new name"}},
+		Handler: func(m *telebot.Message) {
+			args := strings.Fields(m.Payload)
+			if len(args) != 2 {
+				utils.Send(bot, m.Chat, "Usage: /renameCategory [old name] [new name]")
+				return
+			}
+
+			categoryMu.Lock()
+			defer categoryMu.Unlock()
+
+			if err := loadCategories(); err != nil {
+				utils.Send(bot, m.Chat, "Failed to load categories.")
+				return
+			}
+			if err := registerPastTargets(m.Sender.ID); err != nil {
+				utils.Send(bot, m.Chat, "Failed to load purchase records.")
+				return
+			}
+
+			// Every spelling of the old category is rewritten in the history
+			var spellings []string
+			if c := findCategory(m.Sender.ID, args[0]); c != nil {
+				spellings = append([]string{c.Name}, c.Aliases...)
+			}
+
+			before := copyCategories()
+			from, to, err := renameCategory(m.Sender.ID, args[0], args[1])
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			if err := saveCategories(); err != nil {
+				categories = before
+				utils.Send(bot, m.Chat, "Failed to save categories.")
+				return
+			}
+			if err := rewriteCategory(m.Sender.ID, spellings, to); err != nil {
+				utils.Send(bot, m.Chat, "Failed to update purchase records.")
+				return
+			}
+			audit.Record(audit.Entry{Actor: m.Sender.ID, Action: "category.rename", Subject: from, Before: spellings, After: to})
+			utils.Send(bot, m.Chat, fmt.Sprintf("Renamed %s to %s, including past purchases and budgets.", from, to))
+		},
+	})
+}
+
+// Flush writes the category registry, waiting for any change in progress.
+// Purchases and budgets are appended as they are recorded.
+func Flush() (string, error) {
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+
+	if !categoriesRead {
+		return "0 categories", nil
+	}
+	if err := saveCategories(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d categories", len(categories)), nil
+}
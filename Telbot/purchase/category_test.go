@@ -0,0 +1,110 @@
+package purchase
+
+import (
+	"os"
+	"testing"
+)
+
+// inTempDir runs the test in an empty directory, since the records are
+// stored in the working directory.
+func inTempDir(t *testing.T) {
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	categories, categoriesRead, historyRead = nil, false, map[int]bool{}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func TestNormalizeCategory(t *testing.T) {
+	if normalized := normalizeCategory("  Cà  Phê "); normalized != "ca phe" {
+		t.Errorf("Expected ca phe, got %q", normalized)
+	}
+}
+
+func TestResolveCategory(t *testing.T) {
+	inTempDir(t)
+
+	match, _ := ResolveCategory(1, "Groceries")
+	if !match.New || match.Name != "groceries" {
+		t.Errorf("Expected a new groceries category, got %+v", match)
+	}
+	if match, _ := ResolveCategory(1, "grocries"); !match.Fuzzy || match.Name != "groceries" {
+		t.Errorf("Expected grocries to match groceries, got %+v", match)
+	}
+	if match, _ := ResolveCategory(2, "grocries"); !match.New {
+		t.Errorf("Expected categories to be per user, got %+v", match)
+	}
+
+	// Short names are too alike to correct
+	ResolveCategory(1, "tax")
+	ResolveCategory(1, "care")
+	for _, name := range []string{"taxi", "cafe"} {
+		if match, _ := ResolveCategory(1, name); !match.New || match.Name != name {
+			t.Errorf("Expected a new %s category, got %+v", name, match)
+		}
+	}
+}
+
+func TestPreviewCategory(t *testing.T) {
+	inTempDir(t)
+
+	if match, _ := previewCategory(1, "Books"); !match.New || match.Name != "books" {
+		t.Errorf("Expected a new books category, got %+v", match)
+	}
+	categoryMu.Lock()
+	found := findCategory(1, "books")
+	categoryMu.Unlock()
+	if found != nil {
+		t.Error("previewCategory registered the category")
+	}
+}
+
+func TestRenameCategoryRewritesHistory(t *testing.T) {
+	inTempDir(t)
+	os.WriteFile("purchase_records.csv", []byte("1,an,35000,cafe,2024-11-01\n1,an\n1,an,40000,Coffee,2024-11-02\n2,binh,10000,cafe,2024-11-02\n"), 0644)
+
+	categoryMu.Lock()
+	registerPastTargets(1)
+	spellings := []string{"cafe"}
+	_, to, err := renameCategory(1, "cafe", "coffee")
+	categoryMu.Unlock()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := rewriteCategory(1, spellings, to); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	purchases, err := loadPurchases()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"coffee", "coffee", "cafe"}
+	for i, p := range purchases {
+		if p.Target != expected[i] {
+			t.Errorf("Expected purchase %d to be %s, got %s", i, expected[i], p.Target)
+		}
+	}
+}
+
+func TestSetParentRefusesCycles(t *testing.T) {
+	inTempDir(t)
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+	categoriesRead = true
+	categories = []*Category{{IDTele: 1, Name: "food"}, {IDTele: 1, Name: "coffee", Parent: "food"}}
+
+	if err := setParent(1, "food", "coffee"); err == nil || len(categories) != 2 {
+		t.Errorf("Expected food under its own subcategory to be refused, got %v", err)
+	}
+	if err := setParent(1, "coffee", "drinks"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(categories) != 3 || findCategory(1, "coffee").Parent != "drinks" {
+		t.Errorf("Expected coffee under a new drinks category, got %d categories", len(categories))
+	}
+}
@@ -0,0 +1,165 @@
+package purchase
+
+import (
+	"Telbot/chart"
+	"Telbot/router"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// registerChart registers /chart, the charts of the user's spending.
+func registerChart(r *router.Router) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:        "/chart",
+		Group:       "Reports",
+		Description: "Charts of the spending per target and per day",
+		Args:        []router.Arg{{Name: "week|month|year", Optional: true}},
+		Note:        "The last month by default. The spending per target is also shown month by month.",
+		Limit:       ReportLimit,
+		Handler: func(m *telebot.Message) {
+			period := "month"
+			if m.Payload != "" {
+				period = m.Payload
+			}
+			if !slices.Contains(periods, period) {
+				utils.Send(bot, m.Chat, "Please specify a valid period: week, month or year.")
+				return
+			}
+
+			purchases, err := userPurchases(m.Sender.ID)
+			if err != nil {
+				utils.Send(bot, m.Chat, "Failed to load purchase records.")
+				return
+			}
+			if calculateSumByPeriod(purchases, period) == 0 {
+				utils.Send(bot, m.Chat, fmt.Sprintf("No purchases in the last %s.", period))
+				return
+			}
+
+			// telebot uploads files from disk only
+			dir, err := os.MkdirTemp("", "telbot-chart")
+			if err != nil {
+				slog.Error("failed to draw charts", "err", err)
+				utils.Send(bot, m.Chat, "Failed to draw the charts.")
+				return
+			}
+			defer os.RemoveAll(dir)
+
+			photos, err := drawCharts(dir, purchases, period, time.Now())
+			if err != nil {
+				slog.Error("failed to draw charts", "err", err)
+				utils.Send(bot, m.Chat, "Failed to draw the charts.")
+				return
+			}
+			for _, photo := range photos {
+				if utils.Send(bot, m.Chat, photo) == nil {
+					utils.Send(bot, m.Chat, "Failed to send the charts.")
+					return
+				}
+			}
+		},
+	})
+}
+
+// drawCharts saves the charts of the period in dir: the share of each
+// target, the spending per day and the spending per target month by month.
+func drawCharts(dir string, purchases []Purchase, period string, now time.Time) ([]*telebot.Photo, error) {
+	var photos []*telebot.Photo
+	add := func(name, caption string, draw func(filename string) error) error {
+		filename := filepath.Join(dir, name)
+		if err := draw(filename); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		photos = append(photos, &telebot.Photo{File: telebot.FromDisk(filename), Caption: caption})
+		return nil
+	}
+
+	shares := calculateTargetPercentage(purchases, period)
+	err := add("targets.png", "Share of each target in the last "+period+".", func(filename string) error {
+		return chart.Pie(filename, "Spending per target, last "+period, shares)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	days, amounts := dailySpending(purchases, period, now)
+	err = add("daily.png", "Spending per day in the last "+period+".", func(filename string) error {
+		return chart.Bars(filename, "Spending per day, last "+period, days, amounts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Always a few months, a week or a month alone has no trend
+	months := 6
+	if period == "year" {
+		months = 12
+	}
+	labels, series := monthlySpending(purchases, months, now)
+	err = add("monthly.png", fmt.Sprintf("Spending per target in the last %d months.", months), func(filename string) error {
+		return chart.Lines(filename, "Spending per target by month", labels, series)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
+
+// dailySpending sums the purchases of each day of the period, today
+// included, labelled like 31/12.
+func dailySpending(purchases []Purchase, period string, now time.Time) ([]string, []float64) {
+	from := periodStart(period, now)
+	first := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, now.Location())
+	var days []string
+	var amounts []float64
+	for day := first; !day.After(now); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("02/01"))
+		amounts = append(amounts, 0)
+	}
+
+	for _, purchase := range purchases {
+		if !purchase.CreatedTime.After(from) || purchase.CreatedTime.After(now) {
+			continue
+		}
+		at := purchase.CreatedTime.In(now.Location())
+		day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, now.Location())
+		// Days, not hours, so a change of daylight saving time doesn't matter
+		i := int(day.Sub(first).Hours()/24 + 0.5)
+		if i >= 0 && i < len(amounts) {
+			amounts[i] += float64(purchase.Amount)
+		}
+	}
+	return days, amounts
+}
+
+// monthlySpending sums the purchases of each target in each of the last
+// months, this one included, labelled like 12/2024.
+func monthlySpending(purchases []Purchase, months int, now time.Time) ([]string, map[string][]float64) {
+	first := time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, now.Location())
+	var labels []string
+	for i := 0; i < months; i++ {
+		labels = append(labels, first.AddDate(0, i, 0).Format("01/2006"))
+	}
+
+	series := map[string][]float64{}
+	for _, purchase := range purchases {
+		at := purchase.CreatedTime.In(now.Location())
+		i := (at.Year()-first.Year())*12 + int(at.Month()-first.Month())
+		if i < 0 || i >= months || at.After(now) {
+			continue
+		}
+		if series[purchase.Target] == nil {
+			series[purchase.Target] = make([]float64, months)
+		}
+		series[purchase.Target][i] += float64(purchase.Amount)
+	}
+	return labels, series
+}
@@ -0,0 +1,116 @@
+package purchase
+
+import (
+	"Telbot/utils"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Compare shows the user's spending per target over the last period (week,
+// month or year) against the period before and the same period a year
+// earlier, the biggest changes first.
+func Compare(userID int, period string, now time.Time) (string, error) {
+	if !slices.Contains(periods, period) {
+		return "", errors.New("please specify a valid period: week, month or year")
+	}
+	purchases, err := userPurchases(userID)
+	if err != nil {
+		return "", errors.New("failed to load purchase records")
+	}
+	return comparePeriods(purchases, period, now), nil
+}
+
+// comparePeriods is Compare on the purchases given.
+func comparePeriods(purchases []Purchase, period string, now time.Time) string {
+	start := periodStart(period, now)
+	current := calculateSumByTargetBetween(purchases, start, now)
+	previous := calculateSumByTargetBetween(purchases, periodStart(period, start), start)
+
+	// For a year, the period before is the same period last year
+	var lastYear map[string]int
+	header := fmt.Sprintf("Last %s compared with the %s before", period, period)
+	if period != "year" {
+		yearAgo := now.AddDate(-1, 0, 0)
+		lastYear = calculateSumByTargetBetween(purchases, periodStart(period, yearAgo), yearAgo)
+		header += fmt.Sprintf(" and the same %s last year", period)
+	}
+
+	targets := map[string]bool{}
+	for _, totals := range []map[string]int{current, previous, lastYear} {
+		for target := range totals {
+			targets[target] = true
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Sprintf("No purchases in the last %s or the %s before.", period, period)
+	}
+
+	// Biggest movers first, against the period before then last year
+	var sorted []string
+	for target := range targets {
+		sorted = append(sorted, target)
+	}
+	movement := func(target string, base map[string]int) int {
+		return abs(current[target] - base[target])
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if movement(a, previous) != movement(b, previous) {
+			return movement(a, previous) > movement(b, previous)
+		}
+		if movement(a, lastYear) != movement(b, lastYear) {
+			return movement(a, lastYear) > movement(b, lastYear)
+		}
+		return a < b
+	})
+
+	message := header + ":\n"
+	describe := func(name string, now, before, yearBefore int) {
+		message += fmt.Sprintf("%s: %s\n  vs the %s before: %s\n", name, utils.FormatNumber(now), period, describeChange(now, before))
+		if lastYear != nil {
+			message += fmt.Sprintf("  vs last year: %s\n", describeChange(now, yearBefore))
+		}
+	}
+	describe("Total", sum(current), sum(previous), sum(lastYear))
+	for _, target := range sorted {
+		describe(target, current[target], previous[target], lastYear[target])
+	}
+	return message
+}
+
+// describeChange shows the change from before to now, e.g. +200K (+25.0%).
+func describeChange(now, before int) string {
+	change := now - before
+	switch {
+	case change == 0:
+		return "no change"
+	case before == 0:
+		return signed(change) + " (new)"
+	}
+	return fmt.Sprintf("%s (%+.1f%%)", signed(change), float64(change)/float64(before)*100)
+}
+
+func signed(amount int) string {
+	if amount < 0 {
+		return "-" + utils.FormatNumber(-amount)
+	}
+	return "+" + utils.FormatNumber(amount)
+}
+
+func sum(totals map[string]int) int {
+	total := 0
+	for _, amount := range totals {
+		total += amount
+	}
+	return total
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
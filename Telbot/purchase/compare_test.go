@@ -0,0 +1,38 @@
+package purchase
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComparePeriods(t *testing.T) {
+	now := time.Date(2024, 11, 20, 12, 0, 0, 0, time.UTC)
+	at := func(month time.Month, day int) time.Time { return time.Date(2024, month, day, 9, 0, 0, 0, time.UTC) }
+	purchases := []Purchase{
+		{Target: "food", Amount: 1_000_000, CreatedTime: at(11, 10)},
+		{Target: "food", Amount: 800_000, CreatedTime: at(10, 5)},
+		{Target: "coffee", Amount: 100_000, CreatedTime: at(11, 1)},
+		{Target: "coffee", Amount: 400_000, CreatedTime: at(10, 15)},
+		{Target: "books", Amount: 50_000, CreatedTime: at(11, 15)},
+		{Target: "food", Amount: 500_000, CreatedTime: time.Date(2023, 11, 1, 9, 0, 0, 0, time.UTC)},
+		{Target: "food", Amount: 70_000, CreatedTime: at(11, 25)}, // After now
+	}
+
+	got := comparePeriods(purchases, "month", now)
+	want := "Last month compared with the month before and the same month last year:\n" +
+		"Total: 1M and 150K\n  vs the month before: -50K (-4.2%)\n  vs last year: +650K (+130.0%)\n" +
+		"coffee: 100K\n  vs the month before: -300K (-75.0%)\n  vs last year: +100K (new)\n" +
+		"food: 1M\n  vs the month before: +200K (+25.0%)\n  vs last year: +500K (+100.0%)\n" +
+		"books: 50K\n  vs the month before: +50K (new)\n  vs last year: +50K (new)\n"
+	if got != want {
+		t.Errorf("comparePeriods =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := comparePeriods(purchases, "year", now); strings.Contains(got, "last year") {
+		t.Errorf("a year is compared with last year twice:\n%s", got)
+	}
+	if got := comparePeriods(nil, "week", now); got != "No purchases in the last week or the week before." {
+		t.Errorf("comparePeriods(nil) = %q", got)
+	}
+}
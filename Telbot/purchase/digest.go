@@ -0,0 +1,200 @@
+package purchase
+
+import (
+	"Telbot/router"
+	"Telbot/utils"
+	"encoding/csv"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The weekly digest goes out on Monday morning, in private, to everyone who
+// recorded purchases the week before. It lists the purchases that were
+// unusually large for their target, see anomaly.go.
+
+// digestHour is the hour of Monday from which the digest is sent.
+const digestHour = 9
+
+// digestFile holds per user the Monday of the last week a digest was
+// delivered for.
+const digestFile = "weekly_digest.csv"
+
+// maxDigestAttempts is how often an undelivered digest is tried again. Users
+// who never opened a private chat with the bot can't receive it.
+const maxDigestAttempts = 3
+
+var (
+	digestWeek     string      // Monday of the week digestAttempts are for
+	digestAttempts map[int]int // Failed sends per user
+)
+
+// StartDigest checks every hour whether the weekly digest is due and sends
+// it.
+func StartDigest(r *router.Router) {
+	bot := r.Bot
+
+	r.Every(time.Hour, func() { sendDigests(bot, time.Now()) })
+}
+
+func sendDigests(bot *telebot.Bot, now time.Time) {
+	monday, _ := calendarPeriod("week", now)
+	if now.Before(monday.Add(digestHour * time.Hour)) {
+		return
+	}
+	week := monday.Format("2006-01-02")
+
+	purchases, err := AllPurchases()
+	if err != nil {
+		slog.Error("failed to load purchases for the weekly digest", "err", err)
+		return
+	}
+	digests := weeklyDigests(purchases, monday.AddDate(0, 0, -7), monday)
+	if len(digests) == 0 {
+		return
+	}
+	sent, err := sentDigests()
+	if err != nil {
+		slog.Error("failed to load the weekly digests sent", "err", err)
+		return
+	}
+	if digestWeek != week {
+		digestWeek, digestAttempts = week, map[int]int{}
+	}
+
+	// Saved once sent, a failed send is tried again at the next check
+	delivered := 0
+	for userID, digest := range digests {
+		if sent[userID] == week || digestAttempts[userID] >= maxDigestAttempts {
+			continue
+		}
+		// A user's private chat has the user's ID
+		if utils.Send(bot, &telebot.Chat{ID: int64(userID)}, digest) == nil {
+			digestAttempts[userID]++
+			slog.Warn("weekly digest not delivered, the user may not have started a private chat with the bot",
+				"user_id", userID, "attempt", digestAttempts[userID])
+			continue
+		}
+		sent[userID] = week
+		delivered++
+	}
+	if delivered == 0 {
+		return
+	}
+	if err := saveSentDigests(sent); err != nil {
+		slog.Error("failed to save the weekly digests sent", "err", err)
+	}
+	slog.Info("sent weekly digests", "users", delivered)
+}
+
+// weeklyDigests writes the digest of the week from the Monday from to the
+// next, for each user who recorded purchases in it. Outliers are judged
+// against the purchases before the week.
+func weeklyDigests(purchases []Purchase, from, to time.Time) map[int]string {
+	first, end := from.Format("2006-01-02"), to.Format("2006-01-02")
+
+	type user struct {
+		total, count int
+		outliers     []string
+	}
+	users := map[int]*user{}
+	past := map[int]map[string][]int{}
+	var week []Purchase
+	for _, p := range purchases {
+		day := p.CreatedTime.Format("2006-01-02")
+		switch {
+		case day < first:
+			if past[p.IDTele] == nil {
+				past[p.IDTele] = map[string][]int{}
+			}
+			past[p.IDTele][p.Target] = append(past[p.IDTele][p.Target], p.Amount)
+		case day < end:
+			week = append(week, p)
+		}
+	}
+	sort.SliceStable(week, func(i, j int) bool { return week[i].CreatedTime.Before(week[j].CreatedTime) })
+
+	for _, p := range week {
+		u := users[p.IDTele]
+		if u == nil {
+			u = &user{}
+			users[p.IDTele] = u
+		}
+		u.total += p.Amount
+		u.count++
+		if usual, ok := outlier(p.Amount, past[p.IDTele][p.Target]); ok {
+			u.outliers = append(u.outliers, fmt.Sprintf("- %s %s: %s, usually around %s",
+				p.CreatedTime.Format("02/01"), p.Target, utils.FormatNumber(p.Amount), utils.FormatNumber(usual)))
+		}
+	}
+
+	digests := map[int]string{}
+	for userID, u := range users {
+		digest := fmt.Sprintf("Weekly digest, %s to %s:\nYou spent %s on %d purchases.\n",
+			from.Format("02/01"), to.AddDate(0, 0, -1).Format("02/01"), utils.FormatNumber(u.total), u.count)
+		if len(u.outliers) == 0 {
+			digest += "Nothing unusual."
+		} else {
+			digest += "Unusual purchases:\n"
+			for _, line := range u.outliers {
+				digest += line + "\n"
+			}
+		}
+		digests[userID] = digest
+	}
+	return digests
+}
+
+// sentDigests returns the Monday of the last digest delivered, per user.
+func sentDigests() (map[int]string, error) {
+	sent := map[int]string{}
+	file, err := os.Open(digestFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sent, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		if userID, err := strconv.Atoi(record[0]); err == nil {
+			sent[userID] = record[1]
+		}
+	}
+	return sent, nil
+}
+
+func saveSentDigests(sent map[int]string) error {
+	var users []int
+	for userID := range sent {
+		users = append(users, userID)
+	}
+	sort.Ints(users)
+
+	file, err := os.Create(digestFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	for _, userID := range users {
+		writer.Write([]string{strconv.Itoa(userID), sent[userID]})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,197 @@
+package purchase
+
+import (
+	"Telbot/utils"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Budgets are checked over the last week, month or year. Forecasts are for
+// the current calendar week (from Monday), month or year instead, so that
+// the period has an end to project to.
+
+// historyWeeks is how far back the spending per weekday is averaged.
+const historyWeeks = 12
+
+// Projection is where a budget is heading by the end of its period.
+type Projection struct {
+	Budget    Budget
+	Spent     int       // Since the start of the period
+	Projected int       // By its end
+	End       time.Time // Exclusive
+	Reliable  bool      // Whether there is enough data to warn about it
+}
+
+// Over is how much the budget is projected to be exceeded by, or zero.
+func (p Projection) Over() int {
+	return max(p.Projected-p.Budget.Amount, 0)
+}
+
+// Forecast shows where each of the user's budgets is heading.
+func Forecast(userID int, now time.Time) (string, error) {
+	projections, err := projectBudgets(userID, now)
+	if err != nil {
+		return "", errors.New("failed to load budgets")
+	}
+	if len(projections) == 0 {
+		return "You have no budgets set.", nil
+	}
+
+	message := "Budget Forecast:\n"
+	for _, p := range projections {
+		message += fmt.Sprintf("%s: spent %s of %s this %s, heading for %s by %s",
+			p.Budget.Category, utils.FormatNumber(p.Spent), utils.FormatNumber(p.Budget.Amount), p.Budget.Duration,
+			utils.FormatNumber(p.Projected), p.End.AddDate(0, 0, -1).Format("02/01"))
+		if p.Over() > 0 {
+			message += fmt.Sprintf(" ⚠️ %s over", utils.FormatNumber(p.Over()))
+		}
+		if !p.Reliable {
+			message += " (too early to tell)"
+		}
+		message += "\n"
+	}
+	return message, nil
+}
+
+// projectionAlert warns about the first budget that is reliably heading
+// over, before its threshold is reached.
+func projectionAlert(userID int, now time.Time) string {
+	projections, err := projectBudgets(userID, now)
+	if err != nil {
+		return ""
+	}
+	for _, p := range projections {
+		if p.Reliable && p.Over() > 0 {
+			return fmt.Sprintf("📈 At this rate you'll spend %s of your %s %s budget by the end of the %s.",
+				utils.FormatNumber(p.Projected), utils.FormatNumber(p.Budget.Amount), p.Budget.Category, p.Budget.Duration)
+		}
+	}
+	return ""
+}
+
+func projectBudgets(userID int, now time.Time) ([]Projection, error) {
+	budgets, err := LoadBudgets(userID)
+	if err != nil || len(budgets) == 0 {
+		return nil, err
+	}
+	purchases, err := AllPurchases()
+	if err != nil {
+		return nil, err
+	}
+
+	var mine []Purchase
+	for _, purchase := range purchases {
+		if purchase.IDTele == userID {
+			mine = append(mine, purchase)
+		}
+	}
+
+	var projections []Projection
+	for _, budget := range budgets {
+		// A budget on a category also covers its subcategories
+		categoryMu.Lock()
+		targets := descendants(userID, canonicalCategory(userID, budget.Category))
+		categoryMu.Unlock()
+
+		projections = append(projections, project(budget, mine, targets, now))
+	}
+	return projections, nil
+}
+
+// project adds to the spending so far the expected spending of the rest of
+// the period: the pace so far, averaged with the usual spending of each
+// weekday when there are a few weeks of history. purchases are the user's,
+// of every target, to tell since when they have been recorded.
+func project(budget Budget, purchases []Purchase, targets []string, now time.Time) Projection {
+	start, end := calendarPeriod(budget.Duration, now)
+	p := Projection{Budget: budget, End: end}
+	if len(purchases) == 0 {
+		return p
+	}
+
+	first := purchases[0].CreatedTime
+	for _, purchase := range purchases {
+		if purchase.CreatedTime.Before(first) {
+			first = purchase.CreatedTime
+		}
+	}
+	firstDay := startOfDay(first, now.Location())
+
+	historyFrom := start.AddDate(0, 0, -7*historyWeeks)
+	if historyFrom.Before(firstDay) {
+		historyFrom = firstDay
+	}
+	var byWeekday [7]float64
+	for _, purchase := range purchases {
+		if !slices.Contains(targets, purchase.Target) || purchase.CreatedTime.After(now) {
+			continue
+		}
+		switch {
+		case !purchase.CreatedTime.Before(start):
+			p.Spent += purchase.Amount
+		case !purchase.CreatedTime.Before(historyFrom):
+			byWeekday[purchase.CreatedTime.In(now.Location()).Weekday()] += float64(purchase.Amount)
+		}
+	}
+
+	// The pace is measured since the start of the period, or since the
+	// first purchase of a new user
+	tracked := start
+	if firstDay.After(start) {
+		tracked = firstDay
+	}
+	elapsed := now.Sub(tracked).Hours() / 24
+	remaining := end.Sub(now).Hours() / 24
+	pace := float64(p.Spent) / max(elapsed, 1) * remaining
+
+	historyDays := start.Sub(historyFrom).Hours() / 24
+	p.Reliable = historyDays >= 14 || elapsed >= 2 && elapsed >= end.Sub(start).Hours()/24/10
+	if historyDays < 14 {
+		p.Projected = p.Spent + int(pace)
+		return p
+	}
+
+	// Average spending of each weekday over the history, then over the
+	// remaining days, today's rest included
+	var weekdays [7]float64
+	for day := historyFrom; day.Before(start); day = day.AddDate(0, 0, 1) {
+		weekdays[day.Weekday()]++
+	}
+	usual := 0.0
+	tomorrow := startOfDay(now, now.Location()).AddDate(0, 0, 1)
+	for day := startOfDay(now, now.Location()); day.Before(end); day = day.AddDate(0, 0, 1) {
+		if weekdays[day.Weekday()] == 0 {
+			continue
+		}
+		share := 1.0
+		if day.Before(now) {
+			share = tomorrow.Sub(now).Hours() / 24
+		}
+		usual += byWeekday[day.Weekday()] / weekdays[day.Weekday()] * share
+	}
+	p.Projected = p.Spent + int((pace+usual)/2)
+	return p
+}
+
+// calendarPeriod is the week, month or year now is in, as [start, end).
+func calendarPeriod(period string, now time.Time) (time.Time, time.Time) {
+	today := startOfDay(now, now.Location())
+	switch period {
+	case "week":
+		start := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	case "year":
+		start := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(1, 0, 0)
+	default:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
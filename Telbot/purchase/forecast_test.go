@@ -0,0 +1,56 @@
+package purchase
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProject(t *testing.T) {
+	date := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	food := Budget{Category: "food", Amount: 3_000_000, Duration: "month"}
+
+	// A new user: too early to tell
+	today := []Purchase{{Target: "food", Amount: 2_000_000, CreatedTime: date(11, 11, 9)}}
+	if p := project(food, today, []string{"food"}, date(11, 11, 12)); p.Reliable {
+		t.Errorf("projected %+v after one purchase", p)
+	}
+
+	// No history: 1.5M in 10.5 days, 19.5 days to go
+	purchases := []Purchase{
+		{Target: "food", Amount: 1_000_000, CreatedTime: date(11, 1, 9)},
+		{Target: "food", Amount: 500_000, CreatedTime: date(11, 8, 9)},
+		{Target: "books", Amount: 900_000, CreatedTime: date(11, 9, 9)},
+	}
+	p := project(food, purchases, []string{"food"}, date(11, 11, 12))
+	if p.Spent != 1_500_000 || p.Projected != 4_285_714 || !p.Reliable || p.Over() != 1_285_714 || !p.End.Equal(date(12, 1, 0)) {
+		t.Errorf("project = %+v", p)
+	}
+
+	// 100K every Saturday for the last 12 weeks and nothing this week
+	// yet: half the pace, half the usual Saturday
+	coffee := Budget{Category: "coffee", Amount: 80_000, Duration: "week"}
+	purchases = []Purchase{{Target: "books", Amount: 1, CreatedTime: date(1, 1, 9)}}
+	for day := date(8, 24, 9); day.Before(date(11, 11, 0)); day = day.AddDate(0, 0, 7) {
+		purchases = append(purchases, Purchase{Target: "coffee", Amount: 100_000, CreatedTime: day})
+	}
+	p = project(coffee, purchases, []string{"coffee"}, date(11, 13, 0))
+	if p.Spent != 0 || p.Projected != 50_000 || !p.Reliable || !p.End.Equal(date(11, 18, 0)) {
+		t.Errorf("project = %+v", p)
+	}
+}
+
+func TestCalendarPeriod(t *testing.T) {
+	now := time.Date(2024, 11, 17, 20, 0, 0, 0, time.UTC) // A Sunday
+	for period, want := range map[string][2]string{
+		"week":  {"2024-11-11", "2024-11-18"},
+		"month": {"2024-11-01", "2024-12-01"},
+		"year":  {"2024-01-01", "2025-01-01"},
+	} {
+		start, end := calendarPeriod(period, now)
+		if start.Format("2006-01-02") != want[0] || end.Format("2006-01-02") != want[1] {
+			t.Errorf("%s: %s to %s, want %s to %s", period, start, end, want[0], want[1])
+		}
+	}
+}
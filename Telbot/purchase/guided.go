@@ -0,0 +1,247 @@
+package purchase
+
+import (
+	"Telbot/dialog"
+	"Telbot/router"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Guided flows ask for one answer at a time when /purchase or /setBudget
+// is sent without arguments. Categories, dates and periods are picked with
+// inline buttons, anything else can be typed.
+
+const (
+	purchaseFlow = "purchase"
+	budgetFlow   = "budget"
+
+	// maxCategoryButtons keeps the keyboard readable, other categories can be typed
+	maxCategoryButtons = 12
+)
+
+var periods = []string{"week", "month", "year"}
+
+var (
+	categoryButton = telebot.InlineButton{Unique: "pickCategory"}
+	dateButton     = telebot.InlineButton{Unique: "pickDate"}
+	periodButton   = telebot.InlineButton{Unique: "pickPeriod"}
+	guidedSave     = telebot.InlineButton{
+		Unique: "guidedSave",
+		Text:   "✅ Save",
+	}
+)
+
+func registerGuidedFlows(r *router.Router) {
+	bot := r.Bot
+
+	dialog.Register(purchaseFlow, func(m *telebot.Message, c *dialog.Conversation) {
+		switch c.Step() {
+		case "amount":
+			askAmount(bot, m, c, "Which category? Pick one or type a new one.")
+		case "category":
+			pickCategory(bot, m.Chat, nil, c, m.Text)
+		case "date":
+			day, err := utils.ParseDate(m.Text, time.Now())
+			if err != nil {
+				utils.Send(bot, m.Chat, "Please type a date like 2024-12-01 or 1/12, or pick one.", dialog.CancelKeyboard())
+				return
+			}
+			confirmPurchase(bot, m.Chat, nil, c, day)
+		}
+	})
+
+	dialog.Register(budgetFlow, func(m *telebot.Message, c *dialog.Conversation) {
+		switch c.Step() {
+		case "amount":
+			askAmount(bot, m, c, "Which category is the budget for? Pick one or type a new one.")
+		case "category":
+			pickCategory(bot, m.Chat, nil, c, m.Text)
+		case "period":
+			setGuidedBudget(bot, m.Chat, nil, c, m.Text)
+		}
+	})
+
+	r.HandleCallback(&categoryButton, func(cb *telebot.Callback) {
+		c := callbackConversation(bot, cb, "category")
+		if c == nil {
+			return
+		}
+		if category, ok := c.Option(cb.Data); ok {
+			pickCategory(bot, cb.Message.Chat, cb.Message, c, category)
+		}
+	})
+
+	r.HandleCallback(&dateButton, func(cb *telebot.Callback) {
+		c := callbackConversation(bot, cb, "date")
+		if c == nil {
+			return
+		}
+		day, err := utils.ParseDate(cb.Data, time.Now())
+		if err != nil {
+			return
+		}
+		confirmPurchase(bot, cb.Message.Chat, cb.Message, c, day)
+	})
+
+	r.HandleCallback(&periodButton, func(cb *telebot.Callback) {
+		if c := callbackConversation(bot, cb, "period"); c != nil {
+			setGuidedBudget(bot, cb.Message.Chat, cb.Message, c, cb.Data)
+			return
+		}
+
+		// Period picker of /targetSummary, usable by anyone in the chat
+		if cb.Message != nil && slices.Contains(periods, cb.Data) {
+			text, err := TargetSummary(cb.Data)
+			if err != nil {
+				utils.Send(bot, cb.Message.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Edit(bot, cb.Message, text)
+		}
+	})
+
+	r.HandleCallback(&guidedSave, func(cb *telebot.Callback) {
+		c := callbackConversation(bot, cb, "confirm")
+		if c == nil {
+			return
+		}
+		dialog.End(cb.Sender.ID)
+
+		match, err := ResolveCategory(cb.Sender.ID, c.Get("category"))
+		if err != nil {
+			utils.Send(bot, cb.Message.Chat, "Failed to load categories.")
+			return
+		}
+		amount, _ := strconv.Atoi(c.Get("amount"))
+		day, _ := time.Parse("2006-01-02", c.Get("date"))
+		purchase := Purchase{
+			IDTele:      cb.Sender.ID,
+			AccountName: cb.Sender.Username,
+			Amount:      amount,
+			Target:      match.Name,
+			CreatedTime: day,
+		}
+		if err := savePurchaseToFile(purchase); err != nil {
+			utils.Send(bot, cb.Message.Chat, "Failed to save purchase record.")
+			return
+		}
+
+		utils.Edit(bot, cb.Message, "Recorded purchase:\n"+describePurchase(purchase))
+		sendPurchaseCheck(bot, cb.Message.Chat, purchase)
+		sendBudgetAlert(bot, cb.Message.Chat, purchase.IDTele)
+	})
+}
+
+// callbackConversation answers the callback and returns the presser's
+// conversation if it is waiting for the step the button belongs to.
+func callbackConversation(bot *telebot.Bot, cb *telebot.Callback, step string) *dialog.Conversation {
+	utils.Respond(bot, cb)
+	if cb.Message == nil {
+		return nil
+	}
+	for _, flow := range []string{purchaseFlow, budgetFlow} {
+		if c := dialog.Current(cb.Sender.ID, flow); c != nil && c.Step() == step && c.ChatID == cb.Message.Chat.ID {
+			return c
+		}
+	}
+	return nil
+}
+
+func startPurchaseFlow(bot *telebot.Bot, m *telebot.Message) {
+	dialog.Start(m.Sender.ID, m.Chat.ID, purchaseFlow, "amount")
+	utils.Send(bot, m.Chat, "How much did you spend? e.g. 35k", dialog.CancelKeyboard())
+}
+
+func startBudgetFlow(bot *telebot.Bot, m *telebot.Message) {
+	dialog.Start(m.Sender.ID, m.Chat.ID, budgetFlow, "amount")
+	utils.Send(bot, m.Chat, "How much is the budget? e.g. 2M", dialog.CancelKeyboard())
+}
+
+func askAmount(bot *telebot.Bot, m *telebot.Message, c *dialog.Conversation, question string) {
+	amount, err := utils.ParseAmount(m.Text)
+	if err != nil || amount <= 0 {
+		utils.Send(bot, m.Chat, "Please type a valid amount, e.g. 35k or 1.5M.", dialog.CancelKeyboard())
+		return
+	}
+	c.Set("amount", strconv.Itoa(amount))
+	c.Next("category")
+	utils.Send(bot, m.Chat, question, c.Keyboard(categoryButton, categoryOptions(m.Sender.ID), 3))
+}
+
+// pickCategory stores the chosen category and asks the next question. When
+// the answer comes from a button, edit is the message holding the keyboard.
+func pickCategory(bot *telebot.Bot, chat *telebot.Chat, edit *telebot.Message, c *dialog.Conversation, typed string) {
+	// A new category is registered when the purchase or budget is saved
+	match, err := previewCategory(c.UserID, typed)
+	if err != nil {
+		utils.Send(bot, chat, "Failed to load categories.")
+		return
+	}
+	c.Set("category", match.Name)
+
+	if c.Flow == budgetFlow {
+		c.Next("period")
+		dialog.Reply(bot, chat, edit, fmt.Sprintf("Category: %s.%s\nHow often does the budget reset?", match.Name, describeMatch(match, typed)),
+			dialog.Keyboard(periodButton, periods, 3))
+		return
+	}
+
+	c.Next("date")
+	now := time.Now()
+	keyboard := dialog.Keyboard(dateButton, []string{"today", "yesterday"}, 2)
+	dialog.Reply(bot, chat, edit, fmt.Sprintf("Category: %s.%s\nWhen was it? Pick a day or type a date (%s).", match.Name, describeMatch(match, typed), now.Format("2006-01-02")), keyboard)
+}
+
+func confirmPurchase(bot *telebot.Bot, chat *telebot.Chat, edit *telebot.Message, c *dialog.Conversation, day time.Time) {
+	c.Set("date", day.Format("2006-01-02"))
+	c.Next("confirm")
+
+	amount, _ := strconv.Atoi(c.Get("amount"))
+	purchase := Purchase{Amount: amount, Target: c.Get("category"), CreatedTime: day}
+	dialog.Reply(bot, chat, edit, "Save this purchase?\n"+describePurchase(purchase), &telebot.ReplyMarkup{
+		InlineKeyboard: [][]telebot.InlineButton{{guidedSave, dialog.CancelButton}},
+	})
+}
+
+func setGuidedBudget(bot *telebot.Bot, chat *telebot.Chat, edit *telebot.Message, c *dialog.Conversation, period string) {
+	if !slices.Contains(periods, period) {
+		utils.Send(bot, chat, "Please pick week, month or year.", dialog.Keyboard(periodButton, periods, 3))
+		return
+	}
+	dialog.End(c.UserID)
+
+	amount, _ := strconv.Atoi(c.Get("amount"))
+	reply, err := SetBudget(c.UserID, amount, c.Get("category"), period)
+	if err != nil {
+		utils.Send(bot, chat, utils.Sentence(err))
+		return
+	}
+	dialog.Reply(bot, chat, edit, reply, nil)
+}
+
+// categoryOptions lists the user's top level categories for the keyboard.
+func categoryOptions(userID int) []string {
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+
+	if loadCategories() != nil || registerPastTargets(userID) != nil {
+		return nil
+	}
+
+	var names []string
+	for _, c := range userCategories(userID) {
+		if c.Parent == "" {
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > maxCategoryButtons {
+		names = names[:maxCategoryButtons]
+	}
+	return names
+}
@@ -0,0 +1,467 @@
+package purchase
+
+import (
+	"Telbot/dialog"
+	"Telbot/router"
+	"Telbot/statement"
+	"Telbot/utils"
+	"encoding/csv"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /import reads a bank statement the user uploads, as a CSV export, OFX or
+// QIF file. Money spent becomes purchases with a proposed category, those
+// already recorded are flagged as duplicates, and nothing is saved before
+// the user confirms.
+
+const (
+	importFlow   = "import"
+	mappingsFile = "import_mappings.csv"
+
+	maxStatementSize = 2 << 20          // Years of transactions fit in much less
+	maxPreviewRows   = 30               // A Telegram message holds 4096 characters
+	downloadTimeout  = 30 * time.Second // Per statement file
+)
+
+const importUsage = "Usage: /import [column mapping]\n" +
+	"Send the statement file after /import, or with /import as its caption. " +
+	"Columns of CSV files are found by their header, otherwise map them by name or number, e.g.\n" +
+	"/import date=Ngày GD, debit=Ghi nợ, credit=Ghi có, description=Nội dung, format=dd/mm/yyyy\n" +
+	"The mapping is kept for your next imports, /import auto goes back to finding the columns."
+
+var (
+	importSaveButton = telebot.InlineButton{Unique: "importSave"}
+	importAllButton  = telebot.InlineButton{Unique: "importAll"}
+)
+
+// importRow is a purchase read from a statement.
+type importRow struct {
+	Purchase
+	Duplicate bool // The same amount is already recorded on that day
+}
+
+type pendingImport struct {
+	rows     []importRow
+	received int // Money received, left out
+}
+
+var (
+	importMu sync.Mutex
+	imports  = map[int]*pendingImport{} // By user, while their import flow is at "review"
+
+	mappingsMu sync.Mutex // Guards the mappings file
+)
+
+func registerImport(r *router.Router) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:        "/import",
+		Group:       "Purchases",
+		Description: "Import purchases from a bank statement",
+		Args:        []router.Arg{{Name: "column mapping", Kind: router.Text, Optional: true}},
+		Note:        "Then send the CSV, OFX or QIF file.",
+		Limit:       ReportLimit,
+		Handler: func(m *telebot.Message) {
+			if !setMapping(bot, m, m.Payload) {
+				return
+			}
+			dialog.Start(m.Sender.ID, m.Chat.ID, importFlow, "file")
+			utils.Send(bot, m.Chat, "Send me your bank statement as a file: a CSV export, OFX or QIF.", dialog.CancelKeyboard())
+		},
+	})
+
+	r.Handle(router.Command{
+		Name:   telebot.OnDocument,
+		Hidden: true,
+		Limit:  ReportLimit,
+		Handler: func(m *telebot.Message) {
+			caption := strings.TrimSpace(m.Caption)
+			if command, mapping, _ := strings.Cut(caption, " "); strings.EqualFold(command, "/import") {
+				if !setMapping(bot, m, mapping) {
+					return
+				}
+			} else if c := dialog.Current(m.Sender.ID, importFlow); c == nil || c.Step() != "file" || c.ChatID != m.Chat.ID {
+				if m.Chat.Type == telebot.ChatPrivate {
+					utils.Send(bot, m.Chat, "To import a bank statement, send /import first.")
+				}
+				return
+			}
+			importStatement(bot, m)
+		},
+	})
+
+	dialog.Register(importFlow, func(m *telebot.Message, c *dialog.Conversation) {
+		if c.Step() != "review" {
+			utils.Send(bot, m.Chat, "Please send the statement as a file.", dialog.CancelKeyboard())
+			return
+		}
+		correctCategory(bot, m)
+	})
+
+	save := func(all bool) func(cb *telebot.Callback) {
+		return func(cb *telebot.Callback) {
+			c := dialog.Current(cb.Sender.ID, importFlow)
+			importMu.Lock()
+			pending := imports[cb.Sender.ID]
+			delete(imports, cb.Sender.ID)
+			importMu.Unlock()
+			if c == nil || c.Step() != "review" || pending == nil || cb.Message == nil {
+				utils.Respond(bot, cb, &telebot.CallbackResponse{Text: "This import is no longer pending."})
+				return
+			}
+			dialog.End(cb.Sender.ID)
+			utils.Respond(bot, cb)
+			utils.Edit(bot, cb.Message, savePending(pending, all))
+			sendBudgetAlert(bot, cb.Message.Chat, cb.Sender.ID)
+		}
+	}
+	r.HandleCallback(&importSaveButton, save(false))
+	r.HandleCallback(&importAllButton, save(true))
+}
+
+// setMapping saves the column mapping given with /import, if any. It
+// returns false after explaining what is wrong with it.
+func setMapping(bot *telebot.Bot, m *telebot.Message, text string) bool {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return true
+	}
+
+	var mapping statement.Mapping
+	if !strings.EqualFold(text, "auto") {
+		var err error
+		if mapping, err = statement.ParseMapping(text); err != nil {
+			utils.Send(bot, m.Chat, utils.Sentence(err)+"\n"+importUsage)
+			return false
+		}
+	}
+	if err := saveMapping(m.Sender.ID, mapping); err != nil {
+		slog.Error("failed to save the import mapping", "user_id", m.Sender.ID, "err", err)
+		utils.Send(bot, m.Chat, "Failed to save the column mapping.")
+		return false
+	}
+	return true
+}
+
+// importStatement downloads the statement, reads it and asks the user to
+// review the purchases found.
+func importStatement(bot *telebot.Bot, m *telebot.Message) {
+	if m.Document.FileSize > maxStatementSize {
+		utils.Send(bot, m.Chat, fmt.Sprintf("The file is too large, the limit is %d MB.", maxStatementSize>>20))
+		return
+	}
+	data, err := download(bot, m.Document.FileID)
+	if err != nil {
+		slog.Error("failed to download the statement", "user_id", m.Sender.ID, "err", err)
+		utils.Send(bot, m.Chat, "Failed to download the file, please send it again.")
+		return
+	}
+
+	mapping, err := loadMapping(m.Sender.ID)
+	if err != nil {
+		utils.Send(bot, m.Chat, "Failed to load your column mapping.")
+		return
+	}
+	transactions, err := statement.Parse(m.Document.FileName, data, mapping)
+	if err != nil {
+		utils.Send(bot, m.Chat, fmt.Sprintf("I couldn't read %s: %v.\n%s", m.Document.FileName, err, importUsage))
+		return
+	}
+
+	pending, err := proposePurchases(m.Sender.ID, m.Sender.Username, transactions)
+	if err != nil {
+		utils.Send(bot, m.Chat, "Failed to load purchase records.")
+		return
+	}
+	if len(pending.rows) == 0 {
+		dialog.End(m.Sender.ID)
+		utils.Send(bot, m.Chat, fmt.Sprintf("The statement holds no money spent, only %d payments received.", pending.received))
+		return
+	}
+
+	importMu.Lock()
+	imports[m.Sender.ID] = pending
+	importMu.Unlock()
+
+	if c := dialog.Current(m.Sender.ID, importFlow); c != nil && c.ChatID == m.Chat.ID {
+		c.Next("review")
+	} else {
+		dialog.Start(m.Sender.ID, m.Chat.ID, importFlow, "review") // Sent with /import as the caption
+	}
+	text, markup := describeImport(pending)
+	utils.Send(bot, m.Chat, text, markup)
+}
+
+func download(bot *telebot.Bot, fileID string) ([]byte, error) {
+	url, err := bot.FileURLByID(fileID)
+	if err != nil {
+		return nil, err
+	}
+	// The bot's own transport, it talks to Telegram through http.DefaultClient
+	client := &http.Client{Transport: http.DefaultClient.Transport, Timeout: downloadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxStatementSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxStatementSize {
+		return nil, fmt.Errorf("download failed: larger than %d bytes", maxStatementSize)
+	}
+	return data, nil
+}
+
+// proposePurchases turns the money spent into purchases of the user, with
+// a category for each and duplicates of recorded purchases flagged.
+func proposePurchases(userID int, account string, transactions []statement.Transaction) (*pendingImport, error) {
+	purchases, err := loadPurchases()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Same day and amount as a recorded purchase, each of them matched once
+	recorded := map[string]int{}
+	// Descriptions imported before, normalized, and the category they got
+	learned := map[string]string{}
+	for _, p := range purchases {
+		if p.IDTele != userID {
+			continue
+		}
+		recorded[p.CreatedTime.Format("2006-01-02")+" "+strconv.Itoa(p.Amount)]++
+		if p.Note != "" {
+			learned[normalizeCategory(p.Note)] = p.Target
+		}
+	}
+
+	pending := &pendingImport{}
+	for _, t := range transactions {
+		if t.Amount >= 0 {
+			pending.received++
+			continue
+		}
+		row := importRow{Purchase: Purchase{
+			IDTele:      userID,
+			AccountName: account,
+			Amount:      -t.Amount,
+			Target:      proposeCategory(userID, t, learned),
+			CreatedTime: t.Date,
+			Note:        t.Description,
+		}}
+		key := row.CreatedTime.Format("2006-01-02") + " " + strconv.Itoa(row.Amount)
+		if recorded[key] > 0 {
+			recorded[key]--
+			row.Duplicate = true
+		}
+		pending.rows = append(pending.rows, row)
+	}
+	return pending, nil
+}
+
+// proposeCategory picks the category of an imported purchase: the one a
+// purchase with the same description got before, the category given in
+// the statement, or a category named in the description. Anything else is
+// "other", to be corrected during the review.
+func proposeCategory(userID int, t statement.Transaction, learned map[string]string) string {
+	if target, ok := learned[normalizeCategory(t.Description)]; ok {
+		return target
+	}
+
+	categoryMu.Lock()
+	var given *Category
+	if loadCategories() == nil && t.Category != "" {
+		given = findCategory(userID, t.Category)
+	}
+	categoryMu.Unlock()
+	if given != nil {
+		return given.Name
+	}
+
+	// Card payments read like "HIGHLANDS COFFEE HCM 0412"
+	words := strings.Fields(strings.NewReplacer("*", " ", "/", " ", "-", " ").Replace(t.Description))
+	if start, end := matchCategoryRun(userID, words, make([]bool, len(words))); start >= 0 {
+		if match, err := ResolveCategory(userID, strings.Join(words[start:end], " ")); err == nil {
+			return match.Name
+		}
+	}
+	return "other"
+}
+
+// describeImport lists the purchases to review, with the buttons to save
+// them.
+func describeImport(pending *pendingImport) (string, *telebot.ReplyMarkup) {
+	duplicates := 0
+	for _, row := range pending.rows {
+		if row.Duplicate {
+			duplicates++
+		}
+	}
+
+	text := fmt.Sprintf("Found %d purchases", len(pending.rows))
+	var notes []string
+	if duplicates > 0 {
+		notes = append(notes, fmt.Sprintf("%d already recorded", duplicates))
+	}
+	if pending.received > 0 {
+		notes = append(notes, fmt.Sprintf("%d payments received left out", pending.received))
+	}
+	if len(notes) > 0 {
+		text += " (" + strings.Join(notes, ", ") + ")"
+	}
+	text += ":\n"
+
+	for i, row := range pending.rows {
+		if i == maxPreviewRows {
+			text += fmt.Sprintf("… and %d more\n", len(pending.rows)-maxPreviewRows)
+			break
+		}
+		text += fmt.Sprintf("%d. %s %s %s → %s", i+1, row.CreatedTime.Format("2006-01-02"), utils.FormatNumber(row.Amount), row.Note, row.Target)
+		if row.Duplicate {
+			text += " (duplicate)"
+		}
+		text += "\n"
+	}
+	text += "\nTo change a category, type its number and the category, e.g. \"2 food\"."
+
+	save, all := importSaveButton, importAllButton
+	save.Text = fmt.Sprintf("✅ Save %d", len(pending.rows)-duplicates)
+	all.Text = fmt.Sprintf("Save all %d", len(pending.rows))
+	buttons := []telebot.InlineButton{save}
+	if duplicates == len(pending.rows) {
+		buttons = nil // Saving nothing isn't worth a button
+	}
+	if duplicates > 0 {
+		buttons = append(buttons, all)
+	}
+	return text, &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{buttons, {dialog.CancelButton}}}
+}
+
+// correctCategory handles "2 food" typed during the review.
+func correctCategory(bot *telebot.Bot, m *telebot.Message) {
+	number, category, _ := strings.Cut(strings.TrimSpace(m.Text), " ")
+	n, err := strconv.Atoi(number)
+
+	importMu.Lock()
+	pending := imports[m.Sender.ID]
+	importMu.Unlock()
+	if pending == nil {
+		return
+	}
+	if err != nil || n < 1 || n > len(pending.rows) || strings.TrimSpace(category) == "" {
+		utils.Send(bot, m.Chat, fmt.Sprintf("Type the number of a purchase, 1 to %d, and its category, e.g. \"2 food\".", len(pending.rows)))
+		return
+	}
+
+	// Registered by savePending, the import may still be cancelled
+	match, err := previewCategory(m.Sender.ID, category)
+	if err != nil {
+		utils.Send(bot, m.Chat, "Failed to load categories.")
+		return
+	}
+	importMu.Lock()
+	pending.rows[n-1].Target = match.Name
+	importMu.Unlock()
+
+	text, markup := describeImport(pending)
+	utils.Send(bot, m.Chat, text, markup)
+}
+
+// savePending records the purchases, duplicates only when all is set, and
+// returns the reply.
+func savePending(pending *pendingImport, all bool) string {
+	saved := 0
+	for _, row := range pending.rows {
+		if row.Duplicate && !all {
+			continue
+		}
+		// Proposals are existing categories, except "other" the first time
+		purchase := row.Purchase
+		if match, err := ResolveCategory(purchase.IDTele, purchase.Target); err == nil {
+			purchase.Target = match.Name
+		}
+		if err := savePurchaseToFile(purchase); err != nil {
+			slog.Error("failed to save an imported purchase", "user_id", purchase.IDTele, "err", err)
+			return fmt.Sprintf("Imported %d purchases, then failed to save the rest.", saved)
+		}
+		saved++
+	}
+	return fmt.Sprintf("Imported %d purchases.", saved)
+}
+
+// loadMapping returns the column mapping the user set, if any.
+func loadMapping(userID int) (statement.Mapping, error) {
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+
+	records, err := readMappings()
+	if err != nil {
+		return statement.Mapping{}, err
+	}
+	for _, record := range records {
+		if record[0] == strconv.Itoa(userID) {
+			return statement.ParseMapping(record[1])
+		}
+	}
+	return statement.Mapping{}, nil
+}
+
+// saveMapping replaces the user's column mapping, an empty one removes it.
+func saveMapping(userID int, mapping statement.Mapping) error {
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+
+	records, err := readMappings()
+	if err != nil {
+		return err
+	}
+	id := strconv.Itoa(userID)
+	kept := records[:0]
+	for _, record := range records {
+		if record[0] != id {
+			kept = append(kept, record)
+		}
+	}
+	if mapping != (statement.Mapping{}) {
+		kept = append(kept, []string{id, mapping.String()})
+	}
+
+	file, err := os.Create(mappingsFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.WriteAll(kept)
+	return writer.Error()
+}
+
+// readMappings reads every mapping. The caller must hold mappingsMu.
+func readMappings() ([][]string, error) {
+	file, err := os.Open(mappingsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	return reader.ReadAll()
+}
@@ -1,22 +1,38 @@
 package purchase
 
 import (
+	"Telbot/metrics"
 	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 func loadPurchases() ([]Purchase, error) {
+	defer metrics.StoreDuration.Time("purchases", "read")()
+
 	file, err := os.Open("purchase_records.csv")
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+	if err := loadCategories(); err != nil {
+		return nil, err
+	}
+
 	var purchases []Purchase
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // The note column was added later
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
@@ -26,17 +42,118 @@ func loadPurchases() ([]Purchase, error) {
 			return nil, err
 		}
 
-		idTele, _ := strconv.Atoi(record[0])
-		amount, _ := strconv.Atoi(record[2])
-		createdTime, _ := time.Parse("2006-01-02", record[4])
-
-		purchases = append(purchases, Purchase{
-			IDTele:      idTele,
-			AccountName: record[1],
-			Amount:      amount,
-			Target:      record[3],
-			CreatedTime: createdTime,
-		})
+		purchase, err := parsePurchase(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", "purchase_records.csv", "line", line, "err", err)
+			continue
+		}
+		purchase.Target = canonicalCategory(purchase.IDTele, purchase.Target)
+		purchases = append(purchases, purchase)
 	}
 	return purchases, nil
 }
+
+// parsePurchase reads a line of purchase_records.csv, see purchaseRecord.
+func parsePurchase(record []string) (Purchase, error) {
+	if len(record) < 5 {
+		return Purchase{}, fmt.Errorf("%d fields, want at least 5", len(record))
+	}
+	idTele, idErr := strconv.Atoi(record[0])
+	amount, amountErr := strconv.Atoi(record[2])
+	createdTime, dateErr := time.Parse("2006-01-02", record[4])
+	if err := errors.Join(idErr, amountErr, dateErr); err != nil {
+		return Purchase{}, err
+	}
+	note := ""
+	if len(record) > 5 {
+		note = record[5]
+	}
+
+	return Purchase{
+		IDTele:      idTele,
+		AccountName: record[1],
+		Amount:      amount,
+		Target:      record[3],
+		CreatedTime: createdTime,
+		Note:        note,
+	}, nil
+}
+
+// rewriteCategory renames the category in the stored purchases and budgets
+// of a user. Every spelling in names is replaced.
+func rewriteCategory(userID int, names []string, newName string) error {
+	matches := func(idTele, target string) bool {
+		return idTele == strconv.Itoa(userID) && slices.Contains(names, normalizeCategory(target))
+	}
+
+	if err := rewriteCSV("purchase_records.csv", func(record []string) {
+		if len(record) > 3 && matches(record[0], record[3]) {
+			record[3] = newName
+		}
+	}); err != nil {
+		return err
+	}
+	return rewriteCSV("budgets.csv", func(record []string) {
+		if len(record) > 1 && matches(record[0], record[1]) {
+			record[1] = newName
+		}
+	})
+}
+
+// rewriteCSV applies update to every record of the file.
+func rewriteCSV(filename string, update func(record []string)) error {
+	return filterCSV(filename, func(record []string) bool {
+		update(record)
+		return true
+	})
+}
+
+// recordsMu serializes the writes to the purchase and budget files, so a
+// record appended while filterCSV rewrites the file isn't lost.
+var recordsMu sync.Mutex
+
+// filterCSV keeps the records of the file for which keep is true, through
+// a temporary file so a failure never leaves half a file behind.
+func filterCSV(filename string, keep func(record []string) bool) error {
+	defer metrics.StoreDuration.Time(strings.TrimSuffix(filename, ".csv"), "write")()
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	var kept [][]string
+	for _, record := range records {
+		if keep(record) {
+			kept = append(kept, record)
+		}
+	}
+
+	tmp, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(tmp)
+	writer.WriteAll(kept)
+	if err := writer.Error(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
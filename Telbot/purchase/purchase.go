@@ -1,22 +1,36 @@
 package purchase
 
 import (
+	"Telbot/audit"
+	"Telbot/dialog"
+	"Telbot/metrics"
+	"Telbot/router"
 	"Telbot/utils"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"github.com/tucnak/telebot"
+	"io"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// ReportLimit is the rate of commands that read every purchase record,
+// per user.
+var ReportLimit = router.Limit{N: 5, Per: time.Minute}
+
 type Purchase struct {
 	IDTele      int
 	AccountName string
 	Amount      int
 	Target      string
 	CreatedTime time.Time
+	Note        string
 }
 
 type Budget struct {
@@ -29,6 +43,15 @@ type Budget struct {
 
 // LoadBudgets loads all budgets from the CSV file for a specific user.
 func LoadBudgets(userID int) ([]Budget, error) {
+	return readBudgets(func(idTele int) bool { return idTele == userID })
+}
+
+// readBudgets loads the budgets of the users for which want is true.
+// SaveBudget appends, so the last budget of a category replaces the
+// earlier ones.
+func readBudgets(want func(userID int) bool) ([]Budget, error) {
+	defer metrics.StoreDuration.Time("budgets", "read")()
+
 	file, err := os.Open("budgets.csv")
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -39,47 +62,83 @@ func LoadBudgets(userID int) ([]Budget, error) {
 	defer file.Close()
 
 	var budgets []Budget
+	index := map[string]int{} // userID:category, of the budget in budgets
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
 
 	for {
 		record, err := reader.Read()
-		if err != nil {
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 5 {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", "budgets.csv", "line", line,
+				"err", fmt.Errorf("%d fields, want 5", len(record)))
+			continue
+		}
 
-		idTele, _ := strconv.Atoi(record[0])
-		if idTele != userID {
+		idTele, idErr := strconv.Atoi(record[0])
+		if idErr == nil && !want(idTele) {
 			continue // Skip budgets not matching the specified user ID
 		}
 
-		amount, _ := strconv.Atoi(record[2])
-		threshold, _ := strconv.ParseFloat(record[4], 64)
-
-		budget := Budget{
-			IDTele:    idTele,
-			Category:  record[1],
-			Amount:    amount,
-			Duration:  record[3],
-			Threshold: threshold,
+		budget, err := parseBudget(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			slog.Warn("skipping malformed record", "file", "budgets.csv", "line", line, "err", err)
+			continue
 		}
 
+		key := fmt.Sprintf("%d:%s", budget.IDTele, strings.ToLower(budget.Category))
+		if i, ok := index[key]; ok {
+			budgets[i] = budget
+			continue
+		}
+		index[key] = len(budgets)
 		budgets = append(budgets, budget)
 	}
 
 	return budgets, nil
 }
 
+// parseBudget reads a line of budgets.csv, see SaveBudget.
+func parseBudget(record []string) (Budget, error) {
+	idTele, idErr := strconv.Atoi(record[0])
+	amount, amountErr := strconv.Atoi(record[2])
+	threshold, thresholdErr := strconv.ParseFloat(record[4], 64)
+	if err := errors.Join(idErr, amountErr, thresholdErr); err != nil {
+		return Budget{}, err
+	}
+
+	return Budget{
+		IDTele:    idTele,
+		Category:  record[1],
+		Amount:    amount,
+		Duration:  record[3],
+		Threshold: threshold,
+	}, nil
+}
+
 func CalculateSpent(userID int, target string, period string) int {
 	purchases, err := loadPurchases()
 	if err != nil {
 		return 0
 	}
 
+	// A budget on a category also covers its subcategories
+	categoryMu.Lock()
+	targets := descendants(userID, canonicalCategory(userID, target))
+	categoryMu.Unlock()
+
 	totalSpent := 0
 	now := time.Now()
 
 	for _, purchase := range purchases {
-		if purchase.IDTele == userID && purchase.Target == target {
+		if purchase.IDTele == userID && slices.Contains(targets, purchase.Target) {
 			switch period {
 			case "week":
 				weekAgo := now.AddDate(0, 0, -7)
@@ -105,60 +164,59 @@ func CalculateSpent(userID int, target string, period string) int {
 	return totalSpent
 }
 
-func RegisterHandlers(bot *telebot.Bot) {
-	bot.Handle("/purchase", func(m *telebot.Message) {
-		args := strings.SplitN(m.Payload, " ", 2)
-		if len(args) < 2 {
-			bot.Send(m.Chat, "Usage: /purchase [amount with K or M] [target]")
-			return
-		}
-
-		amountString := strings.ToUpper(args[0])
-		multiplier := 1
+func RegisterHandlers(r *router.Router) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:        "/purchase",
+		Group:       "Purchases",
+		Description: "Record a purchase",
+		Args:        []router.Arg{{Name: "amount with K or M", Kind: router.Amount}, {Name: "target", Kind: router.Text}},
+		Guided:      true,
+		Handler: func(m *telebot.Message) {
+			if strings.TrimSpace(m.Payload) == "" {
+				startPurchaseFlow(bot, m)
+				return
+			}
 
-		if strings.HasSuffix(amountString, "K") {
-			multiplier = 1000
-			amountString = strings.TrimSuffix(amountString, "K")
-		} else if strings.HasSuffix(amountString, "M") {
-			multiplier = 1000000
-			amountString = strings.TrimSuffix(amountString, "M")
-		}
+			// The router has checked the amount and that a target follows
+			args := strings.Fields(m.Payload)
+			amount, _ := utils.ParseAmount(args[0])
+			if amount <= 0 {
+				utils.Send(bot, m.Chat, "The amount must be more than zero.")
+				return
+			}
 
-		amount, err := strconv.Atoi(amountString)
-		if err != nil {
-			bot.Send(m.Chat, "Please provide a valid number for the amount.")
-			return
-		}
-		amount *= multiplier
-
-		// Parse the target (e.g., "education")
-		target := args[1]
-
-		// Create a Purchase entry with the current timestamp
-		purchase := Purchase{
-			IDTele:      m.Sender.ID,
-			AccountName: m.Sender.Username,
-			Amount:      amount,
-			Target:      target,
-			CreatedTime: time.Now(), // Capture the current time
-		}
+			purchase, reply, err := recordPurchase(m.Sender.ID, m.Sender.Username, amount, strings.Join(args[1:], " "), time.Now())
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Send(bot, m.Chat, reply)
+			sendPurchaseCheck(bot, m.Chat, purchase)
+			sendBudgetAlert(bot, m.Chat, m.Sender.ID)
+		},
+	})
 
-		// Save the purchase record to a file
-		if err := savePurchaseToFile(purchase); err != nil {
-			bot.Send(m.Chat, "Failed to save purchase record.")
-			return
-		}
+	registerGuidedFlows(r)
+	registerQuickEntry(r)
+	registerImport(r)
+	registerAnomalyChecks(r)
+}
 
-		bot.Send(m.Chat, fmt.Sprintf("Recorded purchase: %d for %s.", purchase.Amount, purchase.Target))
-		message, _ := CheckBudgetAlert(m.Sender.ID)
-		if message != "" {
+func sendBudgetAlert(bot *telebot.Bot, chat *telebot.Chat, userID int) {
+	message, _ := CheckBudgetAlert(userID)
+	if message != "" {
 
-			bot.Send(m.Chat, message)
-		}
-	})
+		utils.Send(bot, chat, message)
+	}
 }
 
 func savePurchaseToFile(purchase Purchase) error {
+	defer metrics.StoreDuration.Time("purchases", "write")()
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+
 	// Open or create the file in append mode
 	file, err := os.OpenFile("purchase_records.csv", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -166,101 +224,154 @@ func savePurchaseToFile(purchase Purchase) error {
 	}
 	defer file.Close()
 
-	// Write purchase data as a CSV line, using YYYY-MM-DD format for CreatedTime
-	_, err = file.WriteString(fmt.Sprintf("%d,%s,%d,%s,%s\n", purchase.IDTele, purchase.AccountName, purchase.Amount, purchase.Target, purchase.CreatedTime.Format("2006-01-02")))
-	return err
+	writer := csv.NewWriter(file)
+	if err := writer.Write(purchaseRecord(purchase)); err != nil {
+		return err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	audit.Record(audit.Entry{Actor: purchase.IDTele, Action: "purchase.create", Subject: purchase.Target, After: purchase})
+	return nil
 }
 
-func RegisterReportCommands(bot *telebot.Bot) {
-	// Command to get total sum by period
-	bot.Handle("/sumPurchases", func(m *telebot.Message) {
-		purchases, err := loadPurchases()
-		if err != nil {
-			bot.Send(m.Chat, "Failed to load purchase records.")
-			return
-		}
+// purchaseRecord is the CSV line of a purchase, using YYYY-MM-DD format for CreatedTime
+func purchaseRecord(purchase Purchase) []string {
+	return []string{
+		strconv.Itoa(purchase.IDTele),
+		purchase.AccountName,
+		strconv.Itoa(purchase.Amount),
+		purchase.Target,
+		purchase.CreatedTime.Format("2006-01-02"),
+		purchase.Note,
+	}
+}
 
-		lastMonth := calculateSumByPeriod(purchases, "month")
-		lastWeek := calculateSumByPeriod(purchases, "week")
-		lastYear := calculateSumByPeriod(purchases, "year")
+func describeMatch(match CategoryMatch, typed string) string {
+	switch {
+	case match.Fuzzy:
+		return fmt.Sprintf(" (matched %q to %s)", typed, match.Name)
+	case match.New:
+		return fmt.Sprintf(" New category %s, see /categories.", match.Name)
+	default:
+		return ""
+	}
+}
 
-		message := fmt.Sprintf("Sum of Purchases:\nLast Month: %d\nLast Week: %d\nLast Year: %d", lastMonth, lastWeek, lastYear)
-		bot.Send(m.Chat, message)
+func RegisterReportCommands(r *router.Router) {
+	bot := r.Bot
+
+	// Command to get total sum by period
+	r.Handle(router.Command{
+		Name:        "/sumPurchases",
+		Group:       "Reports",
+		Description: "Total spent in the last week, month and year",
+		Limit:       ReportLimit,
+		Handler: func(m *telebot.Message) {
+			message, err := Totals()
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Send(bot, m.Chat, message)
+		},
 	})
 
 	// Command to get target percentage by period
-	bot.Handle("/targetPercentage", func(m *telebot.Message) {
-		purchases, err := loadPurchases()
-		if err != nil {
-			bot.Send(m.Chat, "Failed to load purchase records.")
-			return
-		}
+	r.Handle(router.Command{
+		Name:        "/targetPercentage",
+		Group:       "Reports",
+		Description: "Share of each target this month",
+		Limit:       ReportLimit,
+		Handler: func(m *telebot.Message) {
+			purchases, err := loadPurchases()
+			if err != nil {
+				utils.Send(bot, m.Chat, "Failed to load purchase records.")
+				return
+			}
 
-		period := "month" // This could be modified to take arguments for different periods
-		targetData := calculateTargetPercentage(purchases, period)
+			period := "month" // This could be modified to take arguments for different periods
+			targetData := calculateTargetPercentage(purchases, period)
 
-		message := "Target Percentage in " + period + ":\n"
-		for target, percentage := range targetData {
-			message += fmt.Sprintf("%s: %.2f%%\n", target, percentage)
-		}
-		bot.Send(m.Chat, message)
+			message := "Target Percentage in " + period + ":\n"
+			for target, percentage := range targetData {
+				message += fmt.Sprintf("%s: %.2f%%\n", target, percentage)
+			}
+			utils.Send(bot, m.Chat, message)
+		},
 	})
 
 	// Command to get sum by target
-	bot.Handle("/sumByTarget", func(m *telebot.Message) {
-		purchases, err := loadPurchases()
-		if err != nil {
-			bot.Send(m.Chat, "Failed to load purchase records.")
-			return
-		}
-
-		period := "month" // This could be modified to take arguments for different periods
-		targetTotals := calculateSumByTarget(purchases, period)
-
-		message := fmt.Sprintf("Sum by Target in %s:\n", period)
-		for target, sum := range targetTotals {
-			message += fmt.Sprintf("%s: %d\n", target, sum)
-		}
-		bot.Send(m.Chat, message)
-	})
-	bot.Handle("/targetSummary", func(m *telebot.Message) {
-		// Lấy tham số period từ tin nhắn người dùng
-		period := "month" // Mặc định là "month"
-		args := strings.Split(m.Text, " ")
-		if len(args) > 1 {
-			period = args[1]
-			if period != "month" && period != "year" && period != "week" {
-				bot.Send(m.Chat, "Please specify a valid period: 'month' or 'year'.")
+	r.Handle(router.Command{
+		Name:        "/sumByTarget",
+		Group:       "Reports",
+		Description: "Total per target this month",
+		Limit:       ReportLimit,
+		Handler: func(m *telebot.Message) {
+			purchases, err := loadPurchases()
+			if err != nil {
+				utils.Send(bot, m.Chat, "Failed to load purchase records.")
 				return
 			}
-		}
 
-		// Tải dữ liệu mua hàng
-		purchases, err := loadPurchases()
-		if err != nil {
-			bot.Send(m.Chat, "Failed to load purchase records.")
-			return
-		}
-
-		// Tính tổng theo mục tiêu (target)
-		targetTotals := calculateSumByTarget(purchases, period)
+			period := "month" // This could be modified to take arguments for different periods
+			targetTotals := calculateSumByTarget(purchases, period)
 
-		// Tính phần trăm theo mục tiêu (target)
-		targetPercentages := calculateTargetPercentage(purchases, period)
+			message := fmt.Sprintf("Sum by Target in %s:\n", period)
+			for target, sum := range targetTotals {
+				message += fmt.Sprintf("%s: %d\n", target, sum)
+			}
+			utils.Send(bot, m.Chat, message)
+		},
+	})
+	r.Handle(router.Command{
+		Name:        "/targetSummary",
+		Group:       "Reports",
+		Description: "Total and share per target",
+		Limit:       ReportLimit,
+		Args:        []router.Arg{{Name: "week|month|year", Optional: true}},
+		Handler: func(m *telebot.Message) {
+			// Lấy tham số period từ tin nhắn người dùng
+			period := "month" // Mặc định là "month"
+			args := strings.Split(m.Text, " ")
+			if len(args) > 1 {
+				period = args[1]
+				if !slices.Contains(periods, period) {
+					utils.Send(bot, m.Chat, "Please pick a period.", dialog.Keyboard(periodButton, periods, 3))
+					return
+				}
+			}
 
-		// Tạo tin nhắn phản hồi, bao gồm cả tổng và phần trăm cho từng mục tiêu
-		message := fmt.Sprintf("Target Summary in %s:\n", period)
-		for target, sum := range targetTotals {
-			percentage := targetPercentages[target]
-			message += fmt.Sprintf("%s: %s (%.2f%%)\n", target, utils.FormatNumber(sum), percentage)
-		}
+			message, err := TargetSummary(period)
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
 
-		// Gửi tin nhắn phản hồi
-		bot.Send(m.Chat, message)
+			// Gửi tin nhắn phản hồi
+			utils.Send(bot, m.Chat, message)
+		},
 	})
+
+	registerChart(r)
 }
 
 func SaveBudget(budget Budget) error {
+	// The latest budget of a category replaces the earlier ones
+	var before interface{}
+	if budgets, err := LoadBudgets(budget.IDTele); err == nil {
+		for _, b := range budgets {
+			if strings.EqualFold(b.Category, budget.Category) {
+				before = b
+			}
+		}
+	}
+
+	defer metrics.StoreDuration.Time("budgets", "write")()
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
 	file, err := os.OpenFile("budgets.csv", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
@@ -268,7 +379,6 @@ func SaveBudget(budget Budget) error {
 	defer file.Close()
 
 	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
 	record := []string{
 		strconv.Itoa(budget.IDTele),
@@ -277,106 +387,67 @@ func SaveBudget(budget Budget) error {
 		budget.Duration,
 		fmt.Sprintf("%.2f", budget.Threshold),
 	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
 
-	return writer.Write(record)
-}
-
-func SetBudget(bot *telebot.Bot) {
-	bot.Handle("/setBudget", func(m *telebot.Message) {
-		args := strings.SplitN(m.Payload, " ", 3)
-		if len(args) < 3 {
-			bot.Send(m.Chat, "Usage: /setBudget [amount with K or M] [category] [duration (e.g., week)]")
-			return
-		}
-
-		// Parse the amount with suffix
-		amountString := strings.ToUpper(args[0])
-		multiplier := 1
-
-		if strings.HasSuffix(amountString, "K") {
-			multiplier = 1000
-			amountString = strings.TrimSuffix(amountString, "K")
-		} else if strings.HasSuffix(amountString, "M") {
-			multiplier = 1000000
-			amountString = strings.TrimSuffix(amountString, "M")
-		}
-
-		amount, err := strconv.Atoi(amountString)
-		if err != nil {
-			bot.Send(m.Chat, "Please provide a valid number for the amount.")
-			return
-		}
-		amount *= multiplier
-
-		category := args[1]
-		duration := args[2]
-
-		newBudget := Budget{
-			IDTele:    m.Sender.ID,
-			Category:  category,
-			Amount:    amount,
-			Duration:  duration,
-			Threshold: 0.7, // Set to 70%
-		}
-
-		// Save the budget
-		if err := SaveBudget(newBudget); err != nil {
-			bot.Send(m.Chat, "Failed to save budget.")
-			return
-		}
-
-		bot.Send(m.Chat, fmt.Sprintf("Budget set for %s: %s every %s.", category, utils.FormatNumber(amount), duration))
-	})
-}
-
-func ViewBudget(bot *telebot.Bot) {
-	bot.Handle("/viewBudget", func(m *telebot.Message) {
-		budgets, err := LoadBudgets(m.Sender.ID)
-		if err != nil {
-			bot.Send(m.Chat, "Failed to load budgets.")
-			return
-		}
-
-		if len(budgets) == 0 {
-			bot.Send(m.Chat, "You have no budgets set.")
-			return
-		}
-
-		message := "Your Budgets:\n"
-		for _, budget := range budgets {
-			message += fmt.Sprintf("- %s: %s every %s\n", budget.Category, utils.FormatNumber(budget.Amount), budget.Duration)
-		}
-
-		bot.Send(m.Chat, message)
-	})
+	audit.Record(audit.Entry{Actor: budget.IDTele, Action: "budget.set", Subject: budget.Category, Before: before, After: budget})
+	return nil
 }
 
-func CheckBudget(bot *telebot.Bot) {
-	bot.Handle("/checkBudget", func(m *telebot.Message) {
-		budgets, err := LoadBudgets(m.Sender.ID)
-		if err != nil {
-			bot.Send(m.Chat, "Failed to load budgets.")
-			return
-		}
-
-		if len(budgets) == 0 {
-			bot.Send(m.Chat, "You have no budgets set.")
-			return
-		}
-
-		message := "Budget Usage:\n"
-		for _, budget := range budgets {
-			spent := CalculateSpent(m.Sender.ID, budget.Category, budget.Duration) // Hàm để tính chi tiêu theo Category và Duration
-			percentage := float64(spent) / float64(budget.Amount)
+// RegisterBudgetCommands registers /setBudget. Viewing and checking
+// budgets needs nothing from Telegram, see package core.
+func RegisterBudgetCommands(r *router.Router) {
+	bot := r.Bot
+
+	r.Handle(router.Command{
+		Name:        "/setBudget",
+		Group:       "Budgets",
+		Description: "Set a budget for a category",
+		Args:        []router.Arg{{Name: "amount with K or M", Kind: router.Amount}, {Name: "category"}, {Name: "duration (e.g., week)"}},
+		Guided:      true,
+		Handler: func(m *telebot.Message) {
+			if strings.TrimSpace(m.Payload) == "" {
+				startBudgetFlow(bot, m)
+				return
+			}
 
-			message += fmt.Sprintf("%s: Spent %s of %s (%.2f%%)\n", budget.Category, utils.FormatNumber(spent), utils.FormatNumber(budget.Amount), percentage*100)
+			// The router has checked the amount and that all three arguments are there
+			args := strings.Fields(m.Payload)
+			amount, _ := utils.ParseAmount(args[0])
+			if amount <= 0 {
+				utils.Send(bot, m.Chat, "The amount must be more than zero.")
+				return
+			}
 
-			if percentage >= budget.Threshold {
-				message += fmt.Sprintf("⚠️ Warning: You've spent %.2f%% of your %s budget!\n", percentage*100, budget.Category)
+			// Registered by SetBudget, the period may still be cancelled
+			match, err := previewCategory(m.Sender.ID, args[1])
+			if err != nil {
+				utils.Send(bot, m.Chat, "Failed to load categories.")
+				return
+			}
+			category := match.Name
+			duration := strings.Join(args[2:], " ")
+			if !slices.Contains(periods, duration) {
+				// Keep the answers so far and ask for the period with buttons
+				c := dialog.Start(m.Sender.ID, m.Chat.ID, budgetFlow, "period")
+				c.Set("amount", strconv.Itoa(amount))
+				c.Set("category", category)
+				utils.Send(bot, m.Chat, "Please pick how often the budget resets.", dialog.Keyboard(periodButton, periods, 3))
+				return
 			}
-		}
 
-		bot.Send(m.Chat, message)
+			reply, err := SetBudget(m.Sender.ID, amount, category, duration)
+			if err != nil {
+				utils.Send(bot, m.Chat, utils.Sentence(err))
+				return
+			}
+			utils.Send(bot, m.Chat, reply)
+		},
 	})
 }
 
@@ -401,5 +472,81 @@ func CheckBudgetAlert(Id int) (string, error) {
 			return message, nil
 		}
 	}
-	return "", nil
+
+	// Before any threshold, warn about budgets heading over
+	return projectionAlert(Id, time.Now()), nil
+}
+
+// Stats summarizes the stored records for /admin stats.
+func Stats() string {
+	counts, err := Counts()
+	if err != nil {
+		return "Failed to load purchase records.\n"
+	}
+	return fmt.Sprintf("Purchases: %d\nBudgets: %d\n", counts["purchases"], counts["budgets"])
+}
+
+// Counts returns the number of records in each store, for metrics.
+func Counts() (map[string]int, error) {
+	purchases, err := countRecords("purchase_records.csv")
+	if err != nil {
+		return nil, err
+	}
+	budgets, err := countRecords("budgets.csv")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"purchases": purchases, "budgets": budgets}, nil
+}
+
+// recordCount is the number of records of a file as of its last change.
+type recordCount struct {
+	modTime time.Time
+	size    int64
+	records int
+}
+
+var (
+	countsMu sync.Mutex
+	counts   = map[string]recordCount{}
+)
+
+// countRecords counts the records of a file, reading it again only when it
+// changed since the last count. Metrics are scraped far more often than
+// purchases are recorded.
+func countRecords(filename string) (int, error) {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	countsMu.Lock()
+	defer countsMu.Unlock()
+	if c, ok := counts[filename]; ok && c.modTime.Equal(info.ModTime()) && c.size == info.Size() {
+		return c.records, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	records := 0
+	for {
+		if _, err := reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		records++
+	}
+	counts[filename] = recordCount{info.ModTime(), info.Size(), records}
+	return records, nil
 }
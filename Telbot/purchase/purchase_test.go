@@ -0,0 +1,45 @@
+package purchase
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCountRecords(t *testing.T) {
+	inTempDir(t)
+
+	if n, err := countRecords("purchase_records.csv"); err != nil || n != 0 {
+		t.Fatalf("countRecords = %d, %v without a file", n, err)
+	}
+	os.WriteFile("purchase_records.csv", []byte("1,an,35000,coffee,2024-11-01\n"), 0644)
+	if n, _ := countRecords("purchase_records.csv"); n != 1 {
+		t.Errorf("Expected 1 record, got %d", n)
+	}
+
+	// Counted again once the file changes
+	os.WriteFile("purchase_records.csv", []byte("1,an,35000,coffee,2024-11-01\n1,an,40000,food,2024-11-02\n"), 0644)
+	if n, _ := countRecords("purchase_records.csv"); n != 2 {
+		t.Errorf("Expected 2 records, got %d", n)
+	}
+}
+
+func TestLatestBudgetWins(t *testing.T) {
+	inTempDir(t)
+	for _, amount := range []int{1_000_000, 2_000_000} {
+		if err := SaveBudget(Budget{IDTele: 1, Category: "food", Amount: amount, Duration: "month", Threshold: 0.7}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	SaveBudget(Budget{IDTele: 2, Category: "food", Amount: 500_000, Duration: "week", Threshold: 0.7})
+
+	budgets, err := LoadBudgets(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budgets) != 1 || budgets[0].Amount != 2_000_000 {
+		t.Errorf("Expected only the 2000000 budget, got %+v", budgets)
+	}
+	if all, _ := AllBudgets(); len(all) != 2 {
+		t.Errorf("Expected one budget per user, got %+v", all)
+	}
+}
@@ -0,0 +1,293 @@
+package purchase
+
+import (
+	"Telbot/dialog"
+	"Telbot/router"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Quick entry records purchases written as plain text in a private chat,
+// e.g. "cà phê 35k", "35k coffee yesterday" or "grab 52.000đ đi làm". The
+// parsed purchase is only saved once the user confirms it.
+
+var (
+	quickSaveButton = telebot.InlineButton{
+		Unique: "quickSave",
+		Text:   "✅ Save",
+	}
+	quickCancelButton = telebot.InlineButton{
+		Unique: "quickCancel",
+		Text:   "❌ Cancel",
+	}
+)
+
+// pendingFor is how long a quick entry can be saved or cancelled.
+const pendingFor = time.Hour
+
+// pendingEntry is a quick entry waiting for confirmation, with when it was
+// asked about.
+type pendingEntry struct {
+	purchase Purchase
+	asked    time.Time
+}
+
+var (
+	pendingMu     sync.Mutex
+	pending       = map[string]pendingEntry{}
+	pendingNextID int
+)
+
+func registerQuickEntry(r *router.Router) {
+	bot := r.Bot
+
+	// Text belongs to a guided flow first, see guided.go
+	dialog.HandleText(r, func(m *telebot.Message) {
+		// In groups the conversation isn't meant for the bot
+		if m.Chat.Type != telebot.ChatPrivate {
+			return
+		}
+		purchase, ok := parseQuickEntry(m.Sender.ID, m.Text, time.Now())
+		if !ok {
+			utils.Send(bot, m.Chat, "I couldn't find an amount. Try something like \"cà phê 35k\" or \"35k coffee yesterday\".")
+			return
+		}
+		purchase.AccountName = m.Sender.Username
+
+		id := addPending(purchase, time.Now())
+		save, cancel := quickSaveButton, quickCancelButton
+		save.Data, cancel.Data = id, id
+		utils.Send(bot, m.Chat, "Save this purchase?\n"+describePurchase(purchase), &telebot.ReplyMarkup{
+			InlineKeyboard: [][]telebot.InlineButton{{save, cancel}},
+		})
+	})
+
+	r.HandleCallback(&quickSaveButton, func(c *telebot.Callback) {
+		purchase, ok := takePending(c)
+		if !ok {
+			utils.Respond(bot, c, &telebot.CallbackResponse{Text: "This entry is no longer pending."})
+			return
+		}
+
+		typed := purchase.Target
+		match, err := ResolveCategory(purchase.IDTele, typed)
+		if err != nil {
+			utils.Respond(bot, c, &telebot.CallbackResponse{Text: "Failed to load categories."})
+			return
+		}
+		purchase.Target = match.Name
+
+		if err := savePurchaseToFile(purchase); err != nil {
+			utils.Respond(bot, c, &telebot.CallbackResponse{Text: "Failed to save purchase record."})
+			return
+		}
+
+		utils.Respond(bot, c)
+		utils.Edit(bot, c.Message, "Recorded purchase:\n"+describePurchase(purchase)+describeMatch(match, typed))
+		sendPurchaseCheck(bot, c.Message.Chat, purchase)
+		sendBudgetAlert(bot, c.Message.Chat, purchase.IDTele)
+	})
+
+	r.HandleCallback(&quickCancelButton, func(c *telebot.Callback) {
+		if _, ok := takePending(c); !ok {
+			utils.Respond(bot, c)
+			return
+		}
+		utils.Respond(bot, c)
+		utils.Edit(bot, c.Message, "Cancelled, nothing was saved.")
+	})
+}
+
+// addPending keeps the purchase until it is confirmed, dropping the ones
+// never answered, and returns the ID for the buttons.
+func addPending(purchase Purchase, now time.Time) string {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	for id, entry := range pending {
+		if now.Sub(entry.asked) >= pendingFor {
+			delete(pending, id)
+		}
+	}
+	pendingNextID++
+	id := strconv.Itoa(pendingNextID)
+	pending[id] = pendingEntry{purchase, now}
+	return id
+}
+
+// takePending removes the pending purchase of the button, only the person
+// who wrote it may confirm or cancel it.
+func takePending(c *telebot.Callback) (Purchase, bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	entry, ok := pending[c.Data]
+	purchase := entry.purchase
+	if !ok || time.Since(entry.asked) >= pendingFor || c.Sender == nil || c.Sender.ID != purchase.IDTele || c.Message == nil {
+		return Purchase{}, false
+	}
+	delete(pending, c.Data)
+	return purchase, true
+}
+
+func describePurchase(purchase Purchase) string {
+	text := fmt.Sprintf("%s for %s on %s", utils.FormatNumber(purchase.Amount), purchase.Target, purchase.CreatedTime.Format("2006-01-02"))
+	if purchase.Note != "" {
+		text += fmt.Sprintf(" (%s)", purchase.Note)
+	}
+	return text
+}
+
+// parseQuickEntry finds the amount, date, category and note in free text.
+// The category is the longest run of words matching one of the user's
+// categories, otherwise the words before the amount, otherwise the first
+// word after it. What is left becomes the note.
+func parseQuickEntry(userID int, text string, now time.Time) (Purchase, bool) {
+	words := strings.Fields(text)
+	used := make([]bool, len(words))
+
+	amountAt := -1
+	amount := 0
+	for i := range words {
+		if value, ok := isAmount(userID, words, i); ok {
+			amountAt, amount = i, value
+			break
+		}
+	}
+	if amountAt < 0 {
+		return Purchase{}, false
+	}
+	used[amountAt] = true
+
+	date := now
+	for i := 0; i < len(words); i++ {
+		if used[i] {
+			continue
+		}
+		if i+1 < len(words) && !used[i+1] {
+			if day, err := utils.ParseDate(words[i]+" "+words[i+1], now); err == nil {
+				date, used[i], used[i+1] = day, true, true
+				break
+			}
+		}
+		if day, err := utils.ParseDate(words[i], now); err == nil {
+			date, used[i] = day, true
+			break
+		}
+	}
+
+	start, end := matchCategoryRun(userID, words, used)
+	if start < 0 {
+		start, end = -1, -1
+		for i := 0; i < amountAt; i++ {
+			if !used[i] {
+				if start < 0 {
+					start = i
+				}
+				end = i + 1
+			} else if start >= 0 {
+				break
+			}
+		}
+		if start < 0 {
+			for i := amountAt + 1; i < len(words); i++ {
+				if !used[i] {
+					start, end = i, i+1
+					break
+				}
+			}
+		}
+	}
+
+	target := "other"
+	if start >= 0 {
+		target = strings.Join(words[start:end], " ")
+		for i := start; i < end; i++ {
+			used[i] = true
+		}
+	}
+
+	var note []string
+	for i, word := range words {
+		if !used[i] {
+			note = append(note, word)
+		}
+	}
+
+	return Purchase{
+		IDTele:      userID,
+		Amount:      amount,
+		Target:      target,
+		CreatedTime: date,
+		Note:        strings.Join(note, " "),
+	}, true
+}
+
+// isAmount tells whether the word at i is an amount and returns it. It
+// needs a unit or a currency, e.g. "35k" or "52.000đ", or to be a number
+// from 1000 next to one of the user's categories, e.g. "coffee 35000".
+// Other numbers are rarely amounts: "2 coffees", "at 1530", "room 1203".
+func isAmount(userID int, words []string, i int) (int, bool) {
+	word := []rune(words[i])
+	value, err := utils.ParseAmount(words[i])
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+	if !unicode.IsDigit(word[len(word)-1]) {
+		return value, true
+	}
+	if value < 1000 {
+		return 0, false
+	}
+
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+	if loadCategories() != nil || registerPastTargets(userID) != nil {
+		return 0, false
+	}
+	for size := 1; size <= 3; size++ {
+		if i-size >= 0 && findCategory(userID, strings.Join(words[i-size:i], " ")) != nil ||
+			i+1+size <= len(words) && findCategory(userID, strings.Join(words[i+1:i+1+size], " ")) != nil {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// matchCategoryRun returns the longest run of unused words naming one of
+// the user's categories, trying exact names and aliases before similar
+// names. It returns -1, -1 when nothing matches.
+func matchCategoryRun(userID int, words []string, used []bool) (int, int) {
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+
+	if loadCategories() != nil || registerPastTargets(userID) != nil {
+		return -1, -1
+	}
+
+	// Short words such as "đi" are too easily a typo away from a category
+	for pass, find := range []func(int, string) *Category{findCategory, fuzzyCategory} {
+		for size := 3; size >= 1; size-- {
+			for start := 0; start+size <= len(words); start++ {
+				free := true
+				for i := start; i < start+size; i++ {
+					free = free && !used[i]
+				}
+				run := strings.Join(words[start:start+size], " ")
+				if !free || (pass == 1 && len([]rune(run)) < 4) {
+					continue
+				}
+				if find(userID, run) != nil {
+					return start, start + size
+				}
+			}
+		}
+	}
+	return -1, -1
+}
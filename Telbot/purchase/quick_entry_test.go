@@ -0,0 +1,72 @@
+package purchase
+
+import (
+	"github.com/tucnak/telebot"
+	"testing"
+	"time"
+)
+
+func TestParseQuickEntry(t *testing.T) {
+	inTempDir(t)
+	ResolveCategory(1, "coffee")
+	categoryMu.Lock()
+	addCategoryAlias(1, "cà phê", "coffee")
+	categoryMu.Unlock()
+
+	now := time.Date(2024, 11, 5, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		text   string
+		amount int
+		target string
+		date   string
+		note   string
+	}{
+		{"cà phê 35k", 35_000, "cà phê", "2024-11-05", ""},
+		{"35k coffee yesterday", 35_000, "coffee", "2024-11-04", ""},
+		{"grab 52.000đ đi làm", 52_000, "grab", "2024-11-05", "đi làm"},
+		{"hôm qua ăn trưa 60K với team", 60_000, "ăn trưa", "2024-11-04", "với team"},
+		{"cà phê 35000", 35_000, "cà phê", "2024-11-05", ""},
+		{"45.000 coffee", 45_000, "coffee", "2024-11-05", ""},
+	}
+
+	for _, c := range cases {
+		p, ok := parseQuickEntry(1, c.text, now)
+		if !ok {
+			t.Errorf("%q: expected a purchase", c.text)
+			continue
+		}
+		if p.Amount != c.amount || p.Target != c.target || p.CreatedTime.Format("2006-01-02") != c.date || p.Note != c.note {
+			t.Errorf("%q: got %d %q %s %q", c.text, p.Amount, p.Target, p.CreatedTime.Format("2006-01-02"), p.Note)
+		}
+	}
+
+	// Bare numbers need one of the user's categories next to them
+	for _, text := range []string{"see you at 5", "see you at 1530", "room 1203 please", "1,200,000"} {
+		if _, ok := parseQuickEntry(1, text, now); ok {
+			t.Errorf("%q: expected no purchase without an amount", text)
+		}
+	}
+}
+
+func TestPendingExpires(t *testing.T) {
+	press := func(id string) *telebot.Callback {
+		return &telebot.Callback{Sender: &telebot.User{ID: 1}, Message: &telebot.Message{}, Data: id}
+	}
+	coffee := Purchase{IDTele: 1, Amount: 35_000, Target: "coffee"}
+
+	old := addPending(coffee, time.Now().Add(-pendingFor))
+	if _, ok := takePending(press(old)); ok {
+		t.Error("an expired entry could still be saved")
+	}
+
+	fresh := addPending(coffee, time.Now())
+	pendingMu.Lock()
+	_, kept := pending[old]
+	pendingMu.Unlock()
+	if kept {
+		t.Error("the expired entry was kept")
+	}
+	if purchase, ok := takePending(press(fresh)); !ok || purchase.Amount != 35_000 {
+		t.Errorf("takePending = %+v, %v", purchase, ok)
+	}
+}
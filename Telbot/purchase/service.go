@@ -0,0 +1,150 @@
+package purchase
+
+import (
+	"Telbot/utils"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// The functions below are purchases and budgets without Telegram: they
+// take plain values and return the reply to show, or an error whose text
+// can be shown as is. The Telegram handlers and package core both use them.
+
+// RecordPurchase saves a purchase, matching the target to one of the user's
+// categories.
+func RecordPurchase(userID int, account string, amount int, target string, at time.Time) (string, error) {
+	_, reply, err := recordPurchase(userID, account, amount, target, at)
+	return reply, err
+}
+
+// recordPurchase is RecordPurchase, also returning the purchase saved.
+func recordPurchase(userID int, account string, amount int, target string, at time.Time) (Purchase, string, error) {
+	if amount <= 0 {
+		return Purchase{}, "", errors.New("the amount must be more than zero")
+	}
+
+	// Parse the target (e.g., "education") and match it to a category
+	match, err := ResolveCategory(userID, target)
+	if err != nil {
+		return Purchase{}, "", errors.New("failed to load categories")
+	}
+
+	purchase := Purchase{
+		IDTele:      userID,
+		AccountName: account,
+		Amount:      amount,
+		Target:      match.Name,
+		CreatedTime: at,
+	}
+	if err := savePurchaseToFile(purchase); err != nil {
+		return Purchase{}, "", errors.New("failed to save purchase record")
+	}
+	return purchase, fmt.Sprintf("Recorded purchase: %d for %s.%s", purchase.Amount, purchase.Target, describeMatch(match, target)), nil
+}
+
+// SetBudget sets the user's budget for a category, resetting every period
+// (week, month or year).
+func SetBudget(userID, amount int, category, period string) (string, error) {
+	if amount <= 0 {
+		return "", errors.New("the amount must be more than zero")
+	}
+	if !slices.Contains(periods, period) {
+		return "", errors.New("please specify a valid period: week, month or year")
+	}
+	match, err := ResolveCategory(userID, category)
+	if err != nil {
+		return "", errors.New("failed to load categories")
+	}
+
+	budget := Budget{
+		IDTele:    userID,
+		Category:  match.Name,
+		Amount:    amount,
+		Duration:  period,
+		Threshold: 0.7, // Set to 70%
+	}
+	if err := SaveBudget(budget); err != nil {
+		return "", errors.New("failed to save budget")
+	}
+	return fmt.Sprintf("Budget set for %s: %s every %s.", budget.Category, utils.FormatNumber(amount), period), nil
+}
+
+// Budgets lists the user's budgets.
+func Budgets(userID int) (string, error) {
+	budgets, err := LoadBudgets(userID)
+	if err != nil {
+		return "", errors.New("failed to load budgets")
+	}
+	if len(budgets) == 0 {
+		return "You have no budgets set.", nil
+	}
+
+	message := "Your Budgets:\n"
+	for _, budget := range budgets {
+		message += fmt.Sprintf("- %s: %s every %s\n", budget.Category, utils.FormatNumber(budget.Amount), budget.Duration)
+	}
+	return message, nil
+}
+
+// BudgetStatus shows how much of each budget is spent.
+func BudgetStatus(userID int) (string, error) {
+	budgets, err := LoadBudgets(userID)
+	if err != nil {
+		return "", errors.New("failed to load budgets")
+	}
+	if len(budgets) == 0 {
+		return "You have no budgets set.", nil
+	}
+
+	message := "Budget Usage:\n"
+	for _, budget := range budgets {
+		spent := CalculateSpent(userID, budget.Category, budget.Duration) // Hàm để tính chi tiêu theo Category và Duration
+		percentage := float64(spent) / float64(budget.Amount)
+
+		message += fmt.Sprintf("%s: Spent %s of %s (%.2f%%)\n", budget.Category, utils.FormatNumber(spent), utils.FormatNumber(budget.Amount), percentage*100)
+
+		if percentage >= budget.Threshold {
+			message += fmt.Sprintf("⚠️ Warning: You've spent %.2f%% of your %s budget!\n", percentage*100, budget.Category)
+		}
+	}
+	return message, nil
+}
+
+// Totals sums the purchases of the last week, month and year.
+func Totals() (string, error) {
+	purchases, err := loadPurchases()
+	if err != nil {
+		return "", errors.New("failed to load purchase records")
+	}
+
+	lastMonth := calculateSumByPeriod(purchases, "month")
+	lastWeek := calculateSumByPeriod(purchases, "week")
+	lastYear := calculateSumByPeriod(purchases, "year")
+
+	return fmt.Sprintf("Sum of Purchases:\nLast Month: %d\nLast Week: %d\nLast Year: %d", lastMonth, lastWeek, lastYear), nil
+}
+
+// TargetSummary shows the total and share of each target over the period.
+func TargetSummary(period string) (string, error) {
+	// Tải dữ liệu mua hàng
+	purchases, err := loadPurchases()
+	if err != nil {
+		return "", errors.New("failed to load purchase records")
+	}
+
+	// Tính tổng theo mục tiêu (target)
+	targetTotals := calculateSumByTarget(purchases, period)
+
+	// Tính phần trăm theo mục tiêu (target)
+	targetPercentages := calculateTargetPercentage(purchases, period)
+
+	// Tạo tin nhắn phản hồi, bao gồm cả tổng và phần trăm cho từng mục tiêu
+	message := fmt.Sprintf("Target Summary in %s:\n", period)
+	for target, sum := range targetTotals {
+		percentage := targetPercentages[target]
+		message += fmt.Sprintf("%s: %s (%.2f%%)\n", target, utils.FormatNumber(sum), percentage)
+	}
+	return message, nil
+}
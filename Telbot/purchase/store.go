@@ -0,0 +1,111 @@
+package purchase
+
+import (
+	"Telbot/audit"
+	"os"
+)
+
+// The functions below reach the records of every user at once, for
+// telbotctl.
+
+// AllPurchases returns the purchases of every user, in the order recorded.
+func AllPurchases() ([]Purchase, error) {
+	purchases, err := loadPurchases()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return purchases, err
+}
+
+// userPurchases returns the purchases of one user, in the order recorded.
+func userPurchases(userID int) ([]Purchase, error) {
+	purchases, err := AllPurchases()
+	if err != nil {
+		return nil, err
+	}
+	var mine []Purchase
+	for _, purchase := range purchases {
+		if purchase.IDTele == userID {
+			mine = append(mine, purchase)
+		}
+	}
+	return mine, nil
+}
+
+// AllBudgets returns the budgets of every user.
+func AllBudgets() ([]Budget, error) {
+	return readBudgets(func(int) bool { return true })
+}
+
+// Spending sums the purchases of the user over the period (week, month or
+// year) per target. A userID of 0 sums everyone's.
+func Spending(userID int, period string) (map[string]int, error) {
+	purchases, err := AllPurchases()
+	if err != nil {
+		return nil, err
+	}
+	var mine []Purchase
+	for _, purchase := range purchases {
+		if userID == 0 || purchase.IDTele == userID {
+			mine = append(mine, purchase)
+		}
+	}
+	return calculateSumByTarget(mine, period), nil
+}
+
+// DeletePurchases removes the purchases for which match is true and
+// returns them. Malformed records are kept, see telbotctl repair.
+func DeletePurchases(actor int, match func(Purchase) bool) ([]Purchase, error) {
+	categoryMu.Lock()
+	defer categoryMu.Unlock()
+	if err := loadCategories(); err != nil {
+		return nil, err
+	}
+
+	var deleted []Purchase
+	err := filterCSV("purchase_records.csv", func(record []string) bool {
+		purchase, err := parsePurchase(record)
+		if err != nil {
+			return true
+		}
+		purchase.Target = canonicalCategory(purchase.IDTele, purchase.Target)
+		if !match(purchase) {
+			return true
+		}
+		deleted = append(deleted, purchase)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, purchase := range deleted {
+		audit.Record(audit.Entry{Actor: actor, Action: "purchase.delete", Subject: purchase.Target, Before: purchase})
+	}
+	return deleted, nil
+}
+
+// DeleteBudgets removes the budgets for which match is true and returns
+// them.
+func DeleteBudgets(actor int, match func(Budget) bool) ([]Budget, error) {
+	var deleted []Budget
+	err := filterCSV("budgets.csv", func(record []string) bool {
+		if len(record) < 5 {
+			return true
+		}
+		budget, err := parseBudget(record)
+		if err != nil || !match(budget) {
+			return true
+		}
+		deleted = append(deleted, budget)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, budget := range deleted {
+		audit.Record(audit.Entry{Actor: actor, Action: "budget.delete", Subject: budget.Category, Before: budget})
+	}
+	return deleted, nil
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"Telbot/utils"
+	"encoding/json"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"strings"
+)
+
+// HandleHelp registers /help, listing the commands by group, and
+// "/help command" with the usage of one command. /start shows the same list.
+func (r *Router) HandleHelp() {
+	help := func(m *telebot.Message) {
+		name := strings.TrimSpace(m.Payload)
+		if name == "" {
+			utils.Send(r.Bot, m.Chat, r.helpText())
+			return
+		}
+
+		if !strings.HasPrefix(name, "/") {
+			name = "/" + name
+		}
+		for _, c := range r.commands {
+			if strings.EqualFold(c.Name, name) && !c.Hidden {
+				utils.Send(r.Bot, m.Chat, c.Description+"\n"+c.Usage())
+				return
+			}
+		}
+		utils.Send(r.Bot, m.Chat, fmt.Sprintf("Unknown command %s, see /help.", name))
+	}
+
+	r.Handle(Command{
+		Name:        "/help",
+		Group:       "General",
+		Description: "List the commands, or show how to use one",
+		Args:        []Arg{{Name: "command", Optional: true}},
+		Handler:     help,
+	})
+	r.Handle(Command{Name: "/start", Hidden: true, Handler: help})
+}
+
+func (r *Router) helpText() string {
+	var groups []string
+	byGroup := map[string][]*Command{}
+	for _, c := range r.commands {
+		if c.Hidden {
+			continue
+		}
+		if _, ok := byGroup[c.Group]; !ok {
+			groups = append(groups, c.Group)
+		}
+		byGroup[c.Group] = append(byGroup[c.Group], c)
+	}
+
+	text := "Commands:\n"
+	for _, group := range groups {
+		if group != "" {
+			text += "\n" + group + ":\n"
+		}
+		for _, c := range byGroup[group] {
+			text += fmt.Sprintf("%s - %s\n", c.Name, c.Description)
+		}
+	}
+	return text + "\nSend /help [command] for its arguments."
+}
+
+// SetMyCommands publishes the registered commands as the Telegram command menu.
+func (r *Router) SetMyCommands() error {
+	type botCommand struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+
+	var commands []botCommand
+	for _, c := range r.commands {
+		if c.Hidden {
+			continue
+		}
+		commands = append(commands, botCommand{
+			Command:     strings.ToLower(strings.TrimPrefix(c.Name, "/")),
+			Description: c.Description,
+		})
+	}
+
+	data, err := r.Bot.Raw("setMyCommands", map[string]interface{}{"commands": commands})
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("setMyCommands: %s", resp.Description)
+	}
+	return nil
+}
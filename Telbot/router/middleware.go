@@ -0,0 +1,78 @@
+package router
+
+import (
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Recover replies with an apology instead of failing silently when a
+// handler panics, and logs the stack.
+func Recover(bot *telebot.Bot) Middleware {
+	return func(cmd *Command, next Handler) Handler {
+		return func(m *telebot.Message) {
+			defer func() {
+				if r := recover(); r != nil {
+					panics.Inc(commandName(cmd))
+					Log(m).Error("handler panicked", "command", commandName(cmd), "panic", r, "stack", string(debug.Stack()))
+					utils.Send(bot, m.Chat, "Something went wrong, please try again.")
+				}
+			}()
+			next(m)
+		}
+	}
+}
+
+var (
+	requestIDs    sync.Map // *telebot.Message -> request ID, while it is handled
+	lastRequestID atomic.Uint64
+)
+
+// Logging gives every update a request ID and logs the command with its
+// sender, chat and latency. It should be the first middleware so the
+// others can log with the request ID, see Log.
+func Logging(cmd *Command, next Handler) Handler {
+	return func(m *telebot.Message) {
+		id := newRequestID()
+		requestIDs.Store(m, id)
+		defer requestIDs.Delete(m)
+
+		start := time.Now()
+		next(m)
+		Log(m).Info("handled", "command", commandName(cmd), "latency", time.Since(start))
+	}
+}
+
+// Log returns a logger carrying the request ID, user and chat of the message.
+func Log(m *telebot.Message) *slog.Logger {
+	logger := slog.Default()
+	if id, ok := requestIDs.Load(m); ok {
+		logger = logger.With("request_id", id)
+	}
+	if m.Sender != nil {
+		logger = logger.With("user_id", m.Sender.ID)
+	}
+	if m.Chat != nil {
+		logger = logger.With("chat_id", m.Chat.ID)
+	}
+	return logger
+}
+
+func newRequestID() string {
+	return fmt.Sprintf("%x-%d", time.Now().Unix(), lastRequestID.Add(1))
+}
+
+func commandName(cmd *Command) string {
+	switch cmd.Name {
+	case telebot.OnText:
+		return "text"
+	case telebot.OnDocument:
+		return "document"
+	}
+	return cmd.Name
+}
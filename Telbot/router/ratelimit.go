@@ -0,0 +1,225 @@
+package router
+
+import (
+	"Telbot/metrics"
+	"Telbot/utils"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows N commands per period, in bursts of up to N.
+type Limit struct {
+	N   int
+	Per time.Duration
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.N, l.Per)
+}
+
+// ParseLimit reads a limit written like "3/1m", i.e. 3 per minute.
+func ParseLimit(s string) (Limit, error) {
+	n, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, want e.g. 3/1m", s)
+	}
+	var l Limit
+	var err error
+	if l.N, err = strconv.Atoi(n); err != nil || l.N <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, want e.g. 3/1m", s)
+	}
+	if l.Per, err = time.ParseDuration(per); err != nil || l.Per <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, want e.g. 3/1m", s)
+	}
+	return l, nil
+}
+
+// Limits configures RateLimit. A command's own Limit replaces User for
+// that command, and Commands, keyed by command name, replaces both. A zero
+// Limit doesn't limit.
+type Limits struct {
+	User     Limit // Per user and command
+	Chat     Limit // Per chat, all commands together
+	Commands map[string]Limit
+}
+
+// ParseLimits reads per command limits written like
+// "/sumPurchases=3/1m,/addDebtor=10/1m".
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q, want e.g. /sumPurchases=3/1m", entry)
+		}
+		l, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(name, "/") {
+			name = "/" + name
+		}
+		limits[name] = l
+	}
+	return limits, nil
+}
+
+var throttled = metrics.NewCounter("telbot_throttled_total",
+	"Commands refused by the rate limit, by command and scope (user or chat).", "command", "scope")
+
+// bucket is a token bucket, refilled continuously at N tokens per period.
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+	warned bool // Told about the limit since the last allowed command
+}
+
+// refill adds the tokens earned since the last command.
+func (b *bucket) refill(now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(b.limit.N)
+	} else {
+		b.tokens = math.Min(float64(b.limit.N), b.tokens+now.Sub(b.last).Seconds()*b.rate())
+	}
+	b.last = now
+}
+
+// wait tells how long until a token is available, after refill.
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate() * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled, so it can be forgotten.
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate() >= float64(b.limit.N)
+}
+
+// rate is in tokens per second.
+func (b *bucket) rate() float64 {
+	return float64(b.limit.N) / b.limit.Per.Seconds()
+}
+
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// maxBuckets bounds memory, refilled buckets are dropped past it.
+const maxBuckets = 10000
+
+// quota is a bucket a command takes a token from.
+type quota struct {
+	scope string // user or chat
+	key   string
+	limit Limit
+}
+
+// allow takes a token from each quota, or from none if one of them is
+// empty. It returns the empty quota, how long until it has a token, and
+// whether to warn about it.
+func (l *limiter) allow(quotas ...quota) (*quota, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.buckets) > maxBuckets {
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	buckets := make([]*bucket, len(quotas))
+	for i, q := range quotas {
+		b := l.buckets[q.key]
+		if b == nil {
+			b = &bucket{limit: q.limit}
+			l.buckets[q.key] = b
+		}
+		b.refill(now)
+		buckets[i] = b
+	}
+
+	// Checked before taking any, a refused command doesn't cost a token
+	for i, b := range buckets {
+		if wait := b.wait(); wait > 0 {
+			warn := !b.warned
+			b.warned = true
+			return &quotas[i], wait, warn
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+		b.warned = false
+	}
+	return nil, 0, false
+}
+
+// RateLimit refuses commands past the user's or the chat's limit with a
+// polite reply, sent once until the user may send again. Plain text, e.g.
+// quick entries and answers to guided commands, isn't limited.
+func RateLimit(bot *telebot.Bot, limits Limits) Middleware {
+	l := &limiter{buckets: map[string]*bucket{}, now: time.Now}
+
+	return func(cmd *Command, next Handler) Handler {
+		if cmd.Name == telebot.OnText {
+			return next
+		}
+
+		userLimit := limits.User
+		if cmd.Limit.N > 0 {
+			userLimit = cmd.Limit
+		}
+		for name, limit := range limits.Commands {
+			if strings.EqualFold(name, cmd.Name) {
+				userLimit = limit
+			}
+		}
+
+		return func(m *telebot.Message) {
+			var quotas []quota
+			if userLimit.N > 0 {
+				quotas = append(quotas, quota{"user", fmt.Sprintf("user:%d:%s", m.Sender.ID, cmd.Name), userLimit})
+			}
+			if limits.Chat.N > 0 {
+				quotas = append(quotas, quota{"chat", fmt.Sprintf("chat:%d", m.Chat.ID), limits.Chat})
+			}
+			refused, wait, warn := l.allow(quotas...)
+			if refused == nil {
+				next(m)
+				return
+			}
+
+			throttled.Inc(commandName(cmd), refused.scope)
+			Log(m).Info("throttled", "command", commandName(cmd), "scope", refused.scope, "wait", wait.Round(time.Second))
+			if !warn {
+				return
+			}
+			seconds := int(math.Ceil(wait.Seconds()))
+			if refused.scope == "chat" {
+				utils.Send(bot, m.Chat, fmt.Sprintf("This chat is sending commands too fast. Please wait %d seconds.", seconds))
+				return
+			}
+			if cmd.button {
+				utils.Send(bot, m.Chat, fmt.Sprintf("Easy there! Please wait %d seconds before pressing that again.", seconds))
+				return
+			}
+			utils.Send(bot, m.Chat, fmt.Sprintf("Easy there! Please wait %d seconds before using %s again.", seconds, cmd.Name))
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package router
+
+import (
+	"Telbot/metrics"
+	"Telbot/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler handles one message sent to a command.
+type Handler func(m *telebot.Message)
+
+// Middleware wraps the handler of a command, e.g. to log or recover from
+// panics. cmd is the command being handled.
+type Middleware func(cmd *Command, next Handler) Handler
+
+// ArgKind tells how an argument is checked before the handler runs.
+type ArgKind int
+
+const (
+	Word   ArgKind = iota // One word, anything goes
+	Amount                // Amount with K, M, tr, ... (see utils.ParseAmount)
+	Period                // week, month or year
+	Name                  // A debtor name, a mention may span several words
+	Text                  // The rest of the message
+)
+
+var (
+	handled = metrics.NewCounter("telbot_commands_total",
+		"Commands and button presses received, by command or button.", "command")
+	handlerDuration = metrics.NewHistogram("telbot_handler_duration_seconds",
+		"Time spent handling a command or button press.", metrics.DefaultBuckets, "command")
+	panics = metrics.NewCounter("telbot_handler_panics_total",
+		"Handlers that panicked, by command or button.", "command")
+)
+
+// Arg describes one positional argument of a command.
+type Arg struct {
+	Name     string // Shown in the usage, e.g. "amount with K or M"
+	Kind     ArgKind
+	Optional bool
+}
+
+// Command is a bot command with the metadata shown in /help and in the
+// Telegram command menu.
+type Command struct {
+	Name        string // e.g. "/purchase"
+	Group       string // Section of /help, e.g. "Budgets"
+	Description string // One line for the menu
+	Args        []Arg
+	Note        string // Extra usage line, e.g. which arguments are optional
+	Guided      bool   // Without arguments the handler asks for them
+	Admin       bool   // Administers the bot or the shared ledger, only for the bot's owner and admins, see access.Middleware
+	Hidden      bool   // Left out of /help and the menu
+	Limit       Limit  // Per user, zero for the default of RateLimit
+	Handler     Handler
+
+	button bool // An inline button, see HandleCallback
+}
+
+// Usage is the usage line of the command.
+func (c *Command) Usage() string {
+	usage := "Usage: " + c.Name
+	for _, arg := range c.Args {
+		usage += " [" + arg.Name + "]"
+	}
+	if c.Note != "" {
+		usage += "\n" + c.Note
+	}
+	return usage
+}
+
+// Router registers commands on the bot, running every message through the
+// middleware chain and the argument checks before the handler.
+type Router struct {
+	Bot        *telebot.Bot
+	commands   []*Command
+	middleware []Middleware
+
+	mu       sync.Mutex
+	inFlight int           // Handlers and jobs running right now
+	draining bool          // Set by Drain, new updates are dropped
+	stop     chan struct{} // Closed by Drain to stop the jobs, see Every
+}
+
+func New(bot *telebot.Bot) *Router {
+	return &Router{Bot: bot}
+}
+
+// Use appends middleware, the first one added runs first. Commands
+// registered before the call are not affected.
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Handle registers the command. Telegram only allows lowercase names in
+// the command menu, so /addDebtor is also registered as /adddebtor.
+func (r *Router) Handle(cmd Command) {
+	c := &cmd
+	r.commands = append(r.commands, c)
+
+	handler := r.checkArgs(c)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](c, handler)
+	}
+
+	// telebot only calls plain func(*telebot.Message) values
+	h := func(m *telebot.Message) {
+		if !r.begin() {
+			return
+		}
+		defer r.end()
+
+		name := commandName(c)
+		handled.Inc(name)
+		defer handlerDuration.Time(name)()
+		handler(m)
+	}
+	r.Bot.Handle(c.Name, h)
+	if lower := strings.ToLower(c.Name); lower != c.Name {
+		r.Bot.Handle(lower, h)
+	}
+}
+
+// HandleCallback registers the handler of an inline button. Presses go
+// through the middleware like commands, so access control and rate limits
+// apply, and Drain waits for them.
+func (r *Router) HandleCallback(button *telebot.InlineButton, handler func(c *telebot.Callback)) {
+	cmd := &Command{Name: "button:" + button.Unique, Hidden: true, button: true}
+	middleware := slices.Clone(r.middleware)
+
+	r.Bot.Handle(button, func(c *telebot.Callback) {
+		if !r.begin() {
+			return
+		}
+		defer r.end()
+
+		// The middleware sees the press as a message from the presser in
+		// the chat of the button, their private chat for inline messages
+		m := &telebot.Message{Sender: c.Sender, Text: c.Data}
+		if c.Message != nil {
+			m.Chat = c.Message.Chat
+		} else if c.Sender != nil {
+			m.Chat = &telebot.Chat{ID: int64(c.Sender.ID), Type: telebot.ChatPrivate}
+		}
+		if m.Sender == nil || m.Chat == nil {
+			return
+		}
+
+		ran := false
+		var h Handler = func(*telebot.Message) {
+			ran = true
+			handler(c)
+		}
+		for i := len(middleware) - 1; i >= 0; i-- {
+			h = middleware[i](cmd, h)
+		}
+
+		handled.Inc(cmd.Name)
+		defer handlerDuration.Time(cmd.Name)()
+		h(m)
+		// Refused presses still need an answer, or the button keeps spinning
+		if !ran {
+			utils.Respond(r.Bot, c)
+		}
+	})
+}
+
+// Commands returns the registered commands in registration order.
+func (r *Router) Commands() []*Command {
+	return r.commands
+}
+
+// checkArgs replies with the usage instead of calling the handler when an
+// argument is missing or malformed.
+func (r *Router) checkArgs(c *Command) Handler {
+	return func(m *telebot.Message) {
+		if err := validate(c, m); err != nil {
+			utils.Send(r.Bot, m.Chat, utils.Sentence(err)+"\n"+c.Usage())
+			return
+		}
+		c.Handler(m)
+	}
+}
+
+func (r *Router) begin() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return false
+	}
+	r.inFlight++
+	return true
+}
+
+func (r *Router) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight--
+}
+
+// Drain stops accepting updates and waits for the running handlers to
+// finish, or for ctx to end. It returns how many handlers it waited for
+// and how many were still running when it gave up.
+func (r *Router) Drain(ctx context.Context) (drained, abandoned int) {
+	r.mu.Lock()
+	if !r.draining {
+		close(r.stopLocked())
+	}
+	r.draining = true
+	running := r.inFlight
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r.mu.Lock()
+		left := r.inFlight
+		r.mu.Unlock()
+
+		if left == 0 {
+			return running, 0
+		}
+		select {
+		case <-ctx.Done():
+			return running - left, left
+		case <-ticker.C:
+		}
+	}
+}
+
+// Every runs the job now and then at every interval, e.g. to send
+// reminders, until Drain. Drain waits for a run in progress like for a
+// handler, so the stores aren't written by a job while they are flushed.
+func (r *Router) Every(interval time.Duration, job func()) {
+	r.mu.Lock()
+	stop := r.stopLocked()
+	r.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if !r.begin() {
+				return
+			}
+			job()
+			r.end()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopLocked returns the channel Drain closes. The caller must hold r.mu.
+func (r *Router) stopLocked() chan struct{} {
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+	}
+	return r.stop
+}
+
+// Draining reports whether Drain was called, i.e. the bot is shutting down.
+func (r *Router) Draining() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.draining
+}
+
+func validate(c *Command, m *telebot.Message) error {
+	words := strings.Fields(m.Payload)
+	if len(words) == 0 && c.Guided {
+		return nil
+	}
+
+	for i, arg := range c.Args {
+		if i >= len(words) {
+			if arg.Optional {
+				return nil
+			}
+			return fmt.Errorf("missing %s", arg.Name)
+		}
+
+		switch arg.Kind {
+		case Amount:
+			if _, err := utils.ParseAmount(words[i]); err != nil {
+				return errors.New("please provide a valid number for the amount")
+			}
+		case Period:
+			if words[i] != "week" && words[i] != "month" && words[i] != "year" {
+				return errors.New("please specify a valid period: week, month or year")
+			}
+		case Name:
+			if hasTextMention(m) {
+				return nil
+			}
+		case Text:
+			return nil
+		}
+	}
+	return nil
+}
+
+// hasTextMention reports whether the message mentions a user without a
+// username, whose name may be several words.
+func hasTextMention(m *telebot.Message) bool {
+	for _, e := range m.Entities {
+		if e.Type == telebot.EntityTMention {
+			return true
+		}
+	}
+	return false
+}
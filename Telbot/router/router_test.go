@@ -0,0 +1,199 @@
+package router
+
+import (
+	"context"
+	"github.com/tucnak/telebot"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	cmd := &Command{
+		Name: "/purchase",
+		Args: []Arg{
+			{Name: "amount", Kind: Amount},
+			{Name: "target", Kind: Text},
+			{Name: "period", Kind: Period, Optional: true},
+		},
+		Guided: true,
+	}
+
+	cases := []struct {
+		payload string
+		ok      bool
+	}{
+		{"", true}, // Guided
+		{"35k coffee", true},
+		{"35k", false},
+		{"abc coffee", false},
+		{"35k coffee at the station", true}, // Text takes the rest
+	}
+	for _, c := range cases {
+		err := validate(cmd, &telebot.Message{Payload: c.payload})
+		if (err == nil) != c.ok {
+			t.Errorf("validate(%q) = %v, want ok %v", c.payload, err, c.ok)
+		}
+	}
+
+	budget := &Command{Name: "/budget", Args: []Arg{{Name: "period", Kind: Period}}}
+	if validate(budget, &telebot.Message{Payload: "day"}) == nil {
+		t.Error("accepted an invalid period")
+	}
+	if validate(budget, &telebot.Message{}) == nil {
+		t.Error("accepted a missing argument of a command that isn't guided")
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	r := &Router{}
+	r.commands = []*Command{
+		{Name: "/purchase", Group: "Purchases", Description: "Record a purchase"},
+		{Name: "/checkBudget", Group: "Budgets", Description: "Check budgets"},
+		{Name: "/start", Hidden: true},
+		{Name: "/sumPurchases", Group: "Purchases", Description: "Totals"},
+	}
+
+	text := r.helpText()
+	if strings.Contains(text, "/start") {
+		t.Error("hidden command listed")
+	}
+	purchases := strings.Index(text, "Purchases:")
+	if purchases < 0 || strings.Index(text, "/sumPurchases") < purchases || strings.Index(text, "Budgets:") < strings.Index(text, "/sumPurchases") {
+		t.Errorf("commands not grouped in registration order:\n%s", text)
+	}
+
+	usage := (&Command{Name: "/alias", Args: []Arg{{Name: "name"}, {Name: "other name"}}}).Usage()
+	if usage != "Usage: /alias [name] [other name]" {
+		t.Errorf("usage = %q", usage)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	r := &Router{}
+	if !r.begin() {
+		t.Fatal("handler refused before draining")
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.end()
+		close(finished)
+	}()
+
+	drained, abandoned := r.Drain(context.Background())
+	<-finished
+	if drained != 1 || abandoned != 0 {
+		t.Errorf("Drain = %d drained, %d abandoned, want 1, 0", drained, abandoned)
+	}
+	if r.begin() {
+		t.Error("handler accepted while draining")
+	}
+
+	// A handler that never finishes is abandoned at the deadline
+	r = &Router{}
+	r.begin()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if drained, abandoned := r.Drain(ctx); drained != 0 || abandoned != 1 {
+		t.Errorf("Drain = %d drained, %d abandoned, want 0, 1", drained, abandoned)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	r := &Router{}
+	runs := make(chan int, 10)
+	release := make(chan struct{})
+	n := 0
+	r.Every(time.Millisecond, func() {
+		n++
+		runs <- n
+		if n == 2 {
+			<-release // Still running when Drain starts
+		}
+	})
+
+	<-runs
+	<-runs
+	drained := make(chan int)
+	go func() {
+		d, _ := r.Drain(context.Background())
+		drained <- d
+	}()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-drained:
+		t.Fatal("Drain didn't wait for the running job")
+	default:
+	}
+
+	close(release)
+	if d := <-drained; d != 1 {
+		t.Errorf("Drain waited for %d jobs, want 1", d)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(runs) != 0 {
+		t.Errorf("the job ran %d times after Drain", len(runs))
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 12, 1, 9, 0, 0, 0, time.UTC)
+	l := &limiter{buckets: map[string]*bucket{}, now: func() time.Time { return now }}
+	limit := Limit{N: 2, Per: time.Minute}
+	user1 := quota{"user", "user:1", limit}
+
+	for i := 0; i < 2; i++ {
+		if refused, _, _ := l.allow(user1); refused != nil {
+			t.Fatalf("command %d refused within the burst", i+1)
+		}
+	}
+	refused, wait, warn := l.allow(user1)
+	if refused == nil || !warn || wait != 30*time.Second {
+		t.Errorf("third command: refused %v, warn %v, wait %s, want refused with a warning and 30s wait", refused, warn, wait)
+	}
+	if _, _, warn := l.allow(user1); warn {
+		t.Error("warned twice")
+	}
+	if refused, _, _ := l.allow(quota{"user", "user:2", limit}); refused != nil {
+		t.Error("another user was limited")
+	}
+
+	now = now.Add(30 * time.Second)
+	if refused, _, _ := l.allow(user1); refused != nil {
+		t.Error("refused after a token was refilled")
+	}
+}
+
+func TestLimiterChat(t *testing.T) {
+	now := time.Date(2024, 12, 1, 9, 0, 0, 0, time.UTC)
+	l := &limiter{buckets: map[string]*bucket{}, now: func() time.Time { return now }}
+	user := quota{"user", "user:1", Limit{N: 1, Per: time.Minute}}
+	chat := quota{"chat", "chat:1", Limit{N: 1, Per: time.Minute}}
+
+	// Someone else used up the chat's limit
+	l.allow(quota{"user", "user:2", user.limit}, chat)
+	if refused, _, _ := l.allow(user, chat); refused == nil || refused.scope != "chat" {
+		t.Fatalf("refused %v, want the chat", refused)
+	}
+	// The refusal didn't cost the user's token
+	if refused, _, _ := l.allow(user); refused != nil {
+		t.Error("the user's token was spent on a refused command")
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("/sumPurchases=3/1m, addDebtor=10/30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["/sumPurchases"] != (Limit{3, time.Minute}) || limits["/addDebtor"] != (Limit{10, 30 * time.Second}) {
+		t.Errorf("limits = %v", limits)
+	}
+	for _, bad := range []string{"/sumPurchases", "/sumPurchases=3", "/sumPurchases=0/1m", "/x=3/soon"} {
+		if _, err := ParseLimits(bad); err == nil {
+			t.Errorf("ParseLimits(%q) accepted", bad)
+		}
+	}
+}
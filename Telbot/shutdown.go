@@ -0,0 +1,76 @@
+package main
+
+import (
+	"Telbot/access"
+	"Telbot/debt"
+	"Telbot/purchase"
+	"Telbot/router"
+	"context"
+	"github.com/tucnak/telebot"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultShutdownTimeout is used when TELBOT_SHUTDOWN_TIMEOUT isn't set.
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdown stops the poller, waits for running handlers and jobs (see
+// router.Every) and writes the stores one last time, all within the
+// configured timeout. The metrics server stays up until the end so /readyz
+// reports the shutdown.
+func shutdown(bot *telebot.Bot, r *router.Router, stopped <-chan struct{}, server *http.Server) {
+	timeout := defaultShutdownTimeout
+	if value := os.Getenv("TELBOT_SHUTDOWN_TIMEOUT"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			timeout = d
+		} else {
+			slog.Warn("invalid TELBOT_SHUTDOWN_TIMEOUT", "value", value, "using", timeout)
+		}
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	go bot.Stop()
+	poller := "stopped"
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		poller = "still running"
+	}
+
+	drained, abandoned := r.Drain(ctx)
+
+	var flushed []string
+	for _, flush := range []func() (string, error){debt.Flush, purchase.Flush, access.Flush} {
+		done := make(chan struct{})
+		var summary string
+		var err error
+		go func() {
+			summary, err = flush()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			if err != nil {
+				flushed = append(flushed, "error: "+err.Error())
+			} else {
+				flushed = append(flushed, summary)
+			}
+		case <-ctx.Done():
+			flushed = append(flushed, "timed out")
+		}
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("failed to stop the metrics server", "err", err)
+	}
+
+	slog.Info("shut down", "duration", time.Since(start).Round(time.Millisecond), "poller", poller,
+		"drained", drained, "abandoned", abandoned, "flushed", strings.Join(flushed, ", "))
+}
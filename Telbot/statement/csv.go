@@ -0,0 +1,219 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Mapping tells which columns of a bank's CSV export hold what, by header
+// name or by number counting from 1. Empty columns are guessed from the
+// header. Banks that list money spent in its own column set Debit, and
+// Credit for money received, instead of Amount.
+type Mapping struct {
+	Date        string
+	Amount      string
+	Debit       string
+	Credit      string
+	Description string
+	DateFormat  string // e.g. "dd/mm/yyyy", the default, or "mm/dd/yyyy"
+}
+
+// mappingKeys are the keys of ParseMapping, in the order of String.
+var mappingKeys = []string{"date", "amount", "debit", "credit", "description", "format"}
+
+func (m *Mapping) field(key string) *string {
+	switch key {
+	case "date":
+		return &m.Date
+	case "amount":
+		return &m.Amount
+	case "debit":
+		return &m.Debit
+	case "credit":
+		return &m.Credit
+	case "description":
+		return &m.Description
+	case "format":
+		return &m.DateFormat
+	}
+	return nil
+}
+
+// ParseMapping reads a mapping written like String, e.g.
+// "date=Ngày GD, debit=Ghi nợ, description=Nội dung, format=dd/mm/yyyy".
+func ParseMapping(s string) (Mapping, error) {
+	var m Mapping
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		field := m.field(strings.ToLower(strings.TrimSpace(key)))
+		if !ok || field == nil {
+			return Mapping{}, fmt.Errorf("%q is not one of %s followed by = and a column", strings.TrimSpace(pair), strings.Join(mappingKeys, ", "))
+		}
+		*field = strings.TrimSpace(value)
+	}
+	if m.Amount != "" && (m.Debit != "" || m.Credit != "") {
+		return Mapping{}, fmt.Errorf("give either amount or debit and credit")
+	}
+	return m, nil
+}
+
+func (m Mapping) String() string {
+	var pairs []string
+	for _, key := range mappingKeys {
+		if value := *m.field(key); value != "" {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// headerNames are the usual names of each column, lowercase.
+var headerNames = map[string][]string{
+	"date":        {"date", "transaction date", "posting date", "posted date", "booking date", "value date", "ngày", "ngày giao dịch", "ngày gd", "ngay", "ngay giao dich"},
+	"amount":      {"amount", "transaction amount", "số tiền", "so tien"},
+	"debit":       {"debit", "debit amount", "withdrawal", "withdrawals", "money out", "ghi nợ", "ghi no", "số tiền ghi nợ", "phát sinh nợ"},
+	"credit":      {"credit", "credit amount", "deposit", "deposits", "money in", "ghi có", "ghi co", "số tiền ghi có", "phát sinh có"},
+	"description": {"description", "details", "narrative", "payee", "memo", "reference", "transaction details", "nội dung", "noi dung", "diễn giải", "dien giai", "mô tả", "mo ta", "nội dung giao dịch"},
+}
+
+// columns are the indexes of the mapped columns in a row, -1 when absent.
+type columns struct {
+	date, amount, debit, credit, description int
+}
+
+// locate finds the columns in the header row, returning false when the
+// row doesn't hold a date and an amount.
+func (m Mapping) locate(header []string) (columns, bool) {
+	find := func(key, given string) int {
+		for i, name := range header {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if given != "" {
+				if n, err := strconv.Atoi(given); err == nil {
+					if n >= 1 && n <= len(header) {
+						return n - 1
+					}
+					return -1
+				}
+				if name == strings.ToLower(given) {
+					return i
+				}
+			} else if slices.Contains(headerNames[key], name) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	c := columns{
+		date:        find("date", m.Date),
+		description: find("description", m.Description),
+		amount:      -1,
+		debit:       find("debit", m.Debit),
+		credit:      find("credit", m.Credit),
+	}
+	if m.Debit == "" && m.Credit == "" {
+		c.amount = find("amount", m.Amount)
+	}
+	if c.amount >= 0 {
+		c.debit, c.credit = -1, -1
+	}
+	return c, c.date >= 0 && (c.amount >= 0 || c.debit >= 0)
+}
+
+// parseCSV reads a CSV export. Banks often put the account details above
+// the header row, so lines are skipped until a row has the mapped columns.
+func parseCSV(data []byte, m Mapping) ([]Transaction, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	dates := layouts(m.DateFormat, []string{"2/1/2006", "2006-01-02", "2-1-2006", "2.1.2006", "2/1/06"})
+	start, cols := -1, columns{}
+	for i, record := range records {
+		if c, ok := m.locate(record); ok {
+			start, cols = i+1, c
+			// Columns given by number may have no header at all
+			if _, err := parseDate(record[c.date], dates); err == nil {
+				start = i
+			}
+			break
+		}
+	}
+	if start < 0 {
+		if m == (Mapping{}) {
+			return nil, fmt.Errorf("no header row with a date and an amount column, set a mapping")
+		}
+		return nil, fmt.Errorf("no header row with the columns %s", m)
+	}
+
+	var transactions []Transaction
+	for i, record := range records[start:] {
+		cell := func(index int) string {
+			if index < 0 || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+		if strings.Join(record, "") == "" || cell(cols.date) == "" {
+			continue // Blank lines and totals at the end
+		}
+
+		line := start + i + 1
+		date, err := parseDate(cell(cols.date), dates)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		amount := 0
+		if cols.amount >= 0 {
+			amount, err = ParseAmount(cell(cols.amount))
+		} else {
+			var debit, credit int
+			if value := cell(cols.debit); value != "" {
+				debit, err = ParseAmount(value)
+			}
+			if value := cell(cols.credit); value != "" && err == nil {
+				credit, err = ParseAmount(value)
+			}
+			amount = abs(credit) - abs(debit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		transactions = append(transactions, Transaction{Date: date, Amount: amount, Description: cell(cols.description)})
+	}
+	return transactions, nil
+}
+
+// delimiter guesses the separator from the line with the most of one.
+func delimiter(data []byte) rune {
+	best, bestCount := ',', 0
+	for _, line := range bytes.SplitN(data, []byte("\n"), 20) {
+		for _, d := range []rune{',', ';', '\t'} {
+			if n := bytes.Count(line, []byte(string(d))); n > bestCount {
+				best, bestCount = d, n
+			}
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
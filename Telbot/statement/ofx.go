@@ -0,0 +1,63 @@
+package statement
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseOFX reads the transactions of an OFX file. Version 1 is SGML where
+// closing tags are optional, e.g. "<TRNAMT>-35000.00", version 2 is XML;
+// both come down to a tag followed by its value.
+func parseOFX(data string) ([]Transaction, error) {
+	start := strings.Index(strings.ToUpper(data), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("not an OFX file")
+	}
+
+	var transactions []Transaction
+	var current map[string]string
+	for _, part := range strings.Split(data[start:], "<")[1:] {
+		tag, value, _ := strings.Cut(part, ">")
+		tag = strings.ToUpper(strings.TrimSpace(tag))
+		value = strings.TrimSpace(value)
+
+		switch {
+		case tag == "STMTTRN":
+			current = map[string]string{}
+		case tag == "/STMTTRN" && current != nil:
+			t, err := ofxTransaction(current)
+			if err != nil {
+				return nil, err
+			}
+			transactions = append(transactions, t)
+			current = nil
+		case current != nil && !strings.HasPrefix(tag, "/"):
+			current[tag] = unescapeSGML(value)
+		}
+	}
+	return transactions, nil
+}
+
+func ofxTransaction(fields map[string]string) (Transaction, error) {
+	// Dates are YYYYMMDD, maybe followed by the time and zone
+	posted := fields["DTPOSTED"]
+	date, err := parseDate(posted[:min(len(posted), 8)], []string{"20060102"})
+	if err != nil {
+		return Transaction{}, err
+	}
+	amount, err := ParseAmount(fields["TRNAMT"])
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	description := fields["NAME"]
+	if fields["PAYEE"] != "" && description == "" {
+		description = fields["PAYEE"]
+	}
+	if memo := fields["MEMO"]; memo != "" && memo != description {
+		description = strings.TrimSpace(description + " " + memo)
+	}
+	return Transaction{Date: date, Amount: amount, Description: description, ID: fields["FITID"]}, nil
+}
+
+var unescapeSGML = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'").Replace
@@ -0,0 +1,57 @@
+package statement
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseQIF reads a QIF file: one field per line, keyed by its first
+// letter, and "^" after each transaction. Quicken writes dates month first,
+// e.g. "12/25/2024" or "12/25'24".
+func parseQIF(data, format string) ([]Transaction, error) {
+	dates := layouts(format, []string{"1/2/2006", "1/2/06", "2006-01-02", "2/1/2006"})
+
+	var transactions []Transaction
+	var current Transaction
+	var payee, memo string
+	started := false
+	for n, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "!") {
+			continue
+		}
+		value := strings.TrimSpace(line[1:])
+
+		var err error
+		switch line[0] {
+		case 'D':
+			current.Date, err = parseDate(strings.ReplaceAll(value, "'", "/"), dates)
+			started = true
+		case 'T', 'U':
+			current.Amount, err = ParseAmount(value)
+			started = true
+		case 'P':
+			payee = value
+		case 'M':
+			memo = value
+		case 'L':
+			// Transfers between accounts are written [Account]
+			if !strings.HasPrefix(value, "[") {
+				current.Category, _, _ = strings.Cut(value, ":")
+			}
+		case '^':
+			if started {
+				current.Description = strings.TrimSpace(payee + " " + memo)
+				if payee == memo {
+					current.Description = payee
+				}
+				transactions = append(transactions, current)
+			}
+			current, payee, memo, started = Transaction{}, "", "", false
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+	}
+	return transactions, nil
+}
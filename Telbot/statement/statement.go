@@ -0,0 +1,121 @@
+// Package statement reads bank statements: CSV exports, given a mapping of
+// their columns, OFX (and QFX) files and QIF files.
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Transaction is one line of a statement.
+type Transaction struct {
+	Date        time.Time
+	Amount      int    // Negative for money spent, positive for money received
+	Description string // Payee and memo
+	Category    string // Given by the bank or the app that wrote the file, often empty
+	ID          string // Transaction ID of the bank, OFX only
+}
+
+// Parse reads the statement, picking the format from the file name or,
+// failing that, from the content.
+func Parse(filename string, data []byte, m Mapping) ([]Transaction, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Byte order mark of Excel exports
+	head := strings.ToUpper(string(data[:min(len(data), 512)]))
+
+	var transactions []Transaction
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".ofx" || ext == ".qfx" || strings.Contains(head, "OFXHEADER") || strings.Contains(head, "<OFX>"):
+		transactions, err = parseOFX(string(data))
+	case ext == ".qif" || strings.HasPrefix(head, "!TYPE:"):
+		transactions, err = parseQIF(string(data), m.DateFormat)
+	default:
+		transactions, err = parseCSV(data, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, fmt.Errorf("no transactions found")
+	}
+	return transactions, nil
+}
+
+// ParseAmount reads amounts as banks write them: "-35,000", "35.000 VND",
+// "(1,234.50)", "1.234,50-". A single separator followed by three digits
+// groups thousands, otherwise it starts the decimals, which are rounded.
+func ParseAmount(s string) (int, error) {
+	negative := false
+	var digits strings.Builder
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case r == '-' || r == '(' || r == '−':
+			negative = true
+		case unicode.IsDigit(r) || r == '.' || r == ',':
+			digits.WriteRune(r)
+		}
+	}
+	number := digits.String()
+	if number == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	lastDot, lastComma := strings.LastIndex(number, "."), strings.LastIndex(number, ",")
+	decimal := -1
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimal = max(lastDot, lastComma)
+	case lastDot >= 0 || lastComma >= 0:
+		at := max(lastDot, lastComma)
+		separator := number[at : at+1]
+		if strings.Count(number, separator) == 1 && len(number)-at-1 != 3 {
+			decimal = at
+		}
+	}
+
+	whole, fraction := number, ""
+	if decimal >= 0 {
+		whole, fraction = number[:decimal], number[decimal+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+	value, err := strconv.ParseFloat(whole+"."+fraction+"0", 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	amount := int(math.Round(value))
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// dateLayouts turns a format such as "dd/mm/yyyy" into a Go layout.
+var dateLayouts = strings.NewReplacer("yyyy", "2006", "yy", "06", "mm", "1", "dd", "2")
+
+// parseDate reads the date at the start of s, ignoring a time after it,
+// trying the layouts in order.
+func parseDate(s string, layouts []string) (time.Time, error) {
+	day, _, _ := strings.Cut(strings.TrimSpace(s), " ")
+	day, _, _ = strings.Cut(day, "T")
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, day); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// layouts returns the layout of the format given in the mapping, or the
+// defaults when there is none.
+func layouts(format string, defaults []string) []string {
+	if format == "" {
+		return defaults
+	}
+	return []string{dateLayouts.Replace(strings.ToLower(format))}
+}
@@ -0,0 +1,154 @@
+package statement
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestParseAmount(t *testing.T) {
+	for input, want := range map[string]int{
+		"-35,000":     -35000,
+		"35.000 VND":  35000,
+		"1.234.567":   1234567,
+		"(1,234.50)":  -1235,
+		"1.234,50-":   -1235,
+		"-12.5":       -13,
+		"250000":      250000,
+		" 52,000 đ ":  52000,
+		"−1,000,000":  -1000000,
+		"1,5":         2,
+		"+2,000.00":   2000,
+		"-0.99":       -1,
+		"12,345,678.": 12345678,
+	} {
+		got, err := ParseAmount(input)
+		if err != nil || got != want {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+	if _, err := ParseAmount("n/a"); err == nil {
+		t.Error("parsed n/a")
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	data := "\xef\xbb\xbfSao kê tài khoản 0123456789\n" +
+		"Ngày GD;Nội dung;Ghi nợ;Ghi có\n" +
+		"01/12/2024;Highlands Coffee;35.000;\n" +
+		"02/12/2024;Lương tháng 11;;15.000.000\n" +
+		"\n" +
+		"03/12/2024 10:15;GRAB*A-1B2C;52.000;\n"
+
+	got, err := Parse("statement.csv", []byte(data), Mapping{Date: "ngày gd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Transaction{
+		{Date: date("2024-12-01"), Amount: -35000, Description: "Highlands Coffee"},
+		{Date: date("2024-12-02"), Amount: 15000000, Description: "Lương tháng 11"},
+		{Date: date("2024-12-03"), Amount: -52000, Description: "GRAB*A-1B2C"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transactions, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transaction %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseCSVByNumber(t *testing.T) {
+	data := "12/01/2024,-4.50,Coffee\n12/03/2024,-20.00,Books\n"
+	m, err := ParseMapping("date=1, amount=2, description=3, format=mm/dd/yyyy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse("export.txt", []byte(data), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Date != date("2024-12-01") || got[1].Amount != -20 || got[1].Description != "Books" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	m, err := ParseMapping("Date=Ngày GD, debit = Ghi nợ, description=Nội dung,format=dd/mm/yyyy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "date=Ngày GD, debit=Ghi nợ, description=Nội dung, format=dd/mm/yyyy"; m.String() != want {
+		t.Errorf("String() = %q, want %q", m.String(), want)
+	}
+	for _, bad := range []string{"day=1", "date", "amount=2, debit=3"} {
+		if _, err := ParseMapping(bad); err == nil {
+			t.Errorf("ParseMapping(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseOFX(t *testing.T) {
+	data := `OFXHEADER:100
+DATA:OFXSGML
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20241201120000[+7:ICT]
+<TRNAMT>-35000.00
+<FITID>2024120101
+<NAME>HIGHLANDS COFFEE
+<MEMO>Card 1234
+</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT</TRNTYPE><DTPOSTED>20241202</DTPOSTED><TRNAMT>500000</TRNAMT><FITID>2024120202</FITID><NAME>Tom &amp; Jerry</NAME></STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`
+	got, err := Parse("bank.qfx", []byte(data), Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Transaction{
+		{Date: date("2024-12-01"), Amount: -35000, Description: "HIGHLANDS COFFEE Card 1234", ID: "2024120101"},
+		{Date: date("2024-12-02"), Amount: 500000, Description: "Tom & Jerry", ID: "2024120202"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseQIF(t *testing.T) {
+	data := "!Type:Bank\r\nD12/25'24\r\nT-1,250.00\r\nPBookshop\r\nMGifts\r\nLShopping:Books\r\n^\r\n" +
+		"D12/26/2024\r\nU300.00\r\nPRefund\r\nL[Savings]\r\n^\r\n"
+	got, err := Parse("money.qif", []byte(data), Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Transaction{
+		{Date: date("2024-12-25"), Amount: -1250, Description: "Bookshop Gifts", Category: "Shopping"},
+		{Date: date("2024-12-26"), Amount: 300, Description: "Refund"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse("a.csv", []byte("foo,bar\n1,2\n"), Mapping{}); err == nil {
+		t.Error("parsed a CSV without date and amount columns")
+	}
+	if _, err := Parse("a.csv", []byte("date,amount\nyesterday,5\n"), Mapping{}); err == nil {
+		t.Error("parsed an invalid date")
+	}
+	if _, err := Parse("a.qif", []byte("!Type:Bank\n"), Mapping{}); err == nil {
+		t.Error("parsed an empty statement")
+	}
+}
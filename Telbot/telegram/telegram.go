@@ -0,0 +1,38 @@
+// Package telegram offers the commands of package core on Telegram.
+package telegram
+
+import (
+	"Telbot/core"
+	"Telbot/router"
+	"Telbot/utils"
+	"errors"
+	"github.com/tucnak/telebot"
+)
+
+// Messenger sends replies to Telegram chats.
+type Messenger struct {
+	Bot *telebot.Bot
+}
+
+func (t Messenger) Send(chatID int64, text string) error {
+	if utils.Send(t.Bot, &telebot.Chat{ID: chatID}, text) == nil {
+		return errors.New("telegram: send failed")
+	}
+	return nil
+}
+
+// Handle registers the core command on the router. limit is the per user
+// rate, zero for the default.
+func Handle(r *router.Router, cmd *core.Command, limit router.Limit) {
+	m := Messenger{Bot: r.Bot}
+	r.Handle(router.Command{
+		Name:        cmd.Name,
+		Group:       cmd.Group,
+		Description: cmd.Description,
+		Limit:       limit,
+		Handler: func(msg *telebot.Message) {
+			req := core.Request{UserID: msg.Sender.ID, Username: msg.Sender.Username, ChatID: msg.Chat.ID}
+			core.Handle(m, req, cmd.Name+" "+msg.Payload)
+		},
+	})
+}
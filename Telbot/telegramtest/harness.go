@@ -0,0 +1,99 @@
+package telegramtest
+
+import (
+	"github.com/tucnak/telebot"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ReplyTimeout is how long Expect waits for the bot to answer.
+var ReplyTimeout = 5 * time.Second
+
+// Harness talks to a running bot as one user in one chat.
+type Harness struct {
+	T      testing.TB
+	Server *Server
+	Bot    *telebot.Bot
+	User   telebot.User
+	Chat   telebot.Chat
+
+	seen int // Calls already matched by Expect
+}
+
+// Start runs a bot against a new Server, with handlers registered by
+// setup. The bot and the server stop when the test ends.
+func Start(t testing.TB, setup func(bot *telebot.Bot)) *Harness {
+	t.Helper()
+
+	server := NewServer()
+	bot, err := server.NewBot()
+	if err != nil {
+		server.Close()
+		t.Fatalf("failed to create the bot: %v", err)
+	}
+	setup(bot)
+
+	stopped := make(chan struct{})
+	go func() {
+		bot.Start()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		bot.Stop()
+		<-stopped
+		server.Close()
+	})
+
+	user := telebot.User{ID: 1001, FirstName: "Lan", Username: "lan"}
+	return &Harness{
+		T:      t,
+		Server: server,
+		Bot:    bot,
+		User:   user,
+		Chat:   telebot.Chat{ID: int64(user.ID), Type: telebot.ChatPrivate, Username: user.Username},
+	}
+}
+
+// Send types the text in the chat.
+func (h *Harness) Send(text string) {
+	h.Server.SendMessage(h.User, h.Chat, text)
+}
+
+// SendDocument uploads a file in the chat, with an optional caption.
+func (h *Harness) SendDocument(name string, data []byte, caption string) {
+	h.Server.SendDocument(h.User, h.Chat, name, data, caption)
+}
+
+// Press presses the inline button of the reply, given as "unique|data".
+func (h *Harness) Press(reply Call, button string) {
+	h.Server.Press(h.User, reply, button)
+}
+
+// Expect waits for a message sent or edited in the chat that contains
+// every one of the parts, after the last one matched. It fails the
+// test listing what the bot sent when none arrives.
+func (h *Harness) Expect(parts ...string) Call {
+	h.T.Helper()
+
+	c, i, err := h.Server.WaitFor(h.seen, ReplyTimeout, func(c Call) bool {
+		if c.Method == "answerCallbackQuery" || c.Method == "setMyCommands" || c.ChatID() != h.Chat.ID {
+			return false
+		}
+		for _, part := range parts {
+			if !strings.Contains(c.Text(), part) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		var sent []string
+		for _, c := range h.Server.Calls()[h.seen:] {
+			sent = append(sent, c.Method+": "+c.Text())
+		}
+		h.T.Fatalf("expected a reply containing %q, %v; got:\n%s", parts, err, strings.Join(sent, "\n"))
+	}
+	h.seen = i + 1
+	return c
+}
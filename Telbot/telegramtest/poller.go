@@ -0,0 +1,45 @@
+package telegramtest
+
+import (
+	"encoding/json"
+	"github.com/tucnak/telebot"
+	"strconv"
+)
+
+// Poller long polls getUpdates like telebot.LongPoller, but returns when
+// the bot stops instead of polling a closed server forever.
+type Poller struct {
+	lastID int
+}
+
+func (p *Poller) Poll(b *telebot.Bot, updates chan telebot.Update, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			close(stop)
+			return
+		default:
+		}
+
+		data, err := b.Raw("getUpdates", map[string]string{"offset": strconv.Itoa(p.lastID + 1), "timeout": "1"})
+		if err != nil {
+			continue
+		}
+		var response struct {
+			Result []telebot.Update
+		}
+		if json.Unmarshal(data, &response) != nil {
+			continue
+		}
+
+		for _, u := range response.Result {
+			p.lastID = u.ID
+			select {
+			case updates <- u:
+			case <-stop:
+				close(stop)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package telegramtest runs a stand-in for the Telegram Bot API, so
+// handlers can be tested end to end without a network or a real bot.
+//
+// The server answers the methods the bot uses (getMe, getUpdates,
+// sendMessage, editMessageText, answerCallbackQuery, sendPhoto,
+// sendDocument, getFile, ...), hands out updates injected by the test,
+// serves the files they hold and records everything the bot sends.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tucnak/telebot"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is the bot token the server expects.
+const Token = "123456:test"
+
+// BotUser is the bot itself, as returned by getMe.
+var BotUser = telebot.User{ID: 123456, FirstName: "Telbot", Username: "telbot_test_bot"}
+
+// Call is one request the bot made.
+type Call struct {
+	Method string
+	Params map[string]string // JSON or form fields, nested objects as JSON
+	File   string            // Name of the uploaded file, if any
+	Data   []byte            // Its content
+}
+
+// ChatID is the chat the call was addressed to.
+func (c Call) ChatID() int64 {
+	id, _ := strconv.ParseInt(c.Params["chat_id"], 10, 64)
+	return id
+}
+
+// Text is the message text, or the caption of a photo or document.
+func (c Call) Text() string {
+	if text, ok := c.Params["text"]; ok {
+		return text
+	}
+	return c.Params["caption"]
+}
+
+// Buttons lists the inline buttons of the message as "unique|data".
+func (c Call) Buttons() []string {
+	var markup telebot.ReplyMarkup
+	if json.Unmarshal([]byte(c.Params["reply_markup"]), &markup) != nil {
+		return nil
+	}
+	var buttons []string
+	for _, row := range markup.InlineKeyboard {
+		for _, b := range row {
+			buttons = append(buttons, strings.TrimPrefix(b.Data, "\f"))
+		}
+	}
+	return buttons
+}
+
+// Server is the fake Bot API. Create it with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	updates   []telebot.Update
+	calls     []Call
+	changed   chan struct{} // Closed and replaced when an update or call arrives
+	lastID    int           // Of updates, messages and callbacks
+	files     map[string][]byte
+	transport http.RoundTripper
+}
+
+// NewServer starts the server and routes the bot's requests to it until
+// Close. The bot talks to api.telegram.org through http.DefaultClient, so
+// only one server can run at a time.
+func NewServer() *Server {
+	s := &Server{changed: make(chan struct{}), files: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	s.transport = http.DefaultClient.Transport
+	target, _ := url.Parse(s.URL)
+	http.DefaultClient.Transport = redirect{target: target, next: s.Server.Client().Transport}
+	return s
+}
+
+// Close stops the server and lets requests reach Telegram again.
+func (s *Server) Close() {
+	http.DefaultClient.Transport = s.transport
+	s.Server.Close()
+}
+
+// redirect sends requests for api.telegram.org to the fake server.
+type redirect struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "api.telegram.org" {
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+		req.Host = r.target.Host
+	}
+	return r.next.RoundTrip(req)
+}
+
+// NewBot creates a bot connected to the server, polling with Poller.
+func (s *Server) NewBot() (*telebot.Bot, error) {
+	return telebot.NewBot(telebot.Settings{Token: Token, Poller: &Poller{}})
+}
+
+// SendMessage injects a message from the user in the chat, as if typed in
+// Telegram, and returns it.
+func (s *Server) SendMessage(from telebot.User, chat telebot.Chat, text string) *telebot.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	m := &telebot.Message{ID: s.lastID, Sender: &from, Chat: &chat, Text: text, Unixtime: time.Now().Unix()}
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		m.Entities = []telebot.MessageEntity{{Type: telebot.EntityCommand, Length: len(command)}}
+	}
+	s.push(telebot.Update{Message: m})
+	return m
+}
+
+// SendDocument injects a file sent by the user in the chat, with an
+// optional caption, and returns the message.
+func (s *Server) SendDocument(from telebot.User, chat telebot.Chat, name string, data []byte, caption string) *telebot.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	id := "file" + strconv.Itoa(s.lastID)
+	s.files[id] = data
+	m := &telebot.Message{ID: s.lastID, Sender: &from, Chat: &chat, Caption: caption, Unixtime: time.Now().Unix(),
+		Document: &telebot.Document{File: telebot.File{FileID: id, FileSize: len(data)}, FileName: name}}
+	s.push(telebot.Update{Message: m})
+	return m
+}
+
+// Press injects a press of the inline button, given as "unique|data" like
+// Call.Buttons, on a message the bot sent.
+func (s *Server) Press(from telebot.User, message Call, button string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, _ := strconv.Atoi(message.Params["message_id"])
+	chat := &telebot.Chat{ID: message.ChatID(), Type: telebot.ChatPrivate}
+	if chat.ID < 0 {
+		chat.Type = telebot.ChatGroup
+	}
+
+	s.lastID++
+	s.push(telebot.Update{Callback: &telebot.Callback{
+		ID:      strconv.Itoa(s.lastID),
+		Sender:  &from,
+		Message: &telebot.Message{ID: id, Chat: chat, Text: message.Text()},
+		Data:    "\f" + button,
+	}})
+}
+
+// push must be called with s.mu held.
+func (s *Server) push(u telebot.Update) {
+	s.lastID++
+	u.ID = s.lastID
+	s.updates = append(s.updates, u)
+	s.notify()
+}
+
+// notify must be called with s.mu held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Calls returns every request made so far, in order.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// WaitFor waits until a call made after the first skip calls matches, and
+// returns it with its index.
+func (s *Server) WaitFor(skip int, timeout time.Duration, match func(Call) bool) (Call, int, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		for i := skip; i < len(s.calls); i++ {
+			if match(s.calls[i]) {
+				c := s.calls[i]
+				s.mu.Unlock()
+				return c, i, nil
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return Call{}, 0, fmt.Errorf("no matching call after %s", timeout)
+		}
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// Downloads are /file/bot<token>/<file path given by getFile>
+	if path, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+Token+"/"); ok {
+		s.mu.Lock()
+		data, found := s.files[strings.TrimPrefix(path, "documents/")]
+		s.mu.Unlock()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+		return
+	}
+
+	// /bot<token>/<method>
+	path := strings.TrimPrefix(r.URL.Path, "/bot")
+	token, method, ok := strings.Cut(path, "/")
+	if !ok || token != Token {
+		reply(w, nil, "Unauthorized")
+		return
+	}
+
+	call, err := readCall(method, r)
+	if err != nil {
+		reply(w, nil, "Bad Request: "+err.Error())
+		return
+	}
+
+	switch method {
+	case "getMe":
+		reply(w, BotUser, "")
+	case "getUpdates":
+		reply(w, s.nextUpdates(call), "")
+	case "sendMessage", "sendPhoto", "sendDocument":
+		reply(w, s.record(call, true), "")
+	case "editMessageText":
+		reply(w, s.record(call, false), "")
+	case "answerCallbackQuery", "setMyCommands", "setWebhook", "deleteWebhook":
+		s.record(call, false)
+		reply(w, true, "")
+	case "getFile":
+		id := call.Params["file_id"]
+		s.mu.Lock()
+		data, found := s.files[id]
+		s.mu.Unlock()
+		if !found {
+			reply(w, nil, "Bad Request: invalid file_id")
+			return
+		}
+		reply(w, telebot.File{FileID: id, FileSize: len(data), FilePath: "documents/" + id}, "")
+	case "getChatMember":
+		id, _ := strconv.Atoi(call.Params["user_id"])
+		reply(w, telebot.ChatMember{User: &telebot.User{ID: id}, Role: telebot.Member}, "")
+	default:
+		reply(w, nil, "Not Found: method not found")
+	}
+}
+
+// nextUpdates answers getUpdates, waiting up to the timeout for updates
+// from the offset on like Telegram does.
+func (s *Server) nextUpdates(call Call) []telebot.Update {
+	offset, _ := strconv.Atoi(call.Params["offset"])
+	seconds, _ := strconv.Atoi(call.Params["timeout"])
+	deadline := time.After(time.Duration(seconds) * time.Second)
+
+	for {
+		s.mu.Lock()
+		var pending []telebot.Update
+		for _, u := range s.updates {
+			if u.ID >= offset {
+				pending = append(pending, u)
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(pending) > 0 {
+			return pending
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return []telebot.Update{}
+		}
+	}
+}
+
+// record stores the call and returns the message it sends or edits.
+func (s *Server) record(call Call, send bool) *telebot.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := &telebot.Chat{ID: call.ChatID()}
+	m := &telebot.Message{Sender: &BotUser, Chat: chat, Text: call.Text(), Unixtime: time.Now().Unix()}
+	if send {
+		s.lastID++
+		m.ID = s.lastID
+		call.Params["message_id"] = strconv.Itoa(m.ID)
+	} else {
+		m.ID, _ = strconv.Atoi(call.Params["message_id"])
+	}
+
+	switch call.Method {
+	case "sendPhoto":
+		m.Photo = &telebot.Photo{File: telebot.File{FileID: "photo" + strconv.Itoa(m.ID)}}
+	case "sendDocument":
+		m.Document = &telebot.Document{File: telebot.File{FileID: "document" + strconv.Itoa(m.ID)}, FileName: call.File}
+	}
+
+	s.calls = append(s.calls, call)
+	s.notify()
+	return m
+}
+
+// readCall reads the parameters, sent as JSON by most methods and as a
+// multipart form when uploading a file.
+func readCall(method string, r *http.Request) (Call, error) {
+	call := Call{Method: method, Params: map[string]string{}}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return call, err
+		}
+		for key, values := range r.MultipartForm.Value {
+			call.Params[key] = values[0]
+		}
+		for _, files := range r.MultipartForm.File {
+			file, err := files[0].Open()
+			if err != nil {
+				return call, err
+			}
+			call.File = files[0].Filename
+			call.Data, err = io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return call, err
+			}
+		}
+		return call, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil || len(body) == 0 || string(body) == "null\n" {
+		return call, err
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return call, err
+	}
+	for key, raw := range params {
+		var text string
+		if json.Unmarshal(raw, &text) == nil {
+			call.Params[key] = text
+		} else {
+			call.Params[key] = string(raw)
+		}
+	}
+	return call, nil
+}
+
+func reply(w http.ResponseWriter, result interface{}, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{"ok": description == ""}
+	if description != "" {
+		response["description"] = description
+	} else {
+		response["result"] = result
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,48 @@
+package telegramtest
+
+import (
+	"github.com/tucnak/telebot"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSendFiles(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	bot, err := s.NewBot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot.Me.Username != BotUser.Username {
+		t.Errorf("getMe = %q, want %q", bot.Me.Username, BotUser.Username)
+	}
+
+	path := filepath.Join(t.TempDir(), "purchases.csv")
+	if err := os.WriteFile(path, []byte("1001,lan,35000,coffee,2024-12-01\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chat := &telebot.Chat{ID: 1001}
+	if _, err := bot.Send(chat, &telebot.Document{File: telebot.FromDisk(path), Caption: "Export"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.Send(chat, &telebot.Photo{File: telebot.FromDisk(path)}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := s.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	document := calls[0]
+	if document.Method != "sendDocument" || document.File != "purchases.csv" || document.Text() != "Export" || document.ChatID() != 1001 {
+		t.Errorf("document call = %+v", document)
+	}
+	if string(document.Data) != "1001,lan,35000,coffee,2024-12-01\n" {
+		t.Errorf("uploaded %q", document.Data)
+	}
+	if calls[1].Method != "sendPhoto" {
+		t.Errorf("second call is %s, want sendPhoto", calls[1].Method)
+	}
+}
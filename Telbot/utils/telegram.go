@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"Telbot/metrics"
+	"github.com/tucnak/telebot"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	sendErrors = metrics.NewCounter("telbot_send_errors_total",
+		"Calls to the Telegram API that failed, by method.", "method")
+	sendRetries = metrics.NewCounter("telbot_send_retries_total",
+		"Calls to the Telegram API retried after 429 Too Many Requests, by method.", "method")
+)
+
+// Send sends a message and logs when Telegram refuses it, e.g. because the
+// user blocked the bot or the text is too long.
+func Send(bot *telebot.Bot, to telebot.Recipient, what interface{}, options ...interface{}) *telebot.Message {
+	var m *telebot.Message
+	err := queued(to.Recipient(), "send", func() (err error) {
+		m, err = bot.Send(to, what, options...)
+		return err
+	})
+	if err != nil {
+		sendErrors.Inc("send")
+		slog.Error("send failed", "chat_id", to.Recipient(), "err", err)
+	}
+	return m
+}
+
+// Edit edits a message and logs failures.
+func Edit(bot *telebot.Bot, message telebot.Editable, what interface{}, options ...interface{}) *telebot.Message {
+	var m *telebot.Message
+	_, chatID := message.MessageSig()
+	err := queued(strconv.FormatInt(chatID, 10), "edit", func() (err error) {
+		m, err = bot.Edit(message, what, options...)
+		return err
+	})
+	if err != nil {
+		sendErrors.Inc("edit")
+		slog.Error("edit failed", "chat_id", chatID, "err", err)
+	}
+	return m
+}
+
+// Respond answers a callback query and logs failures.
+func Respond(bot *telebot.Bot, c *telebot.Callback, response ...*telebot.CallbackResponse) {
+	// Answers to presses on inline messages have no chat, they queue with the
+	// presser's private chat
+	var chat string
+	switch {
+	case c.Message != nil && c.Message.Chat != nil:
+		chat = c.Message.Chat.Recipient()
+	case c.Sender != nil:
+		chat = c.Sender.Recipient()
+	}
+	err := queued(chat, "respond", func() error {
+		return bot.Respond(c, response...)
+	})
+	if err != nil {
+		sendErrors.Inc("respond")
+		slog.Error("callback response failed", "callback_id", c.ID, "err", err)
+	}
+}
+
+// Outgoing calls go through a queue per chat, in order. When Telegram
+// answers 429 Too Many Requests the chat's queue waits the "retry after" it
+// asks for and tries again, so the messages behind wait too instead of being
+// refused, while other chats carry on.
+const (
+	maxSendAttempts = 4
+	maxRetryAfter   = time.Minute // Longer waits give up on the message
+)
+
+type sendJob struct {
+	method string
+	call   func() error
+	done   chan error
+}
+
+var (
+	queuesMu sync.Mutex
+	queues   = map[string][]sendJob{} // Waiting calls by chat, the first is running
+
+	// sleep waits between retries, tests replace it.
+	sleep = time.Sleep
+)
+
+// queued runs the call after the earlier calls to the chat. A chat's worker
+// stops once its queue is empty.
+func queued(chat, method string, call func() error) error {
+	job := sendJob{method: method, call: call, done: make(chan error, 1)}
+
+	queuesMu.Lock()
+	idle := len(queues[chat]) == 0
+	queues[chat] = append(queues[chat], job)
+	queuesMu.Unlock()
+
+	if idle {
+		go work(chat)
+	}
+	return <-job.done
+}
+
+func work(chat string) {
+	for {
+		queuesMu.Lock()
+		job := queues[chat][0]
+		queuesMu.Unlock()
+
+		job.done <- withRetry(job.method, job.call)
+
+		queuesMu.Lock()
+		rest := queues[chat][1:]
+		if len(rest) == 0 {
+			delete(queues, chat)
+			queuesMu.Unlock()
+			return
+		}
+		queues[chat] = rest
+		queuesMu.Unlock()
+	}
+}
+
+func withRetry(method string, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		wait, limited := retryAfter(err)
+		if !limited || attempt == maxSendAttempts || wait > maxRetryAfter {
+			return err
+		}
+		sendRetries.Inc(method)
+		slog.Warn("rate limited by Telegram", "method", method, "retry_after", wait, "attempt", attempt)
+		sleep(wait)
+	}
+}
+
+// telebot only keeps the description of API errors, e.g.
+// "api error: Too Many Requests: retry after 35".
+var retryAfterPattern = regexp.MustCompile(`Too Many Requests: retry after (\d+)`)
+
+func retryAfter(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	match := retryAfterPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	seconds, _ := strconv.Atoi(match[1])
+	return time.Duration(seconds) * time.Second, true
+}
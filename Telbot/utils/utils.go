@@ -2,6 +2,13 @@ package utils
 
 import (
 	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 func FormatNumber(amount int) string {
@@ -20,3 +27,103 @@ func FormatNumber(amount int) string {
 		return fmt.Sprintf("%d", amount)
 	}
 }
+
+// Sentence writes an error as a reply to the user: "no category named food"
+// becomes "No category named food.".
+func Sentence(err error) string {
+	text := err.Error()
+	if text == "" {
+		return text
+	}
+	first, size := utf8.DecodeRuneInString(text)
+	text = string(unicode.ToUpper(first)) + text[size:]
+	if !strings.HasSuffix(text, ".") && !strings.HasSuffix(text, "?") && !strings.HasSuffix(text, "!") {
+		text += "."
+	}
+	return text
+}
+
+// ParseAmount parses an amount such as "35K", "2M", "1.5tr", "52.000đ" or
+// "15000". K stands for thousand, M and tr for million, and the dong sign
+// or "vnd" may follow the number.
+func ParseAmount(s string) (int, error) {
+	amountString := strings.ToLower(strings.TrimSpace(s))
+	for _, currency := range []string{"vnd", "vnđ", "đ"} {
+		amountString = strings.TrimSuffix(amountString, currency)
+	}
+	multiplier := 1
+
+	if strings.HasSuffix(amountString, "k") {
+		multiplier = 1000
+		amountString = strings.TrimSuffix(amountString, "k")
+	} else if strings.HasSuffix(amountString, "m") {
+		multiplier = 1000000
+		amountString = strings.TrimSuffix(amountString, "m")
+	} else if strings.HasSuffix(amountString, "tr") {
+		multiplier = 1000000
+		amountString = strings.TrimSuffix(amountString, "tr")
+	}
+
+	if amount, err := strconv.Atoi(amountString); err == nil {
+		return scale(s, amount, multiplier)
+	}
+
+	// "52.000" and "52,000" group thousands
+	if thousandsRx.MatchString(amountString) {
+		amount, err := strconv.Atoi(strings.NewReplacer(".", "", ",", "").Replace(amountString))
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+		return scale(s, amount, multiplier)
+	}
+
+	// "1.5M" or "1,5tr" are decimals of the unit
+	if multiplier > 1 && decimalRx.MatchString(amountString) {
+		value, err := strconv.ParseFloat(strings.Replace(amountString, ",", ".", 1), 64)
+		value = math.Round(value * float64(multiplier))
+		if err == nil && value < math.MaxInt && value > math.MinInt {
+			return int(value), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid amount %q", s)
+}
+
+// scale multiplies the amount by the unit, refusing amounts that overflow.
+func scale(s string, amount, multiplier int) (int, error) {
+	if amount > math.MaxInt/multiplier || amount < math.MinInt/multiplier {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return amount * multiplier, nil
+}
+
+var (
+	thousandsRx = regexp.MustCompile(`^-?\d{1,3}([.,]\d{3})+$`)
+	decimalRx   = regexp.MustCompile(`^-?\d+([.,]\d+)?$`)
+)
+
+// ParseDate parses a day such as "2024-11-30", "30/11/2024", "30/11",
+// "today", "tomorrow" or "yesterday" (also in Vietnamese) relative to now.
+func ParseDate(s string, now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "today", "hôm nay", "hom nay":
+		return today, nil
+	case "tomorrow", "ngày mai", "ngay mai":
+		return today.AddDate(0, 0, 1), nil
+	case "yesterday", "hôm qua", "hom qua":
+		return today.AddDate(0, 0, -1), nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "2/1/2006"} {
+		if date, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return date, nil
+		}
+	}
+	for _, layout := range []string{"2/1"} {
+		if date, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return date.AddDate(now.Year(), 0, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
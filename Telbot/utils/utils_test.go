@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseAmount(t *testing.T) {
+	cases := map[string]int{
+		"35K":       35_000,
+		"35k":       35_000,
+		"2M":        2_000_000,
+		"15000":     15_000,
+		"52.000đ":   52_000,
+		"1,200,000": 1_200_000,
+		"1.5M":      1_500_000,
+		"2tr":       2_000_000,
+		"-50K":      -50_000,
+	}
+	for input, expected := range cases {
+		amount, err := ParseAmount(input)
+		if err != nil {
+			t.Errorf("ParseAmount(%q) returned error: %v", input, err)
+			continue
+		}
+		if amount != expected {
+			t.Errorf("ParseAmount(%q) = %d, expected %d", input, amount, expected)
+		}
+	}
+
+	for _, input := range []string{"abc", "1.5", "coffee", "infk", "nank", "-infM", "1e3k", "0x10k", "9223372036854775807k", "99999999999999999999M"} {
+		if _, err := ParseAmount(input); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	var waited []time.Duration
+	sleep = func(d time.Duration) { waited = append(waited, d) }
+	defer func() { sleep = time.Sleep }()
+
+	calls := 0
+	err := withRetry("send", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("api error: Too Many Requests: retry after 5")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("withRetry = %v after %d calls, want success after 3", err, calls)
+	}
+	if len(waited) != 2 || waited[0] != 5*time.Second {
+		t.Errorf("waited %v, want 5s twice", waited)
+	}
+
+	// Other errors are not retried
+	calls = 0
+	withRetry("send", func() error {
+		calls++
+		return errors.New("api error: Forbidden: bot was blocked by the user")
+	})
+	if calls != 1 {
+		t.Errorf("retried a refused message %d times", calls-1)
+	}
+}
+
+func TestQueuedPerChat(t *testing.T) {
+	// The first chat is rate limited until released
+	release := make(chan struct{})
+	sleep = func(time.Duration) { <-release }
+	defer func() { sleep = time.Sleep }()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, call)
+	}
+
+	limited := true
+	first := make(chan error)
+	go func() {
+		first <- queued("1", "send", func() error {
+			if limited {
+				limited = false
+				record("1 limited")
+				return errors.New("api error: Too Many Requests: retry after 5")
+			}
+			record("1 sent")
+			return nil
+		})
+	}()
+	// Wait until the first chat's worker is sleeping
+	for {
+		mu.Lock()
+		n := len(order)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan error)
+	go func() {
+		second <- queued("1", "send", func() error { record("1 second"); return nil })
+	}()
+	if err := queued("2", "send", func() error { record("2 sent"); return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Errorf("the retried call failed: %v", err)
+	}
+	if err := <-second; err != nil {
+		t.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"1 limited", "2 sent", "1 sent", "1 second"}
+	if len(order) != len(want) {
+		t.Fatalf("calls %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("calls %v, want %v", order, want)
+		}
+	}
+}
+
+func TestSentence(t *testing.T) {
+	cases := map[string]string{
+		"no category named food":               "No category named food.",
+		"@binh is listed twice":                "@binh is listed twice.",
+		"please pick one: week, month or year": "Please pick one: week, month or year.",
+		"already a sentence.":                  "Already a sentence.",
+		"đã lưu":                               "Đã lưu.",
+	}
+	for input, want := range cases {
+		if got := Sentence(errors.New(input)); got != want {
+			t.Errorf("Sentence(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package webhook receives updates pushed by Telegram instead of polling
+// for them.
+//
+// To try it locally, run the bot with TELBOT_MODE=webhook and post a
+// sample update:
+//
+//	curl -H "X-Telegram-Bot-Api-Secret-Token: $TELBOT_WEBHOOK_SECRET" \
+//	     -d @webhook/testdata/update.json http://localhost:8443/telegram
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/tucnak/telebot"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// SecretHeader carries the secret token given to setWebhook on every update.
+const SecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxUpdateSize bounds the request body, updates are a few kilobytes.
+const maxUpdateSize = 1 << 20
+
+// Poller is a telebot.Poller serving an HTTP endpoint Telegram posts
+// updates to.
+type Poller struct {
+	Listen    string // Address to listen on, e.g. ":8443"
+	Path      string // Endpoint path, e.g. "/telegram"
+	Secret    string // Expected secret token, empty to accept any update
+	CertFile  string // TLS certificate, plain HTTP when empty (e.g. behind a proxy)
+	KeyFile   string
+	PublicURL string // When set, registered with setWebhook on start
+
+	// Ready, when set, is called with true once the server listens and with
+	// false when it stops serving.
+	Ready func(ready bool)
+}
+
+// Poll serves the endpoint until the bot stops.
+func (p *Poller) Poll(b *telebot.Bot, updates chan telebot.Update, stop chan struct{}) {
+	if p.PublicURL != "" {
+		if err := p.register(b); err != nil {
+			slog.Error("failed to set the webhook", "url", p.PublicURL, "err", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(p.Path, Handler(p.Secret, updates))
+	server := &http.Server{
+		Addr:              p.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", p.Listen)
+	if err != nil {
+		slog.Error("webhook server failed to listen", "listen", p.Listen, "err", err)
+	} else {
+		p.setReady(true)
+		go func() {
+			var err error
+			if p.CertFile != "" {
+				err = server.ServeTLS(listener, p.CertFile, p.KeyFile)
+			} else {
+				err = server.Serve(listener)
+			}
+			p.setReady(false)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("webhook server stopped", "listen", p.Listen, "err", err)
+			}
+		}()
+	}
+
+	<-stop
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+	close(stop)
+}
+
+func (p *Poller) setReady(ready bool) {
+	if p.Ready != nil {
+		p.Ready(ready)
+	}
+}
+
+// register points Telegram at the endpoint.
+func (p *Poller) register(b *telebot.Bot) error {
+	params := map[string]string{"url": p.PublicURL}
+	if p.Secret != "" {
+		params["secret_token"] = p.Secret
+	}
+
+	data, err := b.Raw("setWebhook", params)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return errors.New(resp.Description)
+	}
+	return nil
+}
+
+// Handler decodes updates posted to it and passes them on. Requests without
+// the right secret token are refused.
+func Handler(secret string, updates chan<- telebot.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretHeader)), []byte(secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var update telebot.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+			// Telegram retries updates that weren't acknowledged
+		}
+	})
+}
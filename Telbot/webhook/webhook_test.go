@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"bytes"
+	"github.com/tucnak/telebot"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	sample, err := os.ReadFile("testdata/update.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan telebot.Update, 1)
+	handler := Handler("s3cret", updates)
+
+	post := func(secret string, body []byte) int {
+		req := httptest.NewRequest(http.MethodPost, "/telegram", bytes.NewReader(body))
+		if secret != "" {
+			req.Header.Set(SecretHeader, secret)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("", sample); code != http.StatusForbidden {
+		t.Errorf("without secret: got %d, want 403", code)
+	}
+	if code := post("wrong", sample); code != http.StatusForbidden {
+		t.Errorf("wrong secret: got %d, want 403", code)
+	}
+	if code := post("s3cret", []byte("{not json")); code != http.StatusBadRequest {
+		t.Errorf("bad JSON: got %d, want 400", code)
+	}
+	if len(updates) != 0 {
+		t.Fatal("a refused request was passed on")
+	}
+
+	if code := post("s3cret", sample); code != http.StatusOK {
+		t.Fatalf("valid update: got %d, want 200", code)
+	}
+	update := <-updates
+	if update.ID != 100000001 || update.Message == nil || update.Message.Text != "/purchase 35k coffee" {
+		t.Errorf("decoded update = %+v", update)
+	}
+}
+
+func TestPollReady(t *testing.T) {
+	ready := make(chan bool, 2)
+	p := &Poller{Listen: "127.0.0.1:0", Path: "/telegram", Ready: func(r bool) { ready <- r }}
+	stop := make(chan struct{})
+	go p.Poll(nil, make(chan telebot.Update), stop)
+
+	if !<-ready {
+		t.Fatal("expected ready once listening")
+	}
+	stop <- struct{}{}
+	<-stop // Closed once shut down
+	if <-ready {
+		t.Error("expected not ready once stopped")
+	}
+
+	// Never ready when the address is taken
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	p.Listen = taken.Addr().String()
+	stop = make(chan struct{})
+	go p.Poll(nil, make(chan telebot.Update), stop)
+	stop <- struct{}{}
+	<-stop
+	if len(ready) != 0 {
+		t.Error("reported ready without listening")
+	}
+}